@@ -6,20 +6,88 @@ package BlockInfo
 
 import (
 	"bytes"
-	"encoding/gob"
-	"log"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"time"
 )
 
-//定义区块Block结构体
+//区块版本号
+const blockVersion = 1
+//区块头序列化后的长度：版本(4) + 前一区块哈希(32) + Merkle根(32) + 时间戳(8) + 目标值(4) + nonce(4) + 高度(4)
+const blockHeaderLen = 88
+//nonce在区块头序列化数据中的偏移量，挖矿时只需修改这4个字节
+const nonceOffset = 80
+
+/*
+	区块头结构体
+	工作量证明只对区块头进行哈希，交易通过Merkle根与区块头绑定
+ */
+type BlockHeader struct {
+	Version       int32
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	Timestamp     int64
+	Bits          uint32    //紧凑格式表示的目标值
+	Nonce         uint32
+	Height        int
+}
+
+//定义区块Block结构体，由区块头、区块哈希和交易组成
 type Block struct {
-	Timestamp 	  int64
-	Nonce         int
+	BlockHeader
+	Hash          []byte
 	//Data          []byte
 	Transactions  []*Transaction
-	PrevBlockHash []byte
-	Hash          []byte
-	Height		  int
+}
+
+/*
+	将区块头按固定格式序列化，多字节整数都采用小端序，哈希固定为32个字节（创世块的前一区块哈希为全0）
+	| version 4 | prevBlockHash 32 | merkleRoot 32 | timestamp 8 | bits 4 | nonce 4 | height 4 |
+ */
+func (h *BlockHeader) Serialize() []byte {
+	data := make([]byte, blockHeaderLen)
+
+	binary.LittleEndian.PutUint32(data[0:4], uint32(h.Version))
+	copy(data[4:36], h.PrevBlockHash)
+	copy(data[36:68], h.MerkleRoot)
+	binary.LittleEndian.PutUint64(data[68:76], uint64(h.Timestamp))
+	binary.LittleEndian.PutUint32(data[76:80], h.Bits)
+	binary.LittleEndian.PutUint32(data[80:84], h.Nonce)
+	binary.LittleEndian.PutUint32(data[84:88], uint32(h.Height))
+
+	return data
+}
+
+//将固定格式的区块头数据解析为区块头结构体
+func DeserializeBlockHeader(data []byte) (*BlockHeader, error) {
+	if len(data) != blockHeaderLen {
+		return nil, errors.New("invalid block header length")
+	}
+
+	h := &BlockHeader{
+		int32(binary.LittleEndian.Uint32(data[0:4])),
+		append([]byte{}, data[4:36]...),
+		append([]byte{}, data[36:68]...),
+		int64(binary.LittleEndian.Uint64(data[68:76])),
+		binary.LittleEndian.Uint32(data[76:80]),
+		binary.LittleEndian.Uint32(data[80:84]),
+		int(binary.LittleEndian.Uint32(data[84:88])),
+	}
+
+	//全0的前一区块哈希表示创世块，还原为空，与区块链中判断创世块的方式一致
+	if bytes.Equal(h.PrevBlockHash, make([]byte, 32)) {
+		h.PrevBlockHash = []byte{}
+	}
+
+	return h, nil
+}
+
+//区块头的哈希即区块哈希
+func (h *BlockHeader) Hash() []byte {
+	hash := sha256.Sum256(h.Serialize())
+
+	return hash[:]
 }
 
 //计算当前区块的哈希值
@@ -39,29 +107,52 @@ func (block *Block) SetHash()  {
 }
  */
 
-//根据data、前一个区块哈希进行工作量证明，创建一个新的区块
-func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int) *Block  {
+//根据data、前一个区块哈希和目标值bits进行工作量证明，创建一个新的区块
+func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int, bits uint32) *Block  {
+	return newBlockAt(transactions, prevBlockHash, height, bits, time.Now().Unix())
+}
+
+//以timestamp为时间戳生成区块并进行工作量证明
+func newBlockAt(transactions []*Transaction, prevBlockHash []byte, height int, bits uint32, timestamp int64) *Block {
+	block := newUnminedBlock(transactions, prevBlockHash, height, bits, timestamp)
+	block.mine()
+
+	return block
+}
+
+//以timestamp为时间戳生成还没有进行工作量证明的区块，区块哈希为空
+func newUnminedBlock(transactions []*Transaction, prevBlockHash []byte, height int, bits uint32, timestamp int64) *Block {
 	block := &Block{
-		time.Now().Unix(),
-		0,
-		transactions,
-		prevBlockHash,
+		BlockHeader{
+			blockVersion,
+			prevBlockHash,
+			nil,
+			timestamp,
+			bits,
+			0,
+			height,
+		},
 		[]byte{},
-		height,
+		transactions,
 	}
+	//Merkle根只在创建区块时计算一次，挖矿过程只对区块头进行哈希
+	block.MerkleRoot = block.HashTransactions()
+
+	return block
+}
 
+//对区块进行工作量证明，设置区块的Nonce和哈希
+func (block *Block) mine() {
 	pow := NewProofOfWork(block)
 	nonce, hash := pow.Run()
 
 	block.Hash = hash[:]
 	block.Nonce = nonce
-
-	return block
 }
 
 //创建创世纪区块
 func NewGenesisBlock(coinbase *Transaction) *Block {
-	return NewBlock([]*Transaction{coinbase}, []byte{},0)
+	return NewBlock([]*Transaction{coinbase}, []byte{},0, genesisBits)
 }
 
 /*
@@ -93,28 +184,53 @@ func (b *Block) HashTransactions() []byte {
 	return mTree.RootNode.Data
 }
 
-//将Block区块结构序列化为[]byte
+//将Block区块结构按规范二进制格式（见encoding.go）序列化为[]byte：区块头 | 区块体
 func (b *Block) Serialize() []byte {
 	var result bytes.Buffer
-	encoder := gob.NewEncoder(&result)
 
-	err := encoder.Encode(b)
+	result.Write(b.BlockHeader.Serialize())
+	encodeTransactions(&result, b.Transactions)
+
+	return result.Bytes()
+}
+
+//将[]byte内容进行解序列化，返回对应的Block区块结构
+func DeserializeBlock(d []byte) (*Block, error) {
+	var block *Block
+
+	err := decodeAll(d, func(r *bytes.Reader) error {
+		var err error
+		block, err = decodeBlock(r)
+		return err
+	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
+	return block, nil
+}
+
+//将区块体（区块中的交易）序列化为[]byte：varint(交易数) | Transaction...，与区块头分开保存进数据库
+func (b *Block) SerializeBody() []byte {
+	var result bytes.Buffer
+
+	encodeTransactions(&result, b.Transactions)
+
 	return result.Bytes()
 }
 
-//将[]byte内容进行解序列化，返回对应的Block区块结构
-func DeserializeBlock(d []byte) *Block  {
-	var block Block
+//根据区块头和区块体数据还原区块
+func NewBlockFromParts(header *BlockHeader, body []byte) (*Block, error) {
+	var transactions []*Transaction
 
-	decoder := gob.NewDecoder(bytes.NewReader(d))
-	err := decoder.Decode(&block)
+	err := decodeAll(body, func(r *bytes.Reader) error {
+		var err error
+		transactions, err = decodeTransactions(r)
+		return err
+	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	return &block
+	return &Block{*header, header.Hash(), transactions}, nil
 }
\ No newline at end of file
@@ -6,15 +6,17 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/boltdb/bolt"
-	"log"
 	"os"
+	"time"
 )
 
 const dbFile = "blockchain_%s.db"
 const blocksBucket  = "blocks"
+const headersBucket  = "headers"
 const genesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
 
+var ErrBlockNotFound = notFound("block")
+
 //定义区块链Blockchain结构体
 /*
 	版本1 区块链包含区块分组
@@ -27,7 +29,7 @@ type Blockchain struct {
 // 通过结合tip和Db就可以对区块链进行操作，包括添加区块、遍历整个区块
 type Blockchain struct {
 	tip []byte
-	Db  *bolt.DB
+	Db  Store
 }
 
 // 判断区块链数据库是否存在
@@ -118,83 +120,206 @@ func NewBlockChain() *Blockchain {
 }
 */
 
-// 从当前数据库dbFile-blockchain4go.db构建区块链实例（指向最后一个区块的tip和数据库连接db）
-func GetBlockchain4db(nodeID string) *Blockchain {
+// 从当前数据库dbFile-blockchain4go.db构建区块链实例（指向最后一个区块的tip和数据库连接db），数据库不存在时返回ErrChainNotFound
+func GetBlockchain4db(nodeID string) (*Blockchain, error) {
 	dbFile := fmt.Sprintf(dbFile, nodeID)
 	if dbExists(dbFile) == false {
-		fmt.Println("No existing blockchain found. Create one first.")
-		os.Exit(1)
+		return nil, ErrChainNotFound
 	}
 
-	var tip []byte
-	db, err := bolt.Open(dbFile, 0600, nil)
+	store, err := OpenBoltStore(dbFile)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
+	bc, err := OpenBlockchain(store)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	return bc, nil
+}
+
+// 从存储后端store中构建区块链实例，store中没有区块链时返回ErrChainNotFound
+func OpenBlockchain(store Store) (*Blockchain, error) {
+	var tip []byte
+
+	err := store.Update(func(tx StoreTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		tip = b.Get([]byte("l"))
+		if b == nil {
+			return ErrChainNotFound
+		}
+		//存储返回的值只在事务内有效，需要复制一份
+		tip = append([]byte{}, b.Get([]byte("l"))...)
 
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
 
+	bc := Blockchain{tip, store}
+	err = bc.ensureIndexes()
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	bc := Blockchain{tip, db}
-	return &bc
+	return &bc, nil
+}
+
+//为旧数据库建立缺少的区块索引、地址索引和高度索引，并检查UTXO集与链尾是否一致
+func (bc *Blockchain) ensureIndexes() error {
+	err := bc.ensureBlockIndex()
+	if err != nil {
+		return err
+	}
+
+	err = bc.ensureAddrIndex()
+	if err != nil {
+		return err
+	}
+
+	err = bc.ensureHeightIndex()
+	if err != nil {
+		return err
+	}
+
+	return bc.ensureUTXOSet()
 }
 
 /*
 	创建一个只包含创世区块的区块链实例，并生成区块链对应的数据库
-	并将创世纪块的奖励给地址address
+	并将创世纪块的奖励给地址address，数据库已存在时返回ErrChainExists
 */
-func CreateBlockchain(address, nodeID string) *Blockchain  {
+func CreateBlockchain(address, nodeID string) (*Blockchain, error) {
+	if !ValidForAddress(address) {
+		return nil, ErrInvalidAddress
+	}
+
+	return createBlockchainFile(nodeID, func(store Store) (*Blockchain, error) {
+		return CreateBlockchainInStore(address, store)
+	})
+}
+
+//以给定的创世区块创建节点的区块链数据库，用于导入其他节点导出的区块链，数据库已存在时返回ErrChainExists
+func CreateBlockchainWithGenesis(genesis *Block, nodeID string) (*Blockchain, error) {
+	return createBlockchainFile(nodeID, func(store Store) (*Blockchain, error) {
+		return CreateBlockchainWithGenesisInStore(genesis, store)
+	})
+}
+
+//创建节点的数据库文件并由create在其中创建区块链，创建失败时删除数据库文件，使之后可以重新创建
+func createBlockchainFile(nodeID string, create func(store Store) (*Blockchain, error)) (*Blockchain, error) {
 	dbFile := fmt.Sprintf(dbFile, nodeID)
 	if dbExists(dbFile) {
-		fmt.Println("Blockchain already exists.")
-		os.Exit(1)
+		return nil, ErrChainExists
 	}
 
-	var tip []byte
-	db, err := bolt.Open(dbFile, 0600, nil)
+	store, err := OpenBoltStore(dbFile)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	err = db.Update(func(tx *bolt.Tx) error {
-		cbtx := NewCoinbaseTX(address, genesisCoinbaseData)
-		genesis := NewGenesisBlock(cbtx)
+	bc, err := create(store)
+	if err != nil {
+		store.Close()
+		os.Remove(dbFile)
+		return nil, err
+	}
 
+	return bc, nil
+}
+
+/*
+	在存储后端store中创建只包含创世区块的区块链，store中已有区块链时返回ErrChainExists
+	创世区块、区块索引、高度索引和只包含创世区块奖励的UTXO集在同一个事务中写入
+*/
+func CreateBlockchainInStore(address string, store Store) (*Blockchain, error) {
+	if !ValidForAddress(address) {
+		return nil, ErrInvalidAddress
+	}
+
+	//挖创世区块之前先检查，避免无用的工作量证明
+	err := store.View(func(tx StoreTx) error {
+		if tx.Bucket([]byte(blocksBucket)) != nil {
+			return ErrChainExists
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cbtx, err := NewCoinbaseTX(address, genesisCoinbaseData, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return CreateBlockchainWithGenesisInStore(NewGenesisBlock(cbtx), store)
+}
+
+/*
+	在存储后端store中以给定的创世区块创建区块链，store中已有区块链时返回ErrChainExists
+	创世区块必须是高度为0、没有父区块、使用初始难度的区块，并通过CheckBlock
+*/
+func CreateBlockchainWithGenesisInStore(genesis *Block, store Store) (*Blockchain, error) {
+	if genesis.Height != 0 || len(genesis.PrevBlockHash) != 0 {
+		return nil, invalidBlock(genesis, ErrNotGenesisBlock)
+	}
+	if genesis.Bits != genesisBits {
+		return nil, invalidBlock(genesis, ErrBadDifficulty)
+	}
+	err := CheckBlock(genesis)
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.Update(func(tx StoreTx) error {
 		b, err := tx.CreateBucket([]byte(blocksBucket))
+		if err == ErrBucketExists {
+			return ErrChainExists
+		}
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 
-		err = b.Put(genesis.Hash, genesis.Serialize())
+		err = putBlock(tx, genesis)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 
 		err = b.Put([]byte("l"), genesis.Hash)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 
-		tip = genesis.Hash
+		err = putBlockIndex(tx, newBlockIndex(genesis, nil))
+		if err != nil {
+			return err
+		}
 
-		return nil
-	})
+		err = putHeightIndex(tx, 0, genesis.Hash)
+		if err != nil {
+			return err
+		}
 
+		for _, bucketName := range []string{utxoBucket, addrIndexBucket} {
+			_, err = tx.CreateBucket([]byte(bucketName))
+			if err != nil {
+				return err
+			}
+		}
+		return connectUTXO(tx, genesis)
+	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	bc := Blockchain{tip, db}
+	bc := Blockchain{genesis.Hash, store}
 
-	return &bc
+	return &bc, nil
 }
 
 // 返回当前区块链实例下地址对应的公钥哈希下所有的未花费的交易
@@ -203,14 +328,17 @@ func CreateBlockchain(address, nodeID string) *Blockchain  {
 // 3、对每笔交易的输出进行判断，看公钥哈希是否对应其锁定脚本，
 //  同时找出每笔交易的输入是否有地址公钥哈希的解锁脚本，若有，则收集对应的交易Id和输出索引号，即已花费的输出
 //  最后若每笔输出不在已花费的输出中，则当前交易的输出存在未花费的输出
-func (bc *Blockchain) FindUnspentTransactions(pubKeyHash []byte) []Transaction {
+func (bc *Blockchain) FindUnspentTransactions(pubKeyHash []byte) ([]Transaction, error) {
 	var unspentTXs []Transaction
 	spentTXOs := make(map[string][]int)
 	bci := bc.Iterator()
 
 	for  {
 		// 从区块链实例中遍历区块
-		block := bci.Next()
+		block, err := bci.Next()
+		if err != nil {
+			return nil, err
+		}
 
 		// 对区块的每笔交易进行遍历
 		for _, tx := range block.Transactions {
@@ -253,41 +381,33 @@ func (bc *Blockchain) FindUnspentTransactions(pubKeyHash []byte) []Transaction {
 		}
 	}
 
-	return unspentTXs
+	return unspentTXs, nil
 }
 
-// 获取当前区块链中所有的UTXO，即返回交易输出TXOutput数组，可用于计算当前地址的余额
-// 1、获取地址对应的公钥哈希下区块链所有未花费的交易
-// 2、遍历所有未花费的交易，收集所有的未花费输出
-func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
-	UTXO := make(map[string]TXOutputs)
-	spentTXOs := make(map[string][]int)
+// 获取当前区块链中所有的UTXO，用于重建UTXO集
+// 1、从链尾向前遍历区块，先遇到花费输出的交易，再遇到创建输出的交易
+// 2、收集所有未被花费的输出，key为 交易ID + 输出索引号（见utxoKey），同时记录创建区块高度和是否是coinbase交易
+func (bc *Blockchain) FindUTXO() (map[string]UTXOEntry, error) {
+	UTXO := make(map[string]UTXOEntry)
+	spentTXOs := make(map[string]bool)
 	bci := bc.Iterator()
 
 	for {
-		block := bci.Next()
+		block, err := bci.Next()
+		if err != nil {
+			return nil, err
+		}
 		for _, tx := range block.Transactions {
-			txID := hex.EncodeToString(tx.ID)
-
-		Outputs:
 			for outIndex, out := range tx.Vout {
-				if spentTXOs[txID] != nil {
-					for _, spentOutIndex := range spentTXOs[txID] {
-						if spentOutIndex == outIndex {
-							continue Outputs
-						}
-					}
+				key := string(utxoKey(tx.ID, outIndex))
+				if !spentTXOs[key] {
+					UTXO[key] = UTXOEntry{out, block.Height, tx.IsCoinbase()}
 				}
-
-				outs := UTXO[txID]
-				outs.Outputs = append(outs.Outputs, out)
-				UTXO[txID] = outs
 			}
 
 			if tx.IsCoinbase() == false {
 				for _, in := range tx.Vin {
-					inTXID := hex.EncodeToString(in.Txid)
-					spentTXOs[inTXID] = append(spentTXOs[inTXID], in.VoutIndex)
+					spentTXOs[string(utxoKey(in.Txid, in.VoutIndex))] = true
 				}
 			}
 		}
@@ -296,14 +416,17 @@ func (bc *Blockchain) FindUTXO() map[string]TXOutputs {
 		}
 	}
 
-	return UTXO
+	return UTXO, nil
 }
 
 // 从区块链实例中找到当前地址下公钥哈希中数量为amount的可花费的输出，
 // 返回可花费的币数（ >amount ）和 交易ID与输出索引号数组的映射，用于转账时构建交易输入
-func (bc *Blockchain) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+func (bc *Blockchain) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int, error) {
 	unspentOutputs := make(map[string][]int)
-	unspentTXs := bc.FindUnspentTransactions(pubKeyHash)
+	unspentTXs, err := bc.FindUnspentTransactions(pubKeyHash)
+	if err != nil {
+		return 0, nil, err
+	}
 	accumulated := 0
 
 Work:
@@ -322,7 +445,7 @@ Work:
 		}
 	}
 
-	return accumulated, unspentOutputs
+	return accumulated, unspentOutputs, nil
 }
 
 //增加内容为data的区块到区块链中
@@ -380,36 +503,96 @@ func (bc *Blockchain) AddBlock(data string)  {
 }
 */
 
-func (bc *Blockchain) GetBestHeight() int {
-	var lastBlock Block
+/*
+	在读写事务中保存区块
+	区块头以固定格式保存进headers，区块体（交易）保存进blocks，key都为区块哈希，并累计区块数据占用的空间
+ */
+func putBlock(tx StoreTx, block *Block) error {
+	h, err := tx.CreateBucketIfNotExists([]byte(headersBucket))
+	if err != nil {
+		return err
+	}
+	err = h.Put(block.Hash, block.BlockHeader.Serialize())
+	if err != nil {
+		return err
+	}
 
-	err := bc.Db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		lastHash := b.Get([]byte("l"))
-		blockData := b.Get(lastHash)
-		lastBlock = *DeserializeBlock(blockData)
+	b := tx.Bucket([]byte(blocksBucket))
+	body := block.SerializeBody()
+	err = addStoredSize(tx, len(body)-len(b.Get(block.Hash)))
+	if err != nil {
+		return err
+	}
 
-		return nil
+	return b.Put(block.Hash, body)
+}
+
+//在事务中读取区块头，不存在则返回ErrBlockNotFound
+func getBlockHeader(tx StoreTx, hash []byte) (*BlockHeader, error) {
+	h := tx.Bucket([]byte(headersBucket))
+	if h == nil {
+		return nil, ErrBlockNotFound
+	}
+
+	data := h.Get(hash)
+	if data == nil {
+		return nil, ErrBlockNotFound
+	}
+
+	return DeserializeBlockHeader(data)
+}
+
+//在事务中读取区块头和区块体并组成区块，不存在则返回ErrBlockNotFound，只剩区块头（区块数据已被修剪）时返回ErrBlockPruned
+func getBlock(tx StoreTx, hash []byte) (*Block, error) {
+	header, err := getBlockHeader(tx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	body := tx.Bucket([]byte(blocksBucket)).Get(hash)
+	if body == nil {
+		return nil, ErrBlockPruned
+	}
+
+	return NewBlockFromParts(header, body)
+}
+
+//获取主链最后一个区块的高度，只需读取区块头
+func (bc *Blockchain) GetBestHeight() (int, error) {
+	header, err := bc.GetBlockHeader(bc.tip)
+	if err != nil {
+		return 0, err
+	}
+
+	return header.Height, nil
+}
+
+//获取区块哈希对应的区块头，不需要加载区块中的交易
+func (bc *Blockchain) GetBlockHeader(blockHash []byte) (*BlockHeader, error) {
+	var header *BlockHeader
+
+	err := bc.Db.View(func(tx StoreTx) error {
+		var err error
+		header, err = getBlockHeader(tx, blockHash)
+		return err
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	return lastBlock.Height
+	return header, nil
 }
 
 func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 	var block Block
 
-	err := bc.Db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-
-		blockData := b.Get(blockHash)
-		if blockData == nil {
-			return errors.New("Block is not found.")
+	err := bc.Db.View(func(tx StoreTx) error {
+		b, err := getBlock(tx, blockHash)
+		if err != nil {
+			return err
 		}
 
-		block = *DeserializeBlock(blockData)
+		block = *b
 		return nil
 	})
 	if err != nil {
@@ -419,181 +602,290 @@ func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 	return block, nil
 }
 
-func (bc *Blockchain) AddBlock(block *Block)  {
-	err := bc.Db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		blockInDb := b.Get(block.Hash)
-
-		if blockInDb != nil {
-			return nil
-		}
-
-		blockData := block.Serialize()
-		err := b.Put(block.Hash, blockData)
+//在一个事务中保存区块和区块索引，不修改链尾
+func (bc *Blockchain) storeBlock(block *Block, index *blockIndex) error {
+	return bc.Db.Update(func(tx StoreTx) error {
+		err := putBlock(tx, block)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
 
-		lastHash := b.Get([]byte("l"))
-		lastBlockData := b.Get(lastHash)
-		lastBlock := DeserializeBlock(lastBlockData)
+		return putBlockIndex(tx, index)
+	})
+}
 
-		if block.Height > lastBlock.Height {
-			err = b.Put([]byte("l"), block.Hash)
-			if err != nil {
-				log.Panic(err)
-			}
-			bc.tip = block.Hash
-		}
+/*
+	将从其他节点接收的区块添加到区块链中
+	1、若区块已存在于数据库中，则直接返回（从UTXO快照启动的节点补齐区块数据）
+	2、通过CheckBlock对区块进行不依赖链状态的验证，并检查父区块已知且有效、区块高度连续，
+	   区块声明的Bits与根据祖先区块计算出的难度一致，时间戳晚于前面区块的中位时间
+	3、若区块的父区块就是链尾，则对区块进行完整验证后，将区块、区块索引、链尾和UTXO集在同一个事务中写入
+	4、否则将区块保存进数据库，并建立区块索引（累计工作量 = 父区块累计工作量 + 本区块工作量），侧链区块同样保存
+	5、若区块所在分支的累计工作量超过当前主链，则进行链重组，将该分支切换为主链，
+	   链接的每个区块都会经过完整验证并更新UTXO集
+	6、返回因链重组而被断开的交易，调用方可将其放回交易池
+ */
+func (bc *Blockchain) AddBlock(block *Block) ([]*Transaction, error) {
+	known, err := bc.getBlockIndex(block.Hash)
+	if err != nil {
+		return nil, err
+	}
+	//已知的区块不再处理，从UTXO快照启动的节点在这里补齐快照之前的区块数据
+	if known != nil {
+		return nil, bc.backfillBlock(block)
+	}
 
-		return nil
-	})
+	err = CheckBlock(block)
+	if err != nil {
+		return nil, err
+	}
 
+	parent, err := bc.getBlockIndex(block.PrevBlockHash)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
+	}
+	if parent == nil {
+		return nil, invalidBlock(block, ErrPrevBlockNotFound)
+	}
+	if parent.Invalid {
+		return nil, invalidBlock(block, ErrPrevBlockInvalid)
+	}
+	if block.Height != parent.Height+1 {
+		return nil, invalidBlock(block, ErrBadHeight)
+	}
+	bits, err := bc.nextBits(parent)
+	if err != nil {
+		return nil, err
+	}
+	if block.Bits != bits {
+		return nil, invalidBlock(block, ErrBadDifficulty)
+	}
+	err = checkBlockTime(&block.BlockHeader, parent, bc.getBlockIndex)
+	if err != nil {
+		return nil, invalidBlock(block, err)
 	}
-}
 
-func (bc *Blockchain) GetBlockHashes() [][]byte  {
-	var blocks [][]byte
-	bci := bc.Iterator()
+	index := newBlockIndex(block, parent)
 
-	for {
-		block := bci.Next()
-		blocks = append(blocks, block.Hash)
+	//区块直接延长主链时，区块、区块索引与链尾、UTXO集等的修改都在connectBlock的同一个事务中写入
+	if bytes.Equal(block.PrevBlockHash, bc.tip) {
+		err = bc.connectBlock(block, index)
 
-		if len(block.PrevBlockHash) == 0 {
-			break
+		//验证不通过的区块同样保存，并标记为无效，使其后代区块被拒绝
+		var invalid *BlockValidationError
+		if errors.As(err, &invalid) {
+			index.Invalid = true
+			storeErr := bc.storeBlock(block, index)
+			if storeErr != nil {
+				return nil, storeErr
+			}
 		}
+		return nil, err
 	}
 
-	return blocks
+	err = bc.storeBlock(block, index)
+	if err != nil {
+		return nil, err
+	}
+
+	tipIndex, err := bc.getBlockIndex(bc.tip)
+	if err != nil {
+		return nil, err
+	}
+	if index.totalWork().Cmp(tipIndex.totalWork()) <= 0 {
+		fmt.Printf("Block %x is stored on a side chain\n", block.Hash)
+		return nil, nil
+	}
+
+	return bc.reorganize(index)
 }
 
 /*
 	版本3：根据交易生成区块，并存储进数据库，并更新区块链实例
-	0、对交易进行验证
-		a、只能包含一笔coinbase交易
-		b、验证非coinbase交易的签名
-	1、从区块链实例中的数据库连接获取最后一个区块的哈希
-	2、根据最后一个区块哈希和交易进行区块生成
-	3、成功生成后，将新生成的区块关联到区块的最后
+	1、通过prepareBlock验证交易，并以链尾为父区块生成区块
+	2、对区块进行工作量证明
+	3、成功生成后，将新生成的区块关联到区块的最后，并更新UTXO集
  */
-func (bc *Blockchain) MineBlock(transaction []*Transaction) *Block {
+func (bc *Blockchain) MineBlock(transaction []*Transaction) (*Block, error) {
+	newBlock, err := bc.prepareBlock(transaction)
+	if err != nil {
+		return nil, err
+	}
+	newBlock.mine()
+
+	//通过与接收区块相同的流程保存区块、建立索引、更新key=l和UTXO集
+	_, err = bc.AddBlock(newBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlock, nil
+}
+
+/*
+	验证交易并以当前链尾为父区块生成还没有进行工作量证明的区块
+	1、只能包含一笔coinbase交易，非coinbase交易的签名必须有效
+	2、根据链尾区块的索引计算新区块的高度、难度和时间戳
+ */
+func (bc *Blockchain) prepareBlock(transaction []*Transaction) (*Block, error) {
 	var lastHash []byte
 	var lastHeight int
 
 	coinbaseNum := 0
 	for _, tx := range transaction {
-		if coinbaseNum > 1 {
-			log.Panic("Error：coinbase's transaction ")
-		}
 		if tx.IsCoinbase() {
 			coinbaseNum++
 		}
-		if bc.VerifyTransaction(tx) != true {
-			log.Panic("Error：Invalid transaction")
+		if coinbaseNum > 1 {
+			return nil, ErrMultipleCoinbase
+		}
+		err := bc.VerifyTransaction(tx)
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	//创建一个只读事务，从数据库中获取指向最后区块的哈希
-	err := bc.Db.View(func(tx *bolt.Tx) error {
+	err := bc.Db.View(func(tx StoreTx) error {
 		b := tx.Bucket([]byte(blocksBucket))
-		lastHash = b.Get([]byte("l"))
-
-		blockData := b.Get(lastHash)
-		block := DeserializeBlock(blockData)
+		lastHash = append([]byte{}, b.Get([]byte("l"))...)
 
-		lastHeight = block.Height
+		header, err := getBlockHeader(tx, lastHash)
+		if err != nil {
+			return err
+		}
+		lastHeight = header.Height
 		return nil
 	})
 
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	//通过区块数据+上一个区块哈希来生成一个新的区块
-	newBlock := NewBlock(transaction, lastHash, lastHeight+1)
+	//根据最后一个区块的索引计算新区块的难度
+	lastIndex, err := bc.getBlockIndex(lastHash)
+	if err != nil {
+		return nil, err
+	}
+	if lastIndex == nil {
+		return nil, ErrBlockNotFound
+	}
+	bits, err := bc.nextBits(lastIndex)
+	if err != nil {
+		return nil, err
+	}
+	timestamp, err := bc.nextBlockTime(lastIndex)
+	if err != nil {
+		return nil, err
+	}
 
-	//创建一个读写事务，将生成的区块保存进数据库，并更key=l的内容
-	err = bc.Db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		err := b.Put(newBlock.Hash, newBlock.Serialize())
-		if err != nil {
-			log.Panic(err)
+	return newUnminedBlock(transaction, lastHash, lastHeight+1, bits, timestamp), nil
+}
+
+//父区块parent之后新挖出的区块的时间戳，取当前时间，但至少比前面区块的中位时间晚1秒
+func (bc *Blockchain) nextBlockTime(parent *blockIndex) (int64, error) {
+	mtp, err := medianTimePast(parent, bc.getBlockIndex)
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	if timestamp <= mtp {
+		timestamp = mtp + 1
+	}
+
+	return timestamp, nil
+}
+
+/*
+	获取交易输入所引用的交易id-Transaction映射，用于签名和验证签名
+	签名只涉及输入引用的输出，因此直接从UTXO集中读取这些输出，还原出只包含这些输出的交易，
+	而不需要在区块中查找引用的交易，没有历史区块数据（修剪或从UTXO快照启动）的节点同样可以签名和验证交易
+	引用的输出不在UTXO集中时返回ErrMissingInput
+ */
+func (bc *Blockchain) findPrevTransactions(tx *Transaction) (map[string]Transaction, error) {
+	utxoSet := UTXOSet{bc}
+	prevTXs := make(map[string]Transaction)
+
+	for _, vin := range tx.Vin {
+		out, err := utxoSet.FindOutput(vin.Txid, vin.VoutIndex)
+		if err == ErrOutputNotFound {
+			return nil, ErrMissingInput
 		}
-		err = b.Put([]byte("l"), newBlock.Hash)
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
 
-		//将区块链实例的tip变量进行更新，指向数据库中的最后一个区块的哈希
-		bc.tip = newBlock.Hash
+		addPrevOutput(prevTXs, vin, out)
+	}
 
-		return nil
-	})
+	return prevTXs, nil
+}
 
-	if err != nil {
-		log.Panic(err)
+//将输入vin引用的输出out加入交易id-Transaction映射，还原出的交易只包含被引用的输出
+func addPrevOutput(prevTXs map[string]Transaction, vin TXInput, out TXOutput) {
+	txID := hex.EncodeToString(vin.Txid)
+	prevTX := prevTXs[txID]
+	prevTX.ID = vin.Txid
+	for len(prevTX.Vout) <= vin.VoutIndex {
+		prevTX.Vout = append(prevTX.Vout, TXOutput{})
 	}
-
-	return newBlock
+	prevTX.Vout[vin.VoutIndex] = out
+	prevTXs[txID] = prevTX
 }
 
 /*
 	对交易通过私钥进行签名
 	获取交易输入所引用的交易id-Transaction映射，结合私钥对交易进行签名
  */
-func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey)  {
-	prevTxs :=  make(map[string]Transaction)
-
-	for _, vin := range tx.Vin {
-		prevTx, err := bc.FindTransaction(vin.Txid)
-		if err != nil {
-			log.Panic(err)
-		}
-		prevTxs[hex.EncodeToString(prevTx.ID)] = prevTx
+func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) error {
+	prevTXs, err := bc.findPrevTransactions(tx)
+	if err != nil {
+		return err
 	}
 
-	tx.Sign(privKey, prevTxs)
+	return tx.Sign(privKey, prevTXs)
 }
 
 /*
-	对交易进行验证
-	1、获取当前交易中的每个输入在区块链引用的交易
-	2、对交易及交易输入引用的交易进行签名验证
+	对交易进行验证，验证通过时返回nil
+	1、获取当前交易中的每个输入引用的输出，输出不在UTXO集中则返回ErrMissingInput
+	2、对交易及交易输入引用的交易进行签名验证，签名无效则返回ErrInvalidSignature
  */
-func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool  {
+func (bc *Blockchain) VerifyTransaction(tx *Transaction) error {
 	if tx.IsCoinbase() {
-		return true
+		return nil
 	}
-	prevTXs := make(map[string]Transaction)
-	for _, vin := range tx.Vin {
-		prevTX, err := bc.FindTransaction(vin.Txid)
-		if err != nil {
-			log.Panic(err)
-		}
-		prevTXs[hex.EncodeToString(prevTX.ID)]= prevTX
 
-		fmt.Printf("preTxs: %x ", prevTX.ID)
+	prevTXs, err := bc.findPrevTransactions(tx)
+	if err != nil {
+		return err
 	}
 
 	return tx.Verify(prevTXs)
 }
 
 /*
-	在当前区块链实例中遍历，查找交易ID对应的交易
-	1、对当前区块链实例中的区块进行遍历
-	2、将每个区块中的每笔交易的ID与参数ID进行比较
+	在当前区块链实例中查找交易ID对应的交易，并返回交易所在区块的区块头
+	1、启用了交易索引时，直接通过交易索引定位
+	2、否则对当前区块链实例中的区块进行遍历，将每个区块中的每笔交易的ID与参数ID进行比较
  */
-func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
+func (bc *Blockchain) GetTransaction(ID []byte) (Transaction, *BlockHeader, error) {
+	transaction, header, indexed, err := bc.findIndexedTransaction(ID)
+	if indexed || err != nil {
+		return transaction, header, err
+	}
+
 	bci := bc.Iterator()
 
 	for {
-		block := bci.Next()
+		block, err := bci.Next()
+		if err != nil {
+			return Transaction{}, nil, err
+		}
 		for _, tx := range block.Transactions {
 			if bytes.Compare(tx.ID, ID) == 0 {
-				return *tx, nil
+				return *tx, &block.BlockHeader, nil
 			}
 		}
 
@@ -602,13 +894,20 @@ func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
 		}
 	}
 
-	return Transaction{}, errors.New("Transaction is not found")
+	return Transaction{}, nil, ErrTransactionNotFound
+}
+
+//在当前区块链实例中查找交易ID对应的交易
+func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
+	transaction, _, err := bc.GetTransaction(ID)
+
+	return transaction, err
 }
 
 //创建一个区块链迭代器结构体，包含当前区块哈希和数据库连接
 type BlockchainIterator struct {
 	currentHash []byte
-	db *bolt.DB
+	db Store
 }
 
 //返回区块链实例对应的迭代器
@@ -618,22 +917,41 @@ func (bc *Blockchain)Iterator() *BlockchainIterator {
 }
 
 //通过区块链迭代器来返回对应的区块数据，然后指向上一个区块哈希
-func (i *BlockchainIterator)Next() *Block  {
+func (i *BlockchainIterator)Next() (*Block, error) {
 	var block *Block
 
-	err := i.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucket))
-		encodedBlock := b.Get(i.currentHash)
-		block = DeserializeBlock(encodedBlock)
+	err := i.db.View(func(tx StoreTx) error {
+		var err error
+		block, err = getBlock(tx, i.currentHash)
 
-		return nil
+		return err
 	})
 
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
 	i.currentHash = block.PrevBlockHash
 
-	return block
-}
\ No newline at end of file
+	return block, nil
+}
+
+//通过区块链迭代器只返回区块头，不加载区块中的交易，然后指向上一个区块哈希
+func (i *BlockchainIterator)NextHeader() (*BlockHeader, error) {
+	var header *BlockHeader
+
+	err := i.db.View(func(tx StoreTx) error {
+		var err error
+		header, err = getBlockHeader(tx, i.currentHash)
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	i.currentHash = header.PrevBlockHash
+
+	return header, nil
+}
@@ -0,0 +1,107 @@
+/*
+	地址索引
+	UTXO集的二级索引，key为 公钥哈希 + UTXO集中的key（交易ID + 输出索引号），value为空
+	同一地址的输出在数据库中相邻，通过游标按公钥哈希前缀查找，查询地址的余额和可花费输出时
+	只需要访问该地址自己的输出，而不需要遍历整个UTXO集
+	地址索引与UTXO集在同一个数据库事务中修改，二者始终保持一致
+ */
+package BlockInfo
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const addrIndexBucket = "addrindex"
+
+func addrIndexKey(pubKeyHash, outpoint []byte) []byte {
+	key := make([]byte, 0, len(pubKeyHash)+len(outpoint))
+	key = append(key, pubKeyHash...)
+
+	return append(key, outpoint...)
+}
+
+//将输出保存进UTXO集，并建立地址索引
+func putUTXO(tx StoreTx, key []byte, entry UTXOEntry) error {
+	err := tx.Bucket([]byte(utxoBucket)).Put(key, entry.Serialize())
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket([]byte(addrIndexBucket)).Put(addrIndexKey(entry.Output.PubKeyHash, key), []byte{})
+}
+
+//从UTXO集中删除输出，并删除其地址索引，输出不存在时不做任何操作
+func deleteUTXO(tx StoreTx, key []byte) error {
+	b := tx.Bucket([]byte(utxoBucket))
+	data := b.Get(key)
+	if data == nil {
+		return nil
+	}
+
+	entry, err := DeserializeUTXOEntry(data)
+	if err != nil {
+		return err
+	}
+	err = tx.Bucket([]byte(addrIndexBucket)).Delete(addrIndexKey(entry.Output.PubKeyHash, key))
+	if err != nil {
+		return err
+	}
+
+	return b.Delete(key)
+}
+
+/*
+	遍历公钥哈希对应的所有UTXO，fn返回false时停止遍历
+	1、在地址索引中定位到以公钥哈希为前缀的第一个key
+	2、依次取出key中的UTXO集key，从UTXO集中读取对应的输出，地址索引指向不存在的输出时返回错误
+ */
+func forEachAddressUTXO(tx StoreTx, pubKeyHash []byte, fn func(key []byte, entry UTXOEntry) bool) error {
+	b := tx.Bucket([]byte(utxoBucket))
+	c := tx.Bucket([]byte(addrIndexBucket)).Cursor()
+
+	for k, _ := c.Seek(pubKeyHash); k != nil && bytes.HasPrefix(k, pubKeyHash); k, _ = c.Next() {
+		key := k[len(pubKeyHash):]
+		//公钥哈希长度不同的其他地址也可能以该前缀开头，只处理key长度正确的索引
+		if len(key) != utxoKeyLen {
+			continue
+		}
+
+		data := b.Get(key)
+		if data == nil {
+			return fmt.Errorf("address index points to a missing output %x, run reindexutxo to rebuild it", key)
+		}
+		entry, err := DeserializeUTXOEntry(data)
+		if err != nil {
+			return err
+		}
+		if !fn(key, entry) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+//为没有地址索引的旧数据库根据UTXO集建立地址索引
+func (bc *Blockchain) ensureAddrIndex() error {
+	return bc.Db.Update(func(tx StoreTx) error {
+		if tx.Bucket([]byte(utxoBucket)) == nil || tx.Bucket([]byte(addrIndexBucket)) != nil {
+			return nil
+		}
+
+		fmt.Println("Building address index...")
+		ab, err := tx.CreateBucket([]byte(addrIndexBucket))
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket([]byte(utxoBucket)).ForEach(func(k, v []byte) error {
+			entry, err := DeserializeUTXOEntry(v)
+			if err != nil {
+				return err
+			}
+			return ab.Put(addrIndexKey(entry.Output.PubKeyHash, k), []byte{})
+		})
+	})
+}
@@ -0,0 +1,58 @@
+package BlockInfo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddrIndex(t *testing.T) {
+	params.CoinbaseMaturity = 1
+	defer func() { params.CoinbaseMaturity = 100 }()
+
+	from, err := NewWallet()
+	assert.NoError(t, err)
+	to, err := NewWallet()
+	assert.NoError(t, err)
+	fromHash := Ripmd160Hash(from.PublicKey)
+	toHash := Ripmd160Hash(to.PublicKey)
+	store := NewMemoryStore()
+	bc, err := CreateBlockchainInStore(string(from.GetAddress()), store)
+	assert.NoError(t, err)
+	utxoSet := UTXOSet{bc}
+
+	spend, err := NewUTXOTransaction(from, string(to.GetAddress()), 3, 1, &utxoSet)
+	assert.NoError(t, err)
+	_, err = bc.MineBlock([]*Transaction{newTestCoinbase(t, string(from.GetAddress()), 1, 1), spend})
+	assert.NoError(t, err)
+
+	check := func(utxoSet UTXOSet) {
+		outputs, err := utxoSet.FindUTXO(toHash)
+		assert.NoError(t, err)
+		assert.Equal(t, []TXOutput{spend.Vout[0]}, outputs)
+
+		balance, _, err := utxoSet.GetBalance(fromHash)
+		assert.NoError(t, err)
+		assert.Equal(t, params.BlockSubsidy(0)-4+params.BlockSubsidy(1)+1, balance, "Spent genesis reward is removed from the index")
+
+		accumulated, spendable, err := utxoSet.FindSpendableOutputs(toHash, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, accumulated)
+		assert.Len(t, spendable, 1)
+
+		outputs, err = utxoSet.FindUTXO(fromHash[:10])
+		assert.NoError(t, err)
+		assert.Empty(t, outputs, "Prefix of a public key hash does not match its outputs")
+	}
+	check(utxoSet)
+
+	//旧数据库没有地址索引，打开时根据UTXO集重新建立
+	err = store.Update(func(tx StoreTx) error {
+		return tx.DeleteBucket([]byte(addrIndexBucket))
+	})
+	assert.NoError(t, err)
+	bc, err = OpenBlockchain(store)
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+	check(UTXOSet{bc})
+}
@@ -0,0 +1,219 @@
+/*
+	区块索引
+	记录数据库中每个已知区块（包括不在主链上的侧链区块）的父区块、高度和累计工作量，
+	用于在多条分支之间选择累计工作量最大的链作为主链
+ */
+package BlockInfo
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"math/big"
+)
+
+const blockIndexBucket = "blockindex"
+const blockChildrenBucket = "blockchildren"
+
+/*
+	区块索引结构体
+	Hash：区块哈希
+	PrevHash：父区块哈希
+	Height：区块高度
+	Timestamp、Bits：区块的时间戳和目标值，用于计算后续区块的难度
+	TotalWork：从创世块到该区块的累计工作量（big.Int的字节表示）
+	Invalid：区块或其祖先区块在链接到主链时未通过验证
+ */
+type blockIndex struct {
+	Hash      []byte
+	PrevHash  []byte
+	Height    int
+	Timestamp int64
+	Bits      uint32
+	TotalWork []byte
+	Invalid   bool
+}
+
+func (bi *blockIndex) totalWork() *big.Int {
+	return new(big.Int).SetBytes(bi.TotalWork)
+}
+
+func (bi *blockIndex) serialize() ([]byte, error) {
+	var result bytes.Buffer
+	encoder := gob.NewEncoder(&result)
+
+	err := encoder.Encode(bi)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Bytes(), nil
+}
+
+func deserializeBlockIndex(d []byte) (*blockIndex, error) {
+	var bi blockIndex
+
+	decoder := gob.NewDecoder(bytes.NewReader(d))
+	err := decoder.Decode(&bi)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bi, nil
+}
+
+//根据区块及其父区块的索引生成区块索引，父区块为nil表示创世块
+func newBlockIndex(block *Block, parent *blockIndex) *blockIndex {
+	work := NewProofOfWork(block).Work()
+	if parent != nil {
+		work.Add(work, parent.totalWork())
+	}
+
+	return &blockIndex{block.Hash, block.PrevBlockHash, block.Height, block.Timestamp, block.Bits, work.Bytes(), false}
+}
+
+//在读写事务中保存区块索引，同时在blockchildren中以 父区块哈希+区块哈希 为key建立父区块到子区块的索引（创世块除外）
+func putBlockIndex(tx StoreTx, bi *blockIndex) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(blockIndexBucket))
+	if err != nil {
+		return err
+	}
+	data, err := bi.serialize()
+	if err != nil {
+		return err
+	}
+	err = b.Put(bi.Hash, data)
+	if err != nil {
+		return err
+	}
+
+	if len(bi.PrevHash) == 0 {
+		return nil
+	}
+
+	c, err := tx.CreateBucketIfNotExists([]byte(blockChildrenBucket))
+	if err != nil {
+		return err
+	}
+	return c.Put(append(append([]byte{}, bi.PrevHash...), bi.Hash...), []byte{})
+}
+
+//在事务中读取区块哈希对应的区块索引，不存在则返回nil
+func getBlockIndex(tx StoreTx, hash []byte) (*blockIndex, error) {
+	b := tx.Bucket([]byte(blockIndexBucket))
+	if b == nil {
+		return nil, nil
+	}
+
+	data := b.Get(hash)
+	if data == nil {
+		return nil, nil
+	}
+
+	return deserializeBlockIndex(data)
+}
+
+//获取区块哈希对应的区块索引，不存在则返回nil
+func (bc *Blockchain) getBlockIndex(hash []byte) (*blockIndex, error) {
+	var bi *blockIndex
+
+	err := bc.Db.View(func(tx StoreTx) error {
+		var err error
+		bi, err = getBlockIndex(tx, hash)
+		return err
+	})
+
+	return bi, err
+}
+
+//获取以hash为父区块的所有子区块哈希（包括主链和侧链）
+func (bc *Blockchain) GetChildBlocks(hash []byte) ([][]byte, error) {
+	var children [][]byte
+
+	err := bc.Db.View(func(tx StoreTx) error {
+		b := tx.Bucket([]byte(blockChildrenBucket))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for k, _ := c.Seek(hash); k != nil && bytes.HasPrefix(k, hash); k, _ = c.Next() {
+			child := make([]byte, len(k)-len(hash))
+			copy(child, k[len(hash):])
+			children = append(children, child)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return children, nil
+}
+
+//将区块及其所有后代区块标记为无效，之后收到的以这些区块为父区块的区块都将被拒绝
+func (bc *Blockchain) markInvalid(hash []byte) error {
+	err := bc.Db.Update(func(tx StoreTx) error {
+		bi, err := getBlockIndex(tx, hash)
+		if err != nil || bi == nil {
+			return err
+		}
+		bi.Invalid = true
+		return putBlockIndex(tx, bi)
+	})
+	if err != nil {
+		return err
+	}
+
+	children, err := bc.GetChildBlocks(hash)
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		err = bc.markInvalid(child)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+	为没有区块索引的旧数据库建立主链的区块索引
+	从链尾遍历到创世块，再从创世块开始依次计算累计工作量
+ */
+func (bc *Blockchain) ensureBlockIndex() error {
+	tipIndex, err := bc.getBlockIndex(bc.tip)
+	if err != nil || tipIndex != nil {
+		return err
+	}
+
+	fmt.Println("Building block index...")
+	var blocks []*Block
+	bci := bc.Iterator()
+	for {
+		block, err := bci.Next()
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, block)
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return bc.Db.Update(func(tx StoreTx) error {
+		var parent *blockIndex
+		for i := len(blocks) - 1; i >= 0; i-- {
+			bi := newBlockIndex(blocks[i], parent)
+			err := putBlockIndex(tx, bi)
+			if err != nil {
+				return err
+			}
+			parent = bi
+		}
+		return nil
+	})
+}
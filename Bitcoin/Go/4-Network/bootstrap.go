@@ -0,0 +1,148 @@
+/*
+	区块链的导出和导入
+	导出文件由按高度从低到高排列的区块组成，每个区块为一帧：
+	networkMagic（4个字节，与网络消息相同）| uint32(区块数据长度，小端序) | 区块序列化数据（Block.Serialize）
+	导入时每个区块都通过AddBlock进行完整的验证，与从其他节点收到的区块相同
+ */
+package BlockInfo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+//按帧读取导出文件中的区块
+type BlockReader struct {
+	r    *bufio.Reader
+	read int64
+}
+
+func NewBlockReader(r io.Reader) *BlockReader {
+	return &BlockReader{r: bufio.NewReader(r)}
+}
+
+//已读取的字节数，用于计算导入进度
+func (br *BlockReader) BytesRead() int64 {
+	return br.read
+}
+
+/*
+	读取下一个区块，文件正好在帧的边界结束时返回io.EOF
+	1、检查帧的magic，区块数据长度不能超过maxBlockSize，避免按错误的长度分配内存
+	2、读取并反序列化区块数据
+ */
+func (br *BlockReader) Next() (*Block, error) {
+	header := make([]byte, len(networkMagic)+4)
+	n, err := io.ReadFull(br.r, header)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, ErrMalformedData
+	}
+	if !bytes.Equal(header[:len(networkMagic)], networkMagic) {
+		return nil, ErrMalformedData
+	}
+
+	size := binary.LittleEndian.Uint32(header[len(networkMagic):])
+	if size > maxBlockSize {
+		return nil, ErrMalformedData
+	}
+
+	data := make([]byte, size)
+	_, err = io.ReadFull(br.r, data)
+	if err != nil {
+		return nil, ErrMalformedData
+	}
+	br.read += int64(n) + int64(size)
+
+	return DeserializeBlock(data)
+}
+
+func writeBlockFrame(w io.Writer, block *Block) error {
+	data := block.Serialize()
+
+	var frame bytes.Buffer
+	frame.Write(networkMagic)
+	writeUint32(&frame, uint32(len(data)))
+	frame.Write(data)
+
+	_, err := w.Write(frame.Bytes())
+	return err
+}
+
+/*
+	将主链上高度from到to（包含）的区块依次写入w，返回写入的区块数
+	to为负数时导出到链尾，区块数据已被修剪时返回ErrBlockPruned
+	progress不为nil时每写入一个区块调用一次
+ */
+func (bc *Blockchain) ExportBlocks(w io.Writer, from, to int, progress func(height, to int)) (int, error) {
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		return 0, err
+	}
+	if from < 0 {
+		from = 0
+	}
+	if to < 0 || to > bestHeight {
+		to = bestHeight
+	}
+
+	count := 0
+	for height := from; height <= to; height++ {
+		block, err := bc.GetBlockByHeight(height)
+		if err != nil {
+			return count, err
+		}
+
+		err = writeBlockFrame(w, &block)
+		if err != nil {
+			return count, err
+		}
+		count++
+
+		if progress != nil {
+			progress(height, to)
+		}
+	}
+
+	return count, nil
+}
+
+/*
+	依次读取br中的区块并通过AddBlock添加到区块链，返回新添加的区块数
+	已经存在的区块会被跳过，任何一个区块无法读取或未通过验证时停止导入并返回错误
+	progress不为nil时每处理一个区块调用一次
+ */
+func (bc *Blockchain) ImportBlocks(br *BlockReader, progress func(block *Block)) (int, error) {
+	count := 0
+
+	for {
+		block, err := br.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+
+		known, err := bc.getBlockIndex(block.Hash)
+		if err != nil {
+			return count, err
+		}
+
+		_, err = bc.AddBlock(block)
+		if err != nil {
+			return count, err
+		}
+		if known == nil {
+			count++
+		}
+
+		if progress != nil {
+			progress(block)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package BlockInfo
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImportBlocks(t *testing.T) {
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+
+	bc, err := CreateBlockchainInStore(string(wallet.GetAddress()), NewMemoryStore())
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+
+	var buf bytes.Buffer
+	count, err := bc.ExportBlocks(&buf, -1, -1, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	data := buf.Bytes()
+
+	br := NewBlockReader(bytes.NewReader(data))
+	genesis, err := br.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, bc.tip, genesis.Hash)
+	assert.Equal(t, int64(len(data)), br.BytesRead())
+	_, err = br.Next()
+	assert.Equal(t, io.EOF, err)
+
+	imported, err := CreateBlockchainWithGenesisInStore(genesis, NewMemoryStore())
+	assert.NoError(t, err)
+	count, err = imported.ImportBlocks(NewBlockReader(bytes.NewReader(data)), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count, "Known blocks are skipped")
+
+	_, err = NewBlockReader(bytes.NewReader(data[:len(data)-1])).Next()
+	assert.Equal(t, ErrMalformedData, err)
+	_, err = NewBlockReader(bytes.NewReader(data[1:])).Next()
+	assert.Equal(t, ErrMalformedData, err)
+}
@@ -7,25 +7,41 @@ package BlockInfo
  */
 
 import (
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type CLI struct {}
 
 func (cli *CLI) printUsage()  {
 	fmt.Println("Usage:")
-	fmt.Println("  createblockchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS")
+	fmt.Println("  createblockchain -address ADDRESS [-txindex=false] - Create a blockchain and send genesis block reward to ADDRESS")
 	fmt.Println("  createwallet - Generates a new key-pair and saves it into the wallet file")
 	fmt.Println("  getbalance -address ADDRESS - Get balance of ADDRESS")
 	fmt.Println("  listaddresses - Lists all addresses from the wallet file")
-	fmt.Println("  printchain - Print all the blocks of the blockchain")
+	fmt.Println("  printchain [-headers] [-from H -to H] - Print all the blocks of the blockchain (or those between heights), or only their headers")
+	fmt.Println("  getblock -height H - Print the main chain block at height H")
 	fmt.Println("  reindexutxo - Rebuilds the UTXO set")
 	fmt.Println("  printutxo - print the UTXO set")
-	fmt.Println("  send -from FROM -to TO -amount AMOUNT - Send AMOUNT of coins from FROM address to TO")
+	fmt.Println("  exportchain -out FILE [-from H -to H] - Write the main chain blocks (or those between heights) to FILE")
+	fmt.Println("  importchain -in FILE - Validate and add the blocks in FILE exported by exportchain")
+	fmt.Println("  dumputxo -out FILE - Write a snapshot of the UTXO set to FILE")
+	fmt.Println("  loadutxo -in FILE [-txindex=false] [-height HEIGHT -hash HASH -checksum CHECKSUM] - Create a blockchain from a trusted UTXO snapshot in FILE, optionally trusting the given snapshot")
+	fmt.Println("  getsupply - Print issued coins per height and check them against the UTXO set")
+	fmt.Println("  gettransaction -id TXID - Print a transaction with its block, height and confirmations")
+	fmt.Println("  reindextx - Enables and rebuilds the transaction index")
+	fmt.Println("  send -from FROM -to TO -amount AMOUNT -fee FEE [-node ADDR] - Send AMOUNT of coins from FROM address to TO, paying FEE to the miner, through the node at ADDR or a known node")
+	fmt.Println("  startnode [-miner ADDRESS] [-prune SIZE] [-conf FILE] [-listen ADDR] [-externaladdr ADDR] [-seeds ADDR,ADDR] - Start a node, mining to ADDRESS, and keep at most SIZE MiB of old block data")
+	fmt.Println("  getpeerinfo - Print the peers connected to the running node")
+	fmt.Println("  addnode -addr ADDR - Add ADDR to the address book of the running node and connect to it")
+	fmt.Println("  disconnectnode -addr ADDR - Disconnect the running node from ADDR")
 }
 
 func (cli *CLI) validateArgs()  {
@@ -35,6 +51,20 @@ func (cli *CLI) validateArgs()  {
 	}
 }
 
+//打开节点的区块链数据库，区块链不存在时提示先创建区块链并退出
+func openBlockchain(nodeID string) *Blockchain {
+	bc, err := GetBlockchain4db(nodeID)
+	if err == ErrChainNotFound {
+		fmt.Println("No existing blockchain found. Create one first.")
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return bc
+}
+
 /*
 	创建钱包命令
 	这里钱包用于保存地址—私钥及公钥对的map映射
@@ -43,8 +73,14 @@ func (cli *CLI) validateArgs()  {
  */
 func (cli *CLI) createWallet(nodeID string)  {
 	wallets, _ := NewWallets(nodeID)
-	address := wallets.CreateWallet()
-	wallets.SaveToFile(nodeID)
+	address, err := wallets.CreateWallet()
+	if err != nil {
+		log.Panic(err)
+	}
+	err = wallets.SaveToFile(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
 
 	fmt.Printf("Your new address: %s\n", address)
 }
@@ -54,16 +90,27 @@ func (cli *CLI) createWallet(nodeID string)  {
 	1、判断地址是否合规；
 	2、创建一条只包含创世纪块的区块链，生成数据库文件，奖励给地址address
  */
-func (cli *CLI) createBlockchain(address, nodeID string)  {
+func (cli *CLI) createBlockchain(address, nodeID string, txIndex bool)  {
 	if !ValidForAddress(address) {
 		log.Panic("ERROR: Address is not valid")
 	}
 
-	bc := CreateBlockchain(address, nodeID)
+	bc, err := CreateBlockchain(address, nodeID)
+	if err == ErrChainExists {
+		fmt.Println("Blockchain already exists.")
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
 	defer bc.Db.Close()
 
-	UTXOSet := UTXOSet{bc}
-	UTXOSet.Reindex()
+	if txIndex {
+		_, err = bc.ReindexTransactions()
+		if err != nil {
+			log.Panic(err)
+		}
+	}
 
 	fmt.Println("Done!")
 }
@@ -99,20 +146,21 @@ func (cli *CLI) getBalance(address, nodeID  string)  {
 		log.Panic("ERROR: Address is not valid")
 	}
 	
-	bc := GetBlockchain4db(nodeID)
+	bc := openBlockchain(nodeID)
 	UTXOSet := UTXOSet{bc}
 	defer bc.Db.Close()
 
-	balance := 0
 	pubKeyHash := Base58Decode([]byte(address))
 	pubKeyHash = pubKeyHash[1:len(pubKeyHash)-4]
-	UTXOs := UTXOSet.FindUTXO(pubKeyHash)
-
-	for _, out := range UTXOs {
-		balance += out.Value
+	balance, immature, err := UTXOSet.GetBalance(pubKeyHash)
+	if err != nil {
+		log.Panic(err)
 	}
 
 	fmt.Printf("Balance of '%s'：'%d'\n", address, balance)
+	if immature > 0 {
+		fmt.Printf("Immature coinbase rewards：'%d' (spendable after %d confirmations)\n", immature, params.CoinbaseMaturity)
+	}
 }
 
 /*
@@ -122,7 +170,7 @@ func (cli *CLI) getBalance(address, nodeID  string)  {
 	3、构建一条交易，实现从from到to的转账
 	4、将构建的交易打包进区块（目前没有奖励）
  */
-func (cli *CLI) send(from, to, nodeID string, amount int, mineNow bool)  {
+func (cli *CLI) send(from, to, nodeID, node string, amount, fee int, mineNow bool)  {
 	log.Println("From Address: "+from)
 	if !ValidForAddress(from) {
 		log.Panic("ERROR: From's Address is not valid")
@@ -133,7 +181,7 @@ func (cli *CLI) send(from, to, nodeID string, amount int, mineNow bool)  {
 		log.Panic("ERROR: To's Address is not valid")
 	}
 
-	bc := GetBlockchain4db(nodeID)
+	bc := openBlockchain(nodeID)
 	UTXOSet := UTXOSet{bc}
 
 	defer bc.Db.Close()
@@ -142,21 +190,41 @@ func (cli *CLI) send(from, to, nodeID string, amount int, mineNow bool)  {
 	if err != nil {
 		log.Panic(err)
 	}
-	wallet := wallets.GetWallet(from)
-	tx := NewUTXOTransaction(&wallet, to ,amount, &UTXOSet)
+	wallet, err := wallets.GetWallet(from)
+	if err != nil {
+		log.Panic(err)
+	}
+	tx, err := NewUTXOTransaction(&wallet, to ,amount, fee, &UTXOSet)
+	if err != nil {
+		log.Panic(err)
+	}
 	if mineNow {
-		cbTx := NewCoinbaseTX(from, "")
+		bestHeight, err := bc.GetBestHeight()
+		if err != nil {
+			log.Panic(err)
+		}
+		cbTx, err := NewCoinbaseTX(from, "", bestHeight+1, fee)
+		if err != nil {
+			log.Panic(err)
+		}
 		txs := []*Transaction{cbTx,tx}
 
-		newBlock := bc.MineBlock(txs)
-		UTXOSet.Update(newBlock)
+		_, err = bc.MineBlock(txs)
+		if err != nil {
+			log.Panic(err)
+		}
 	} else {
-		if !bc.VerifyTransaction(tx) {
-			fmt.Println("transactions are invalid! Waiting for new ones...")
+		err = bc.VerifyTransaction(tx)
+		if err != nil {
+			fmt.Printf("transactions are invalid: %v\n", err)
 			return
 		}
 
-		sendTx(knownNodes[0], tx)
+		err = submitTransaction(nodeID, node, tx)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
 	}
 
 
@@ -168,60 +236,406 @@ func (cli *CLI) send(from, to, nodeID string, amount int, mineNow bool)  {
 	fmt.Println("Success!")
 }
 
+/*
+	将交易发送给一个节点，由该节点转发给网络中的其他节点
+	指定了node时只发送给node，否则依次尝试地址簿中最近成功连接过的节点和默认种子节点，直到发送成功
+ */
+func submitTransaction(nodeID, node string, tx *Transaction) error {
+	candidates := []string{node}
+	if node == "" {
+		pm, err := LoadPeerManager(nodeID)
+		if err != nil {
+			return err
+		}
+		candidates = append(pm.KnownAddresses(), defaultSeeds...)
+	}
+
+	for _, addr := range candidates {
+		err := SubmitTransaction(addr, tx)
+		if err == nil {
+			fmt.Printf("Sent transaction %x to %s\n", tx.ID, addr)
+			return nil
+		}
+		fmt.Printf("%s is not available: %v\n", addr, err)
+	}
+
+	return errors.New("no node is available")
+}
+
 /*
 	打印区块链相关信息命令
 	1、通过读取数据库文件从而获取区块链实例（包含指向最后的区块哈希和数据库连接）
-	2、遍历区块链区块，输出区块信息
+	2、未指定高度范围时，从链尾遍历区块链区块，输出区块信息
+	3、指定了高度范围from-to时，通过高度索引按高度从低到高输出范围内的区块
+	4、若headersOnly为true，则只读取区块头，不加载区块中的交易
  */
-func (cli *CLI) printChain(nodeID string)  {
-	bc := GetBlockchain4db(nodeID)
+func (cli *CLI) printChain(nodeID string, headersOnly bool, from, to int)  {
+	bc := openBlockchain(nodeID)
 	defer bc.Db.Close()
 
+	if from >= 0 || to >= 0 {
+		if to < 0 {
+			bestHeight, err := bc.GetBestHeight()
+			if err != nil {
+				log.Panic(err)
+			}
+			to = bestHeight
+		}
+
+		hashes, err := bc.GetBlockHashes(from, to)
+		if err != nil {
+			log.Panic(err)
+		}
+		for _, hash := range hashes {
+			printBlock(bc, hash, headersOnly)
+		}
+		return
+	}
+
 	bci := bc.Iterator()
 	for {
-		block := bci.Next()
-
-		fmt.Printf("============ Block %x ============\n", block.Hash)
-		fmt.Printf("Height: %d\n", block.Height)
-		fmt.Printf("Prev. block: %x\n", block.PrevBlockHash)
-		pow := NewProofOfWork(block)
-		fmt.Printf("PoW: %s\n\n", strconv.FormatBool(pow.Validate()))
-		for _, tx := range block.Transactions {
-			fmt.Println(tx)
+		hash := bci.currentHash
+		var header *BlockHeader
+		if headersOnly {
+			h, err := bci.NextHeader()
+			if err != nil {
+				log.Panic(err)
+			}
+			header = h
+			printBlockInfo(&Block{*header, hash, nil})
+		} else {
+			block, err := bci.Next()
+			if err == ErrBlockPruned {
+				//区块数据已被修剪，只输出区块头
+				h, err := bci.NextHeader()
+				if err != nil {
+					log.Panic(err)
+				}
+				block = &Block{*h, hash, nil}
+			} else if err != nil {
+				log.Panic(err)
+			}
+			header = &block.BlockHeader
+			printBlockInfo(block)
 		}
-		fmt.Printf("\n\n")
 
-		if len(block.PrevBlockHash) == 0 {
+		if len(header.PrevBlockHash) == 0 {
 			break
 		}
+	}
+}
+
+//查看主链上指定高度的区块命令
+func (cli *CLI) getBlock(nodeID string, height int)  {
+	bc := openBlockchain(nodeID)
+	defer bc.Db.Close()
+
+	hash, err := bc.GetBlockHashByHeight(height)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	printBlock(bc, hash, false)
+}
+
+//读取区块哈希对应的区块（或只读取区块头）并输出
+func printBlock(bc *Blockchain, hash []byte, headersOnly bool) {
+	var block *Block
+	if headersOnly {
+		header, err := bc.GetBlockHeader(hash)
+		if err != nil {
+			log.Panic(err)
+		}
+		block = &Block{BlockHeader: *header}
+		block.Hash = header.Hash()
+	} else {
+		b, err := bc.GetBlock(hash)
+		if err == ErrBlockPruned {
+			printBlock(bc, hash, true)
+			fmt.Println("Block data has been pruned")
+			return
+		}
+		if err != nil {
+			log.Panic(err)
+		}
+		block = &b
+	}
+
+	printBlockInfo(block)
+}
 
+//输出区块信息和区块中的交易
+func printBlockInfo(block *Block) {
+	fmt.Printf("============ Block %x ============\n", block.Hash)
+	fmt.Printf("Height: %d\n", block.Height)
+	fmt.Printf("Prev. block: %x\n", block.PrevBlockHash)
+	fmt.Printf("Merkle root: %x\n", block.MerkleRoot)
+	fmt.Printf("Bits: %08x\n", block.Bits)
+	pow := NewProofOfWork(block)
+	fmt.Printf("PoW: %s\n\n", strconv.FormatBool(pow.Validate()))
+	for _, tx := range block.Transactions {
+		fmt.Println(tx)
 	}
+	fmt.Printf("\n\n")
 }
 
+//重新建立UTXO集时每处理多少个区块输出一次进度
+const reindexProgressInterval = 1000
+
 /*
-	重新建立UTXO集
+	重新建立UTXO集，用于UTXO集损坏时的恢复
 	1、获取区块链实例
-	2、重新生成UTXO集
+	2、从创世区块开始重新生成UTXO集，并输出进度
  */
 func (cli *CLI) reindexUTXO(nodeID string)  {
-	bc := GetBlockchain4db(nodeID)
+	bc := openBlockchain(nodeID)
+	defer bc.Db.Close()
+
 	UTXOSet := UTXOSet{bc}
-	UTXOSet.Reindex()
+	err := UTXOSet.ReindexWithProgress(func(height, bestHeight int) {
+		if height%reindexProgressInterval == 0 || height == bestHeight {
+			fmt.Printf("Reindexing UTXO set: block %d of %d (%d%%)\n", height, bestHeight, (height+1)*100/(bestHeight+1))
+		}
+	})
+	if errors.Is(err, ErrMissingHistory) {
+		fmt.Println("The UTXO set cannot be rebuilt on a pruned node or a node loaded from a UTXO snapshot, load a snapshot with loadutxo instead.")
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
 
-	count := UTXOSet.CountTransactions()
+	count, err := UTXOSet.CountTransactions()
+	if err != nil {
+		log.Panic(err)
+	}
 	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
 }
 
 func (cli *CLI) printUTXOSet(nodeID string)  {
-	bc := GetBlockchain4db(nodeID)
+	bc := openBlockchain(nodeID)
+	defer bc.Db.Close()
+
 	UTXOSet := UTXOSet{bc}
-	UTXOSet.PrintUTXO()
+	err := UTXOSet.PrintUTXO()
+	if err != nil {
+		log.Panic(err)
+	}
 
-	count := UTXOSet.CountTransactions()
+	count, err := UTXOSet.CountTransactions()
+	if err != nil {
+		log.Panic(err)
+	}
 	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
 }
 
-func (cli *CLI) startNode(nodeID, minerAddress string)  {
+/*
+	将主链上的区块导出到文件，用于在机器之间复制区块链或归档
+	from、to为负数时分别从创世区块开始、导出到链尾
+ */
+func (cli *CLI) exportChain(out, nodeID string, from, to int)  {
+	bc := openBlockchain(nodeID)
+	defer bc.Db.Close()
+
+	f, err := os.Create(out)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer f.Close()
+
+	count, err := bc.ExportBlocks(f, from, to, func(height, to int) {
+		if height%reindexProgressInterval == 0 || height == to {
+			fmt.Printf("Exporting blocks: block %d of %d\n", height, to)
+		}
+	})
+	if err == ErrBlockPruned {
+		fmt.Println("Block data has been pruned, use -from to export recent blocks only.")
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Done! Exported %d blocks to %s\n", count, out)
+}
+
+/*
+	从导出文件导入区块，每个区块都经过完整的验证
+	节点还没有区块链时，以文件中的第一个区块作为创世区块创建区块链
+ */
+func (cli *CLI) importChain(in, nodeID string)  {
+	f, err := os.Open(in)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	br := NewBlockReader(f)
+	bc, err := GetBlockchain4db(nodeID)
+	if err == ErrChainNotFound {
+		var genesis *Block
+		genesis, err = br.Next()
+		if err != nil {
+			log.Panic(err)
+		}
+		bc, err = CreateBlockchainWithGenesis(genesis, nodeID)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	defer bc.Db.Close()
+
+	processed := 0
+	count, err := bc.ImportBlocks(br, func(block *Block) {
+		processed++
+		if processed%reindexProgressInterval == 0 {
+			fmt.Printf("Importing blocks: height %d, %d%% of the file\n", block.Height, br.BytesRead()*100/stat.Size())
+		}
+	})
+	if err != nil {
+		log.Panicf("Import stopped after %d new blocks: %v", count, err)
+	}
+
+	height, err := bc.GetBestHeight()
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Done! Imported %d new blocks, the best height is %d.\n", count, height)
+}
+
+/*
+	将UTXO集导出为快照文件
+	输出快照的区块高度、区块哈希和校验和，加入共识参数TrustedSnapshots或在loadutxo时指定后其他节点才能加载该快照
+ */
+func (cli *CLI) dumpUTXO(out, nodeID string)  {
+	bc := openBlockchain(nodeID)
+	defer bc.Db.Close()
+
+	f, err := os.Create(out)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer f.Close()
+
+	info, err := bc.DumpUTXOSnapshot(f)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Dumped %d outputs at height %d to %s\n", info.Outputs, info.Height, out)
+	fmt.Printf("Block hash: %x\n", info.BlockHash)
+	fmt.Printf("Checksum: %x\n", info.Checksum)
+}
+
+/*
+	从快照文件创建区块链，快照必须在共识参数TrustedSnapshots中，或者由-height、-hash、-checksum指定
+	快照之前的区块数据在节点启动后从已连接的节点补齐
+ */
+func (cli *CLI) loadUTXO(in, nodeID string, txIndex bool)  {
+	bc, info, err := LoadUTXOSnapshot(in, nodeID, txIndex)
+	if err == ErrChainExists {
+		fmt.Println("Blockchain already exists.")
+		os.Exit(1)
+	}
+	if err == ErrUntrustedSnapshot {
+		fmt.Printf("Snapshot at height %d (block %x, checksum %x) is not trusted.\n", info.Height, info.BlockHash, info.Checksum)
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	defer bc.Db.Close()
+
+	fmt.Printf("Done! Loaded %d outputs at height %d, start the node to download earlier blocks.\n", info.Outputs, info.Height)
+}
+
+/*
+	查看币的发行量命令
+	1、从区块链中计算每个高度的区块奖励、实际发行量和累计发行量
+	2、与UTXO集（chainstate）中所有未花费输出的总额进行核对
+ */
+func (cli *CLI) getSupply(nodeID string)  {
+	bc := openBlockchain(nodeID)
+	defer bc.Db.Close()
+
+	supply, err := bc.CalculateSupply()
+	if errors.Is(err, ErrMissingHistory) {
+		fmt.Println("Supply cannot be computed on a pruned node or a node loaded from a UTXO snapshot, earlier blocks are not stored.")
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	for _, s := range supply {
+		fmt.Printf("Height: %d  Subsidy: %d  Issued: %d  Total: %d\n", s.Height, s.Subsidy, s.Issued, s.Total)
+	}
+
+	total := supply[len(supply)-1].Total
+	chainstateTotal, err := UTXOSet{bc}.TotalValue()
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Issued: %d  Max supply: %d\n", total, params.MaxSupply)
+	fmt.Printf("Chainstate total: %d\n", chainstateTotal)
+
+	if total != chainstateTotal {
+		fmt.Println("ERROR: Issued coins do not match the chainstate, run reindexutxo to rebuild it")
+		os.Exit(1)
+	}
+	fmt.Println("Issued coins match the chainstate")
+}
+
+/*
+	查看交易命令
+	1、通过交易ID查找交易及其所在区块（启用交易索引时直接定位，否则遍历区块链）
+	2、输出交易、所在区块哈希、高度和确认数
+ */
+func (cli *CLI) getTransaction(id, nodeID string)  {
+	txID, err := hex.DecodeString(id)
+	if err != nil {
+		log.Panic("ERROR: Transaction ID is not valid")
+	}
+
+	bc := openBlockchain(nodeID)
+	defer bc.Db.Close()
+
+	tx, header, err := bc.GetTransaction(txID)
+	if err != nil {
+		log.Panic(err)
+	}
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(tx)
+	fmt.Printf("Block: %x\n", header.Hash())
+	fmt.Printf("Height: %d\n", header.Height)
+	fmt.Printf("Confirmations: %d\n", bestHeight-header.Height+1)
+}
+
+//启用并重建交易索引命令
+func (cli *CLI) reindexTransactions(nodeID string)  {
+	bc := openBlockchain(nodeID)
+	defer bc.Db.Close()
+
+	count, err := bc.ReindexTransactions()
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Done! There are %d transactions in the transaction index.\n", count)
+}
+
+/*
+	启动节点
+	配置依次取默认配置、配置文件conf和命令行参数，后面的覆盖前面的，seeds为逗号分隔的种子节点地址
+ */
+func (cli *CLI) startNode(nodeID, minerAddress string, pruneSize int, conf, listen, externalAddr, seeds string)  {
 	fmt.Printf("Starting node %s\n", nodeID)
 	if len(minerAddress) > 0 {
 		if ValidForAddress(minerAddress) {
@@ -230,8 +644,91 @@ func (cli *CLI) startNode(nodeID, minerAddress string)  {
 			log.Panic("Wrong miner address!")
 		}
 	}
+	if pruneSize > 0 {
+		fmt.Printf("Pruning is on. Block data is kept under %d MiB\n", pruneSize)
+	}
+
+	cfg := DefaultNodeConfig(nodeID)
+	if conf != "" {
+		err := cfg.Load(conf)
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+	if listen != "" {
+		cfg.ListenAddr = listen
+	}
+	if externalAddr != "" {
+		cfg.ExternalAddr = externalAddr
+	}
+	if seeds != "" {
+		cfg.Seeds = strings.Split(seeds, ",")
+	}
+	cfg.MinerAddress = minerAddress
+	cfg.PruneSize = pruneSize*1024*1024
+
+	err := StartServer(nodeID, cfg)
+	if err == ErrChainNotFound {
+		fmt.Println("No existing blockchain found. Create one first.")
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+//查看运行中的节点已连接的节点
+func (cli *CLI) getPeerInfo(nodeID string)  {
+	infos, err := GetPeerInfo(nodeID)
+	if err == ErrNodeNotRunning {
+		fmt.Printf("Node %s is not running.\n", nodeID)
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+
+	for _, info := range infos {
+		direction := "outbound"
+		if info.Inbound {
+			direction = "inbound"
+		}
+		fmt.Printf("============ Peer %s ============\n", info.Addr)
+		fmt.Printf("Direction: %s\n", direction)
+		fmt.Printf("Version: %d %s\n", info.Version, info.UserAgent)
+		fmt.Printf("Services: %s\n", info.Services)
+		fmt.Printf("Start height: %d\n", info.StartHeight)
+		fmt.Printf("Ban score: %d\n", info.BanScore)
+		fmt.Printf("Connected at: %s\n", info.ConnectedAt.Format(time.RFC3339))
+		fmt.Printf("\n")
+	}
+	fmt.Printf("%d peers connected.\n", len(infos))
+}
 
-	StartServer(nodeID, minerAddress)
+func (cli *CLI) addNode(addr, nodeID string)  {
+	err := AddNode(nodeID, addr)
+	if err == ErrNodeNotRunning {
+		fmt.Printf("Node %s is not running.\n", nodeID)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Failed to connect to %s: %v\n", addr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Connected to %s\n", addr)
+}
+
+func (cli *CLI) disconnectNode(addr, nodeID string)  {
+	err := DisconnectNode(nodeID, addr)
+	if err == ErrNodeNotRunning {
+		fmt.Printf("Node %s is not running.\n", nodeID)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Failed to disconnect from %s: %v\n", addr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Disconnected from %s\n", addr)
 }
 
 func (cli *CLI) Run()  {
@@ -252,14 +749,50 @@ func (cli *CLI) Run()  {
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
 	printUTXOCmd := flag.NewFlagSet("printutxoset", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
+	getSupplyCmd := flag.NewFlagSet("getsupply", flag.ExitOnError)
+	getBlockCmd := flag.NewFlagSet("getblock", flag.ExitOnError)
+	getTransactionCmd := flag.NewFlagSet("gettransaction", flag.ExitOnError)
+	reindexTxCmd := flag.NewFlagSet("reindextx", flag.ExitOnError)
+	exportChainCmd := flag.NewFlagSet("exportchain", flag.ExitOnError)
+	importChainCmd := flag.NewFlagSet("importchain", flag.ExitOnError)
+	dumpUTXOCmd := flag.NewFlagSet("dumputxo", flag.ExitOnError)
+	loadUTXOCmd := flag.NewFlagSet("loadutxo", flag.ExitOnError)
+	getPeerInfoCmd := flag.NewFlagSet("getpeerinfo", flag.ExitOnError)
+	addNodeCmd := flag.NewFlagSet("addnode", flag.ExitOnError)
+	disconnectNodeCmd := flag.NewFlagSet("disconnectnode", flag.ExitOnError)
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	createBlockchainTxIndex := createBlockchainCmd.Bool("txindex", true, "Maintain the transaction index")
+	getTransactionID := getTransactionCmd.String("id", "", "The ID of the transaction")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	sendFee := sendCmd.Int("fee", 0, "Fee paid to the miner")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node")
+	sendNode := sendCmd.String("node", "", "The address of the node to send the transaction to")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodePrune := startNodeCmd.Int("prune", 0, "Delete old block data to keep it under SIZE MiB (0 disables pruning)")
+	startNodeConf := startNodeCmd.String("conf", "", "The node config file")
+	startNodeListen := startNodeCmd.String("listen", "", "The address to listen on (default localhost:NODE_ID)")
+	startNodeExternalAddr := startNodeCmd.String("externaladdr", "", "The address other nodes use to connect to this node")
+	startNodeSeeds := startNodeCmd.String("seeds", "", "Comma-separated addresses of the seed nodes")
+	printChainHeaders := printChainCmd.Bool("headers", false, "Only print block headers")
+	printChainFrom := printChainCmd.Int("from", -1, "Print blocks from this height")
+	printChainTo := printChainCmd.Int("to", -1, "Print blocks up to this height")
+	getBlockHeight := getBlockCmd.Int("height", -1, "The height of the block")
+	exportChainOut := exportChainCmd.String("out", "", "The file to write the blocks to")
+	exportChainFrom := exportChainCmd.Int("from", -1, "Export blocks from this height")
+	exportChainTo := exportChainCmd.Int("to", -1, "Export blocks up to this height")
+	importChainIn := importChainCmd.String("in", "", "The file to read the blocks from")
+	dumpUTXOOut := dumpUTXOCmd.String("out", "", "The file to write the snapshot to")
+	loadUTXOIn := loadUTXOCmd.String("in", "", "The snapshot file to load")
+	loadUTXOTxIndex := loadUTXOCmd.Bool("txindex", true, "Maintain the transaction index")
+	loadUTXOHeight := loadUTXOCmd.Int("height", 0, "The height of a snapshot to trust, as printed by dumputxo")
+	loadUTXOHash := loadUTXOCmd.String("hash", "", "The block hash of a snapshot to trust, as printed by dumputxo")
+	loadUTXOChecksum := loadUTXOCmd.String("checksum", "", "The checksum of a snapshot to trust, as printed by dumputxo")
+	addNodeAddr := addNodeCmd.String("addr", "", "The address of the node to connect to")
+	disconnectNodeAddr := disconnectNodeCmd.String("addr", "", "The address of the node to disconnect from")
 
 	switch os.Args[1] {
 	case "getbalance":
@@ -307,6 +840,61 @@ func (cli *CLI) Run()  {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "getsupply":
+		err := getSupplyCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getblock":
+		err := getBlockCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "gettransaction":
+		err := getTransactionCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "reindextx":
+		err := reindexTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "exportchain":
+		err := exportChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importchain":
+		err := importChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "dumputxo":
+		err := dumpUTXOCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "loadutxo":
+		err := loadUTXOCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getpeerinfo":
+		err := getPeerInfoCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "addnode":
+		err := addNodeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "disconnectnode":
+		err := disconnectNodeCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	default:
 		cli.printUsage()
 		os.Exit(1)
@@ -325,7 +913,7 @@ func (cli *CLI) Run()  {
 			createBlockchainCmd.Usage()
 			os.Exit(1)
 		}
-		cli.createBlockchain(*createBlockchainAddress, nodeID)
+		cli.createBlockchain(*createBlockchainAddress, nodeID, *createBlockchainTxIndex)
 	}
 
 	if createWalletCmd.Parsed() {
@@ -337,7 +925,7 @@ func (cli *CLI) Run()  {
 	}
 
 	if printChainCmd.Parsed() {
-		cli.printChain(nodeID)
+		cli.printChain(nodeID, *printChainHeaders, *printChainFrom, *printChainTo)
 	}
 	if reindexUTXOCmd.Parsed() {
 		cli.reindexUTXO(nodeID)
@@ -345,13 +933,85 @@ func (cli *CLI) Run()  {
 	if printUTXOCmd.Parsed() {
 		cli.printUTXOSet(nodeID)
 	}
+	if getSupplyCmd.Parsed() {
+		cli.getSupply(nodeID)
+	}
+	if getBlockCmd.Parsed() {
+		if *getBlockHeight < 0 {
+			getBlockCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getBlock(nodeID, *getBlockHeight)
+	}
+	if getTransactionCmd.Parsed() {
+		if *getTransactionID == "" {
+			getTransactionCmd.Usage()
+			os.Exit(1)
+		}
+		cli.getTransaction(*getTransactionID, nodeID)
+	}
+	if reindexTxCmd.Parsed() {
+		cli.reindexTransactions(nodeID)
+	}
+	if exportChainCmd.Parsed() {
+		if *exportChainOut == "" {
+			exportChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.exportChain(*exportChainOut, nodeID, *exportChainFrom, *exportChainTo)
+	}
+	if importChainCmd.Parsed() {
+		if *importChainIn == "" {
+			importChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importChain(*importChainIn, nodeID)
+	}
+	if dumpUTXOCmd.Parsed() {
+		if *dumpUTXOOut == "" {
+			dumpUTXOCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpUTXO(*dumpUTXOOut, nodeID)
+	}
+	if loadUTXOCmd.Parsed() {
+		if *loadUTXOIn == "" {
+			loadUTXOCmd.Usage()
+			os.Exit(1)
+		}
+		if (*loadUTXOHash == "") != (*loadUTXOChecksum == "") {
+			loadUTXOCmd.Usage()
+			os.Exit(1)
+		}
+		if *loadUTXOHash != "" {
+			AddTrustedSnapshot(SnapshotCheckpoint{*loadUTXOHeight, strings.ToLower(*loadUTXOHash), strings.ToLower(*loadUTXOChecksum)})
+		}
+		cli.loadUTXO(*loadUTXOIn, nodeID, *loadUTXOTxIndex)
+	}
+	if getPeerInfoCmd.Parsed() {
+		cli.getPeerInfo(nodeID)
+	}
+	if addNodeCmd.Parsed() {
+		if *addNodeAddr == "" {
+			addNodeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.addNode(*addNodeAddr, nodeID)
+	}
+	if disconnectNodeCmd.Parsed() {
+		if *disconnectNodeAddr == "" {
+			disconnectNodeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.disconnectNode(*disconnectNodeAddr, nodeID)
+	}
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 || *sendFee < 0 {
 			sendCmd.Usage()
 			os.Exit(1)
 		}
 
-		cli.send(*sendFrom, *sendTo, nodeID, *sendAmount, *sendMine)
+		cli.send(*sendFrom, *sendTo, nodeID, *sendNode, *sendAmount, *sendFee, *sendMine)
 	}
 	if startNodeCmd.Parsed() {
 		nodeID := os.Getenv("NODE_ID")
@@ -359,6 +1019,6 @@ func (cli *CLI) Run()  {
 			startNodeCmd.Usage()
 			os.Exit(1)
 		}
-		cli.startNode(nodeID, *startNodeMiner)
+		cli.startNode(nodeID, *startNodeMiner, *startNodePrune, *startNodeConf, *startNodeListen, *startNodeExternalAddr, *startNodeSeeds)
 	}
 }
\ No newline at end of file
@@ -0,0 +1,118 @@
+/*
+	节点配置
+	ListenAddr：节点监听的地址，例如0.0.0.0:3001可以接受其他机器的连接
+	ExternalAddr：告知其他节点的本节点地址，其他节点通过这个地址连接本节点，默认与ListenAddr相同
+	Seeds：启动时连接的种子节点，之后通过getaddr/addr消息从已连接的节点得知更多节点
+	配置可以写在配置文件中，每行一项key=value，#开头的行为注释，seed可以出现多次：
+		listen=0.0.0.0:3001
+		externaladdr=192.168.1.10:3001
+		seed=192.168.1.2:3000
+ */
+package BlockInfo
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+//没有配置种子节点时使用的默认种子节点
+var defaultSeeds = []string{"localhost:3000"}
+
+var ErrBadConfig = errors.New("bad node config")
+
+type NodeConfig struct {
+	ListenAddr   string
+	ExternalAddr string
+	Seeds        []string
+	MinerAddress string
+	PruneSize    int //区块数据占用空间的目标字节数，0表示不修剪
+}
+
+//节点nodeID的默认配置：监听localhost:<节点ID>，连接默认种子节点
+func DefaultNodeConfig(nodeID string) NodeConfig {
+	return NodeConfig{
+		ListenAddr: fmt.Sprintf("localhost:%s", nodeID),
+		Seeds:      defaultSeeds,
+	}
+}
+
+/*
+	从配置文件path读取配置，覆盖cfg中对应的项
+	文件中出现seed时，文件中的种子节点替换原有的种子节点
+ */
+func (cfg *NodeConfig) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var seeds []string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		kv := strings.SplitN(text, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("%w: %s:%d: %q", ErrBadConfig, path, line, text)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch key {
+		case "listen":
+			cfg.ListenAddr = value
+		case "externaladdr":
+			cfg.ExternalAddr = value
+		case "seed":
+			seeds = append(seeds, value)
+		default:
+			return fmt.Errorf("%w: %s:%d: unknown key %q", ErrBadConfig, path, line, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if len(seeds) > 0 {
+		cfg.Seeds = seeds
+	}
+
+	return nil
+}
+
+/*
+	检查配置中的地址，并补全ExternalAddr
+	监听所有网卡（例如0.0.0.0:3001）且没有设置ExternalAddr时，告知其他节点localhost上的地址
+ */
+func (cfg *NodeConfig) resolve() error {
+	host, port, err := net.SplitHostPort(cfg.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("%w: listen address %q: %v", ErrBadConfig, cfg.ListenAddr, err)
+	}
+
+	if cfg.ExternalAddr == "" {
+		cfg.ExternalAddr = cfg.ListenAddr
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			cfg.ExternalAddr = net.JoinHostPort("localhost", port)
+		}
+	}
+	_, _, err = net.SplitHostPort(cfg.ExternalAddr)
+	if err != nil {
+		return fmt.Errorf("%w: external address %q: %v", ErrBadConfig, cfg.ExternalAddr, err)
+	}
+
+	for _, seed := range cfg.Seeds {
+		_, _, err = net.SplitHostPort(seed)
+		if err != nil {
+			return fmt.Errorf("%w: seed %q: %v", ErrBadConfig, seed, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package BlockInfo
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNodeConfig(t *testing.T) {
+	cfg := DefaultNodeConfig("3001")
+	assert.NoError(t, cfg.resolve())
+	assert.Equal(t, "localhost:3001", cfg.ExternalAddr)
+	assert.Equal(t, defaultSeeds, cfg.Seeds)
+
+	path := filepath.Join(t.TempDir(), "node.conf")
+	content := "# test\nlisten = 0.0.0.0:3001\n\nseed=10.0.0.1:3000\nseed=10.0.0.2:3000\n"
+	assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+
+	cfg = DefaultNodeConfig("3001")
+	assert.NoError(t, cfg.Load(path))
+	assert.NoError(t, cfg.resolve())
+	assert.Equal(t, "0.0.0.0:3001", cfg.ListenAddr)
+	//监听所有网卡时告知其他节点localhost上的地址
+	assert.Equal(t, "localhost:3001", cfg.ExternalAddr)
+	assert.Equal(t, []string{"10.0.0.1:3000", "10.0.0.2:3000"}, cfg.Seeds)
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("bind=1.2.3.4:1\n"), 0644))
+	assert.True(t, errors.Is(cfg.Load(path), ErrBadConfig))
+
+	cfg = DefaultNodeConfig("3001")
+	cfg.Seeds = []string{"nohost"}
+	assert.True(t, errors.Is(cfg.resolve(), ErrBadConfig))
+}
@@ -0,0 +1,165 @@
+/*
+	节点的控制接口
+	运行中的节点在node_<节点ID>.sock（Unix域套接字）上接受本机命令行的请求，例如查看和管理已连接的节点，
+	请求和回复都使用与节点之间相同的消息格式，但不需要握手：
+	1、命令行发送一条请求消息，消息体为gob编码的参数
+	2、节点处理后回复一条消息，成功时命令为"ok"，消息体为gob编码的结果，失败时命令为"error"，消息体为错误信息
+ */
+package BlockInfo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+const controlSocket = "node_%s.sock"
+const controlTimeout = 10 * time.Second
+
+var ErrNodeNotRunning = errors.New("node is not running")
+var errUnknownControlCommand = errors.New("unknown control command")
+
+//控制接口只在本机的进程之间使用，请求和回复仍使用gob编码
+func gobEncode(data interface{}) ([]byte, error) {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+func gobDecode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+//监听节点nodeID的控制接口，上次运行遗留的套接字文件先被删除
+func listenControl(nodeID string) (net.Listener, error) {
+	path := fmt.Sprintf(controlSocket, nodeID)
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return net.Listen("unix", path)
+}
+
+func serveControl(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go handleControl(conn)
+	}
+}
+
+//处理一条控制请求并回复
+func handleControl(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	request, err := readMessage(bufio.NewReader(conn))
+	if err != nil {
+		return
+	}
+
+	reply, err := dispatchControl(request)
+	if err != nil {
+		writeMessage(conn, message{"error", []byte(err.Error())})
+		return
+	}
+	payload, err := gobEncode(reply)
+	if err != nil {
+		writeMessage(conn, message{"error", []byte(err.Error())})
+		return
+	}
+	writeMessage(conn, message{"ok", payload})
+}
+
+func dispatchControl(request message) (interface{}, error) {
+	switch request.Command {
+	case "getpeerinfo":
+		return peerManager.PeerInfo(), nil
+	case "addnode":
+		var addr string
+		err := gobDecode(request.Payload, &addr)
+		if err != nil {
+			return nil, err
+		}
+		peerManager.AddAddresses(addr)
+		_, err = connectPeer(addr, nodeChain)
+		return addr, err
+	case "disconnect":
+		var addr string
+		err := gobDecode(request.Payload, &addr)
+		if err != nil {
+			return nil, err
+		}
+		return addr, peerManager.Disconnect(addr)
+	}
+
+	return nil, errUnknownControlCommand
+}
+
+/*
+	向节点nodeID的控制接口发送请求，并将回复解码到reply
+	节点没有运行时返回ErrNodeNotRunning
+ */
+func callNode(nodeID, command string, args, reply interface{}) error {
+	conn, err := net.DialTimeout("unix", fmt.Sprintf(controlSocket, nodeID), dialTimeout)
+	if err != nil {
+		return ErrNodeNotRunning
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	payload, err := gobEncode(args)
+	if err != nil {
+		return err
+	}
+	err = writeMessage(conn, message{command, payload})
+	if err != nil {
+		return err
+	}
+
+	msg, err := readMessage(bufio.NewReader(conn))
+	if err != nil {
+		return err
+	}
+	if msg.Command == "error" {
+		return errors.New(string(msg.Payload))
+	}
+
+	return gobDecode(msg.Payload, reply)
+}
+
+//获取运行中的节点nodeID已连接的节点
+func GetPeerInfo(nodeID string) ([]PeerInfo, error) {
+	var infos []PeerInfo
+	err := callNode(nodeID, "getpeerinfo", "", &infos)
+
+	return infos, err
+}
+
+//让运行中的节点nodeID将addr加入地址簿并连接
+func AddNode(nodeID, addr string) error {
+	var reply string
+
+	return callNode(nodeID, "addnode", addr, &reply)
+}
+
+//让运行中的节点nodeID断开与addr的连接
+func DisconnectNode(nodeID, addr string) error {
+	var reply string
+
+	return callNode(nodeID, "disconnect", addr, &reply)
+}
@@ -0,0 +1,129 @@
+/*
+	难度调整
+	每个区块在Bits字段中以紧凑格式（与比特币的nBits相同）声明自己的目标值，
+	每隔retargetInterval个区块，根据这段区块实际花费的时间与期望时间的比值调整目标值，
+	调整幅度限制在4倍以内，目标值不能超过powLimit（即最低难度）
+ */
+package BlockInfo
+
+import (
+	"math/big"
+)
+
+//创世块的难度，表示区块头的哈希值前initialTargetBits位必须是0
+const initialTargetBits = 20
+//最低难度，目标值不能大于 2^(256-minTargetBits)
+const minTargetBits = 16
+//每隔多少个区块调整一次难度
+const retargetInterval = 10
+//期望的出块间隔（秒）
+const targetBlockSpacing = 60
+//一个调整周期的期望时间（秒）
+const targetTimespan = retargetInterval * targetBlockSpacing
+
+var powLimit = new(big.Int).Lsh(big.NewInt(1), 256-minTargetBits)
+
+//创世块的Bits
+var genesisBits = BigToCompact(new(big.Int).Lsh(big.NewInt(1), 256-initialTargetBits))
+
+/*
+	将紧凑格式的目标值转换为big.Int
+	紧凑格式的最高字节为指数（目标值的字节数），低3个字节为尾数，第24位为符号位
+	target = mantissa * 256^(exponent-3)
+ */
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	isNegative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var target *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		target = big.NewInt(int64(mantissa))
+	} else {
+		target = big.NewInt(int64(mantissa))
+		target.Lsh(target, 8*(exponent-3))
+	}
+
+	if isNegative {
+		target = target.Neg(target)
+	}
+
+	return target
+}
+
+//将big.Int表示的目标值转换为紧凑格式，CompactToBig的逆操作（精度只保留最高的3个字节）
+func BigToCompact(target *big.Int) uint32 {
+	if target.Sign() == 0 {
+		return 0
+	}
+
+	var mantissa uint32
+	exponent := uint(len(target.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(target.Bits()[0])
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		tn := new(big.Int).Rsh(target, 8*(exponent-3))
+		mantissa = uint32(tn.Bits()[0])
+	}
+
+	//尾数的最高位是符号位，若被占用，则将尾数右移一个字节，指数加1
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if target.Sign() < 0 {
+		compact |= 0x00800000
+	}
+
+	return compact
+}
+
+/*
+	计算父区块parent之后的下一个区块应当使用的Bits
+	1、若下一个区块的高度不是retargetInterval的整数倍，则沿用父区块的Bits
+	2、否则取本调整周期的第一个区块，计算周期内实际花费的时间，并限制在期望时间的1/4到4倍之间
+	3、新目标值 = 旧目标值 * 实际时间 / 期望时间，且不超过powLimit
+ */
+func (bc *Blockchain) nextBits(parent *blockIndex) (uint32, error) {
+	return nextBits(parent, bc.getBlockIndex)
+}
+
+//与Blockchain.nextBits相同，通过lookup查找祖先区块的索引，用于验证还没有保存进数据库的区块头
+func nextBits(parent *blockIndex, lookup func(hash []byte) (*blockIndex, error)) (uint32, error) {
+	if (parent.Height+1)%retargetInterval != 0 {
+		return parent.Bits, nil
+	}
+
+	first := parent
+	for i := 0; i < retargetInterval-1 && len(first.PrevHash) > 0; i++ {
+		prev, err := lookup(first.PrevHash)
+		if err != nil {
+			return 0, err
+		}
+		if prev == nil {
+			return 0, ErrPrevBlockNotFound
+		}
+		first = prev
+	}
+
+	actualTimespan := parent.Timestamp - first.Timestamp
+	if actualTimespan < targetTimespan/4 {
+		actualTimespan = targetTimespan / 4
+	} else if actualTimespan > targetTimespan*4 {
+		actualTimespan = targetTimespan * 4
+	}
+
+	newTarget := CompactToBig(parent.Bits)
+	newTarget.Mul(newTarget, big.NewInt(actualTimespan))
+	newTarget.Div(newTarget, big.NewInt(targetTimespan))
+
+	if newTarget.Cmp(powLimit) > 0 {
+		newTarget.Set(powLimit)
+	}
+
+	return BigToCompact(newTarget), nil
+}
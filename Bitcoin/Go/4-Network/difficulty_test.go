@@ -0,0 +1,78 @@
+package BlockInfo
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompactEncoding(t *testing.T) {
+	for _, compact := range []uint32{0x1d00ffff, 0x1e100000, genesisBits, 0x1b0404cb, 0x03123456, 0x02008000, 0x01120000, 0x04923456} {
+		assert.Equal(t, compact, BigToCompact(CompactToBig(compact)), "Compact target %08x round-trips", compact)
+	}
+
+	expected := new(big.Int).Lsh(big.NewInt(0xffff), 8*26)
+	assert.Equal(t, expected, CompactToBig(0x1d00ffff))
+	assert.Equal(t, big.NewInt(-0x12345600), CompactToBig(0x04923456), "Sign bit makes the target negative")
+	assert.Equal(t, uint32(0x02008000), BigToCompact(big.NewInt(0x80)), "Mantissa never uses the sign bit")
+	assert.Equal(t, uint32(0x05009234), BigToCompact(big.NewInt(0x92340000)), "Lower bytes are truncated")
+	assert.Equal(t, uint32(0), BigToCompact(big.NewInt(0)))
+}
+
+func TestNextBits(t *testing.T) {
+	//高度0到retargetInterval-1、目标值为target的区块，第一个到最后一个区块共花费timespan秒
+	newChain := func(timespan int64, target *big.Int) (*blockIndex, func(hash []byte) (*blockIndex, error)) {
+		chain := make(map[string]*blockIndex)
+		var parent *blockIndex
+		for height := 0; height < retargetInterval; height++ {
+			timestamp := 1000 + timespan*int64(height)/(retargetInterval-1)
+			index := &blockIndex{Hash: []byte{byte(height)}, Height: height, Timestamp: timestamp, Bits: BigToCompact(target)}
+			if parent != nil {
+				index.PrevHash = parent.Hash
+			}
+			chain[string(index.Hash)] = index
+			parent = index
+		}
+
+		return parent, func(hash []byte) (*blockIndex, error) {
+			return chain[string(hash)], nil
+		}
+	}
+	target := CompactToBig(genesisBits)
+
+	parent, lookup := newChain(targetTimespan, target)
+	middle, _ := lookup(parent.PrevHash)
+	bits, err := nextBits(middle, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, middle.Bits, bits, "Bits are kept between retargets")
+
+	bits, err = nextBits(parent, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, parent.Bits, bits, "Target is kept when blocks are on time")
+
+	parent, lookup = newChain(targetTimespan/2, target)
+	bits, err = nextBits(parent, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, new(big.Int).Rsh(target, 1), CompactToBig(bits), "Target halves when blocks are twice as fast")
+
+	parent, lookup = newChain(0, target)
+	bits, err = nextBits(parent, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, new(big.Int).Rsh(target, 2), CompactToBig(bits), "Target falls by at most 4 times")
+
+	parent, lookup = newChain(targetTimespan*10, target)
+	bits, err = nextBits(parent, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, new(big.Int).Lsh(target, 2), CompactToBig(bits), "Target rises by at most 4 times")
+
+	parent, lookup = newChain(targetTimespan*10, new(big.Int).Rsh(powLimit, 1))
+	bits, err = nextBits(parent, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, powLimit, CompactToBig(bits), "Target never exceeds powLimit")
+
+	_, err = nextBits(parent, func(hash []byte) (*blockIndex, error) {
+		return nil, nil
+	})
+	assert.Equal(t, ErrPrevBlockNotFound, err)
+}
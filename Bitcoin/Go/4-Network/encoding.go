@@ -0,0 +1,318 @@
+/*
+	区块和交易的规范二进制编码
+	取代依赖Go类型注册的gob编码，用于计算交易ID、保存进数据库以及在网络中传输，
+	其他语言按以下格式实现即可得到相同的字节和交易ID
+
+	varint：与比特币的CompactSize相同
+		n < 0xfd           1个字节
+		n <= 0xffff        0xfd + 2个字节（小端序）
+		n <= 0xffffffff    0xfe + 4个字节（小端序）
+		其他               0xff + 8个字节（小端序）
+		必须使用能表示n的最短格式，否则视为格式错误，保证同一个值只有一种编码
+	varbytes：varint(长度) + 字节内容
+
+	TXInput：  varbytes(Txid) | int32(VoutIndex) | varbytes(Signature) | varbytes(PubKey)
+	TXOutput： int64(Value) | varbytes(PubKeyHash)
+	Transaction：uint32(txVersion) | varint(输入数) | TXInput... | varint(输出数) | TXOutput...
+	Block：    BlockHeader(固定88个字节，见Block.go) | varint(交易数) | Transaction...
+	UTXO集中的输出：TXOutput | uint32(创建区块高度) | byte(是否coinbase)，key为 交易ID | uint32(输出索引号，大端序)
+
+	所有定长整数都采用小端序；交易ID和区块哈希不参与编码，由内容计算得到
+ */
+package BlockInfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+//交易编码格式的版本号
+const txVersion = 1
+//varbytes允许的最大长度，防止恶意数据导致分配过大的内存
+const maxVarBytesLen = 1 << 20
+
+var ErrMalformedData = errors.New("malformed encoded data")
+var ErrUnsupportedVersion = errors.New("unsupported encoding version")
+
+func writeVarInt(buf *bytes.Buffer, n uint64) {
+	var b [9]byte
+
+	switch {
+	case n < 0xfd:
+		buf.WriteByte(byte(n))
+	case n <= 0xffff:
+		b[0] = 0xfd
+		binary.LittleEndian.PutUint16(b[1:], uint16(n))
+		buf.Write(b[:3])
+	case n <= 0xffffffff:
+		b[0] = 0xfe
+		binary.LittleEndian.PutUint32(b[1:], uint32(n))
+		buf.Write(b[:5])
+	default:
+		b[0] = 0xff
+		binary.LittleEndian.PutUint64(b[1:], n)
+		buf.Write(b[:9])
+	}
+}
+
+func readVarInt(r *bytes.Reader) (uint64, error) {
+	prefix, err := r.ReadByte()
+	if err != nil {
+		return 0, ErrMalformedData
+	}
+
+	//min为该格式能表示的最小值，更小的值应使用更短的格式
+	var size int
+	var min uint64
+	switch prefix {
+	case 0xfd:
+		size, min = 2, 0xfd
+	case 0xfe:
+		size, min = 4, 0x10000
+	case 0xff:
+		size, min = 8, 0x100000000
+	default:
+		return uint64(prefix), nil
+	}
+
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:size]); err != nil {
+		return 0, ErrMalformedData
+	}
+	n := binary.LittleEndian.Uint64(b[:])
+	if n < min {
+		return 0, ErrMalformedData
+	}
+
+	return n, nil
+}
+
+func writeVarBytes(buf *bytes.Buffer, data []byte) {
+	writeVarInt(buf, uint64(len(data)))
+	buf.Write(data)
+}
+
+//读取varbytes，长度为0时返回nil
+func readVarBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxVarBytesLen || n > uint64(r.Len()) {
+		return nil, ErrMalformedData
+	}
+	if n == 0 {
+		return nil, nil
+	}
+
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, ErrMalformedData
+	}
+
+	return data, nil
+}
+
+func writeUint32(buf *bytes.Buffer, n uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], n)
+	buf.Write(b[:])
+}
+
+func readUint32(r *bytes.Reader) (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, ErrMalformedData
+	}
+	return binary.LittleEndian.Uint32(b[:]), nil
+}
+
+func writeUint64(buf *bytes.Buffer, n uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], n)
+	buf.Write(b[:])
+}
+
+func readUint64(r *bytes.Reader) (uint64, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, ErrMalformedData
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
+
+//读取元素个数，每个元素至少占用一个字节，因此个数不能超过剩余的字节数
+func readCount(r *bytes.Reader) (int, error) {
+	n, err := readVarInt(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(r.Len()) {
+		return 0, ErrMalformedData
+	}
+	return int(n), nil
+}
+
+func (in *TXInput) encode(buf *bytes.Buffer) {
+	writeVarBytes(buf, in.Txid)
+	writeUint32(buf, uint32(int32(in.VoutIndex)))
+	writeVarBytes(buf, in.Signature)
+	writeVarBytes(buf, in.PubKey)
+}
+
+func decodeTXInput(r *bytes.Reader) (TXInput, error) {
+	var in TXInput
+	var err error
+
+	if in.Txid, err = readVarBytes(r); err != nil {
+		return in, err
+	}
+	index, err := readUint32(r)
+	if err != nil {
+		return in, err
+	}
+	in.VoutIndex = int(int32(index))
+	if in.Signature, err = readVarBytes(r); err != nil {
+		return in, err
+	}
+	if in.PubKey, err = readVarBytes(r); err != nil {
+		return in, err
+	}
+
+	return in, nil
+}
+
+func (out *TXOutput) encode(buf *bytes.Buffer) {
+	writeUint64(buf, uint64(int64(out.Value)))
+	writeVarBytes(buf, out.PubKeyHash)
+}
+
+func decodeTXOutput(r *bytes.Reader) (TXOutput, error) {
+	var out TXOutput
+
+	value, err := readUint64(r)
+	if err != nil {
+		return out, err
+	}
+	out.Value = int(int64(value))
+	if out.PubKeyHash, err = readVarBytes(r); err != nil {
+		return out, err
+	}
+
+	return out, nil
+}
+
+func (tx *Transaction) encode(buf *bytes.Buffer) {
+	writeUint32(buf, txVersion)
+
+	writeVarInt(buf, uint64(len(tx.Vin)))
+	for i := range tx.Vin {
+		tx.Vin[i].encode(buf)
+	}
+
+	writeVarInt(buf, uint64(len(tx.Vout)))
+	for i := range tx.Vout {
+		tx.Vout[i].encode(buf)
+	}
+}
+
+//从r中解析一笔交易，交易ID由解析出的内容计算得到
+func decodeTransaction(r *bytes.Reader) (*Transaction, error) {
+	version, err := readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	if version != txVersion {
+		return nil, ErrUnsupportedVersion
+	}
+
+	tx := &Transaction{}
+
+	inputs, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < inputs; i++ {
+		in, err := decodeTXInput(r)
+		if err != nil {
+			return nil, err
+		}
+		tx.Vin = append(tx.Vin, in)
+	}
+
+	outputs, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < outputs; i++ {
+		out, err := decodeTXOutput(r)
+		if err != nil {
+			return nil, err
+		}
+		tx.Vout = append(tx.Vout, out)
+	}
+
+	tx.ID = tx.Hash()
+	return tx, nil
+}
+
+func encodeTransactions(buf *bytes.Buffer, txs []*Transaction) {
+	writeVarInt(buf, uint64(len(txs)))
+	for _, tx := range txs {
+		tx.encode(buf)
+	}
+}
+
+func decodeTransactions(r *bytes.Reader) ([]*Transaction, error) {
+	count, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var txs []*Transaction
+	for i := 0; i < count; i++ {
+		tx, err := decodeTransaction(r)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
+
+	return txs, nil
+}
+
+//解析完整的区块数据，区块哈希由区块头计算得到
+func decodeBlock(r *bytes.Reader) (*Block, error) {
+	headerData := make([]byte, blockHeaderLen)
+	if _, err := io.ReadFull(r, headerData); err != nil {
+		return nil, ErrMalformedData
+	}
+	header, err := DeserializeBlockHeader(headerData)
+	if err != nil {
+		return nil, err
+	}
+
+	txs, err := decodeTransactions(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Block{*header, header.Hash(), txs}, nil
+}
+
+//解析数据中的唯一一个对象，数据末尾不能有多余的字节
+func decodeAll(data []byte, decode func(r *bytes.Reader) error) error {
+	r := bytes.NewReader(data)
+
+	err := decode(r)
+	if err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return ErrMalformedData
+	}
+
+	return nil
+}
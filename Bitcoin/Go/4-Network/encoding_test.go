@@ -0,0 +1,111 @@
+package BlockInfo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//以下期望值按encoding.go中描述的格式独立计算得到，其他实现应得到完全相同的字节和哈希
+const (
+	goldenCoinbase   = "010000000100ffffffff0006676f6c64656e010a00000000000000140102030405060708090a0b0c0d0e0f1011121314"
+	goldenCoinbaseID = "b995c2b71ff087f9b1fde337c8262e765d4c546a51de2888c196fb46e4a7c415"
+	goldenTx         = "010000000120aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa0100000040" +
+		"11111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111111" +
+		"40" +
+		"22222222222222222222222222222222222222222222222222222222222222222222222222222222222222222222222222222222222222222222222222222222" +
+		"0205000000000000001433333333333333333333333333333333333333330300000000000000144444444444444444444444444444444444444444"
+	goldenTxID       = "d3748391a0e7cfdded10d8d80f99d0beb61bc34ad7cb834d6ff6ca8215a03879"
+	goldenHeader     = "010000005555555555555555555555555555555555555555555555555555555555555555b995c2b71ff087f9b1fde337c8262e765d4c546a51de2888c196fb46e4a7c41500105e5f000000000000101e3930000007000000"
+	goldenHeaderHash = "953cf8d35611d4a167cad2133c306dc366e2321702490123e77548e3399c67c5"
+)
+
+func goldenTransactions() (*Transaction, *Transaction) {
+	pubKeyHash := make([]byte, 20)
+	for i := range pubKeyHash {
+		pubKeyHash[i] = byte(i + 1)
+	}
+	coinbase := &Transaction{nil, []TXInput{{[]byte{}, -1, nil, []byte("golden")}}, []TXOutput{{10, pubKeyHash}}}
+	coinbase.ID = coinbase.Hash()
+
+	tx := &Transaction{
+		nil,
+		[]TXInput{{bytes.Repeat([]byte{0xaa}, 32), 1, nil, bytes.Repeat([]byte{0x22}, 64)}},
+		[]TXOutput{{5, bytes.Repeat([]byte{0x33}, 20)}, {3, bytes.Repeat([]byte{0x44}, 20)}},
+	}
+	tx.ID = tx.Hash()
+	tx.Vin[0].Signature = bytes.Repeat([]byte{0x11}, 64)
+
+	return coinbase, tx
+}
+
+func TestTransactionEncoding(t *testing.T) {
+	coinbase, tx := goldenTransactions()
+
+	assert.Equal(t, goldenCoinbase, hex.EncodeToString(coinbase.Serialize()), "Coinbase encoding is correct")
+	assert.Equal(t, goldenCoinbaseID, hex.EncodeToString(coinbase.ID), "Coinbase ID is correct")
+	assert.Equal(t, goldenTx, hex.EncodeToString(tx.Serialize()), "Transaction encoding is correct")
+	assert.Equal(t, goldenTxID, hex.EncodeToString(tx.ID), "Transaction ID does not depend on signatures")
+
+	data, _ := hex.DecodeString(goldenTx)
+	decoded, err := DeserializeTransaction(data)
+	assert.NoError(t, err)
+	assert.Equal(t, goldenTxID, hex.EncodeToString(decoded.ID), "Decoded transaction ID is correct")
+	assert.Equal(t, data, decoded.Serialize(), "Decoded transaction re-encodes to the same bytes")
+}
+
+func TestBlockEncoding(t *testing.T) {
+	coinbase, tx := goldenTransactions()
+	block := &Block{
+		BlockHeader{1, bytes.Repeat([]byte{0x55}, 32), coinbase.ID, 1600000000, 0x1e100000, 12345, 7},
+		nil,
+		[]*Transaction{coinbase, tx},
+	}
+	block.Hash = block.BlockHeader.Hash()
+
+	assert.Equal(t, goldenHeader, hex.EncodeToString(block.BlockHeader.Serialize()), "Header encoding is correct")
+	assert.Equal(t, goldenHeaderHash, hex.EncodeToString(block.Hash), "Block hash is correct")
+	assert.Equal(t, goldenHeader+"02"+goldenCoinbase+goldenTx, hex.EncodeToString(block.Serialize()), "Block encoding is correct")
+
+	decoded, err := DeserializeBlock(block.Serialize())
+	assert.NoError(t, err)
+	assert.Equal(t, block.Hash, decoded.Hash, "Decoded block hash is correct")
+	assert.Equal(t, goldenTxID, hex.EncodeToString(decoded.Transactions[1].ID), "Decoded transaction ID is correct")
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	data, _ := hex.DecodeString(goldenTx)
+
+	_, err := decodeTransaction(bytes.NewReader(data[:len(data)-1]))
+	assert.Equal(t, ErrMalformedData, err, "Truncated transaction is rejected")
+
+	err = decodeAll(append(data, 0x00), func(r *bytes.Reader) error {
+		_, err := decodeTransaction(r)
+		return err
+	})
+	assert.Equal(t, ErrMalformedData, err, "Trailing bytes are rejected")
+
+	_, err = readVarBytes(bytes.NewReader([]byte{0xfe, 0xff, 0xff, 0xff, 0x7f}))
+	assert.Equal(t, ErrMalformedData, err, "Oversized length prefix is rejected")
+
+	_, err = DeserializeTransaction(data[:len(data)-1])
+	assert.Equal(t, ErrMalformedData, err, "Malformed transaction is returned as an error")
+}
+
+func TestVarInt(t *testing.T) {
+	for _, n := range []uint64{0, 0xfc, 0xfd, 0xffff, 0x10000, 0xffffffff, 0x100000000, 1<<64 - 1} {
+		var buf bytes.Buffer
+		writeVarInt(&buf, n)
+		decoded, err := readVarInt(bytes.NewReader(buf.Bytes()))
+		assert.NoError(t, err)
+		assert.Equal(t, n, decoded)
+	}
+
+	for _, encoded := range []string{"fd0100", "fdfc00", "fe01000000", "feffff0000", "ff0100000000000000", "ffffffffff00000000"} {
+		data, _ := hex.DecodeString(encoded)
+		_, err := readVarInt(bytes.NewReader(data))
+		assert.Equal(t, ErrMalformedData, err, "Non-minimal encoding %s is rejected", encoded)
+	}
+}
@@ -0,0 +1,37 @@
+/*
+	BlockInfo包返回的通用错误
+	包中的函数不再调用log.Panic或os.Exit，而是将错误返回给调用方，只有命令行（cli.go）在出错时退出程序，
+	调用方可以通过errors.Is判断错误的类别，例如所有"不存在"类的错误都满足 errors.Is(err, ErrNotFound)
+ */
+package BlockInfo
+
+import "errors"
+
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrChainExists       = errors.New("blockchain already exists")
+	ErrInsufficientFunds = errors.New("not enough funds")
+	ErrInvalidAddress    = errors.New("address is not valid")
+	ErrInvalidAmount     = errors.New("amount must be positive and fee must not be negative")
+
+	ErrChainNotFound  = notFound("blockchain")
+	ErrWalletNotFound = notFound("wallet")
+	ErrOutputNotFound = notFound("unspent output")
+)
+
+//"不存在"类的错误，errors.Is(err, ErrNotFound)对所有此类错误都成立
+type notFoundError struct {
+	what string
+}
+
+func notFound(what string) error {
+	return &notFoundError{what}
+}
+
+func (e *notFoundError) Error() string {
+	return e.what + " is not found"
+}
+
+func (e *notFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
@@ -0,0 +1,306 @@
+/*
+	握手
+	1、主动建立连接的一方先发送version消息
+	2、收到version消息后检查对方的协议版本、Nonce和用户代理，不兼容或连接到自己时断开连接，
+	   否则回复verack，还没有发送过version消息的一方（被连接的一方）同时发送自己的version消息
+	3、双方都收到对方的version和verack后握手完成，之后才处理其他消息，
+	   对方的区块比自己多且能够提供缺少的区块时开始同步
+ */
+package BlockInfo
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+//节点提供的服务
+type ServiceFlag uint64
+
+const (
+	SFNodeNetwork        ServiceFlag = 1 << iota //保存了全部区块数据，可以提供任意区块
+	SFNodeNetworkLimited                         //修剪模式或从UTXO快照启动的节点，只能提供PruneHeight及之后的区块
+	SFNodeSPV                                    //只保存区块头的轻节点，不提供区块数据
+)
+
+func (f ServiceFlag) String() string {
+	var names []string
+	if f&SFNodeNetwork != 0 {
+		names = append(names, "full")
+	}
+	if f&SFNodeNetworkLimited != 0 {
+		names = append(names, "pruned")
+	}
+	if f&SFNodeSPV != 0 {
+		names = append(names, "spv")
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+
+	return strings.Join(names, ",")
+}
+
+const userAgent = "/BlockChain4Go:0.2.0/"
+const maxUserAgentLen = 256
+
+var (
+	errSelfConnection      = errors.New("connected to self")
+	errIncompatibleVersion = errors.New("peer protocol version is not supported")
+	errBadUserAgent        = errors.New("peer user agent is too long")
+	errDuplicateVersion    = errors.New("peer sent more than one version message")
+	errUnexpectedVerack    = errors.New("peer sent verack before receiving version")
+)
+
+//节点启动时生成的随机数，用于发现连接到自己的连接
+var localNonce = newNonce()
+
+func newNonce() uint64 {
+	var b [8]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return uint64(time.Now().UnixNano())
+	}
+
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+/*
+	生成本节点的version消息
+	bc为nil表示不运行节点的客户端（例如send命令），不提供任何服务
+ */
+func newVersion(bc *Blockchain) (verzion, error) {
+	v := verzion{
+		Version:   nodeVersion,
+		Timestamp: time.Now().Unix(),
+		Nonce:     localNonce,
+		UserAgent: userAgent,
+		AddrFrom:  nodeListenAddress,
+	}
+	if bc == nil {
+		return v, nil
+	}
+
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		return v, err
+	}
+	pruneHeight, err := bc.PruneHeight()
+	if err != nil {
+		return v, err
+	}
+
+	v.StartHeight = bestHeight
+	v.PruneHeight = pruneHeight
+	v.Services = SFNodeNetwork
+	if pruneHeight > 0 {
+		v.Services = SFNodeNetworkLimited
+	}
+
+	return v, nil
+}
+
+//检查对方的version消息是否可以接受
+func checkVersion(v verzion) error {
+	if v.Nonce == localNonce {
+		return errSelfConnection
+	}
+	if v.Version < minPeerVersion {
+		return errIncompatibleVersion
+	}
+	if len(v.UserAgent) > maxUserAgentLen {
+		return errBadUserAgent
+	}
+
+	return nil
+}
+
+func (p *peer) sendVersion(bc *Blockchain) error {
+	v, err := newVersion(bc)
+	if err != nil {
+		return err
+	}
+	payload := encodePayload(v)
+
+	p.mu.Lock()
+	p.versionSent = true
+	p.mu.Unlock()
+
+	fmt.Println("command version")
+	return p.queueMessage(message{"version", payload})
+}
+
+//握手完成时发送握手期间等待的消息
+func (p *peer) finishHandshake() {
+	p.mu.Lock()
+	if !(p.versionSent && p.versionReceived && p.verackReceived) {
+		p.mu.Unlock()
+		return
+	}
+	pending := p.pending
+	p.pending = nil
+	version, agent, services := p.version, p.userAgent, p.services
+	p.mu.Unlock()
+
+	fmt.Printf("Connected to %s (version %d, %s, services %s)\n", p, version, agent, services)
+	for _, msg := range pending {
+		if p.queueMessage(msg) != nil {
+			return
+		}
+	}
+}
+
+/*
+	处理对方的version消息
+	1、检查对方的版本等信息，不兼容时断开连接
+	2、记录对方的信息，对方主动连接时以其声明的监听地址登记连接，回复verack，被连接的一方同时发送自己的version
+	3、主动连接对方时，记录与连接的地址成功握手，并向对方请求其已知的地址；
+	   对方主动连接时，其声明的监听地址没有经过验证，只作为未连接过的地址加入地址簿，不记录为成功握手，也不转发给其他节点
+	4、对方的区块比自己多时，对方保存了全部区块，或者对方修剪掉的区块自己都已经有了，才向对方请求区块头
+ */
+func handleVersion(p *peer, request []byte, bc *Blockchain) error {
+	var payload verzion
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	received := p.versionReceived
+	p.mu.Unlock()
+	if received {
+		return errDuplicateVersion
+	}
+
+	err = checkVersion(payload)
+	if err != nil {
+		p.disconnect(err)
+		return nil
+	}
+
+	//对方主动连接时，之后发往其监听地址的消息都通过这个连接发送，主动建立的连接保持以连接的地址登记
+	if p.inbound && payload.AddrFrom != "" {
+		peerManager.rename(p, payload.AddrFrom)
+	}
+
+	p.mu.Lock()
+	p.versionReceived = true
+	p.version = payload.Version
+	if p.version > nodeVersion {
+		p.version = nodeVersion
+	}
+	p.services = payload.Services
+	p.userAgent = payload.UserAgent
+	p.startHeight = payload.StartHeight
+	p.pruneHeight = payload.PruneHeight
+	versionSent := p.versionSent
+	p.mu.Unlock()
+
+	err = p.queueMessage(message{"verack", nil})
+	if err != nil {
+		return err
+	}
+	if !versionSent {
+		err = p.sendVersion(bc)
+		if err != nil {
+			return err
+		}
+	}
+	p.finishHandshake()
+
+	if p.inbound {
+		peerManager.AddAddresses(payload.AddrFrom)
+	} else {
+		//连接的地址已经成功握手，主动建立连接的一方向对方请求其已知的地址
+		peerManager.markSuccess(p.String())
+		fmt.Println("command getaddr")
+		err = p.queueMessage(message{"getaddr", nil})
+		if err != nil {
+			return err
+		}
+	}
+
+	myBestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	if myBestHeight >= payload.StartHeight {
+		return nil
+	}
+
+	if payload.Services&SFNodeNetwork == 0 && (payload.Services&SFNodeNetworkLimited == 0 || payload.PruneHeight > myBestHeight+1) {
+		//对方已修剪了本节点缺少的区块，或者不提供区块数据，无法从对方同步
+		fmt.Printf("%s cannot provide blocks above height %d (services %s, pruned below %d)\n", p, myBestHeight, payload.Services, payload.PruneHeight)
+		return nil
+	}
+
+	return sendGetHeaders(p, bc)
+}
+
+//处理对方的verack消息，对方已接受本节点的version
+func handleVerack(p *peer) error {
+	p.mu.Lock()
+	if !p.versionSent || p.verackReceived {
+		p.mu.Unlock()
+		p.disconnect(errUnexpectedVerack)
+		return nil
+	}
+	p.verackReceived = true
+	p.mu.Unlock()
+
+	p.finishHandshake()
+
+	return nil
+}
+
+/*
+	不运行节点的客户端与节点握手
+	发送不提供任何服务的version消息，等待对方的version和verack，收到对方的version后回复verack
+ */
+func clientHandshake(conn io.ReadWriter) error {
+	v, err := newVersion(nil)
+	if err != nil {
+		return err
+	}
+	payload := encodePayload(v)
+	err = writeMessage(conn, message{"version", payload})
+	if err != nil {
+		return err
+	}
+
+	versionReceived, verackReceived := false, false
+	for !versionReceived || !verackReceived {
+		msg, err := readMessage(conn)
+		if err != nil {
+			return err
+		}
+
+		switch msg.Command {
+		case "version":
+			var remote verzion
+			err = decodePayload(msg.Payload, &remote)
+			if err != nil {
+				return err
+			}
+			err = checkVersion(remote)
+			if err != nil {
+				return err
+			}
+			versionReceived = true
+
+			err = writeMessage(conn, message{"verack", nil})
+			if err != nil {
+				return err
+			}
+		case "verack":
+			verackReceived = true
+		}
+	}
+
+	return nil
+}
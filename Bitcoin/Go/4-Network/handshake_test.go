@@ -0,0 +1,105 @@
+package BlockInfo
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckVersion(t *testing.T) {
+	v := verzion{Version: nodeVersion, Nonce: localNonce + 1, UserAgent: userAgent}
+	assert.NoError(t, checkVersion(v))
+
+	self := v
+	self.Nonce = localNonce
+	assert.Equal(t, errSelfConnection, checkVersion(self))
+
+	old := v
+	old.Version = minPeerVersion - 1
+	assert.Equal(t, errIncompatibleVersion, checkVersion(old))
+
+	assert.Equal(t, "full,pruned", (SFNodeNetwork | SFNodeNetworkLimited).String())
+	assert.Equal(t, "none", ServiceFlag(0).String())
+}
+
+func TestHandshake(t *testing.T) {
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	bc, err := CreateBlockchainInStore(string(wallet.GetAddress()), NewMemoryStore())
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	p, err := startPeer(newPeer(remote, "pipe", true), bc)
+	assert.NoError(t, err)
+	defer p.disconnect(errPeerDisconnected)
+
+	//握手完成之前发往对方的消息先保存
+	assert.NoError(t, p.queueMessage(message{"getheaders", nil}))
+
+	version := encodePayload(verzion{Version: nodeVersion, Nonce: localNonce + 1, UserAgent: "/test/"})
+	assert.NoError(t, writeMessage(local, message{"version", version}))
+
+	msg, err := readMessage(local)
+	assert.NoError(t, err)
+	assert.Equal(t, "verack", msg.Command)
+	msg, err = readMessage(local)
+	assert.NoError(t, err)
+	assert.Equal(t, "version", msg.Command)
+	var v verzion
+	assert.NoError(t, decodePayload(msg.Payload, &v))
+	assert.Equal(t, SFNodeNetwork, v.Services)
+	assert.Equal(t, localNonce, v.Nonce)
+	assert.False(t, p.handshakeComplete())
+
+	assert.NoError(t, writeMessage(local, message{"verack", nil}))
+	msg, err = readMessage(local)
+	assert.NoError(t, err)
+	assert.Equal(t, "getheaders", msg.Command)
+	assert.True(t, p.handshakeComplete())
+
+	//重复的verack断开连接
+	assert.NoError(t, writeMessage(local, message{"verack", nil}))
+	select {
+	case <-p.quit:
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer is not disconnected")
+	}
+}
+
+func TestHandshakeInboundAddress(t *testing.T) {
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	bc, err := CreateBlockchainInStore(string(wallet.GetAddress()), NewMemoryStore())
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+	defer func(saved *PeerManager) { peerManager = saved }(peerManager)
+	peerManager = NewPeerManager()
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	p, err := startPeer(newPeer(remote, "pipe", true), bc)
+	assert.NoError(t, err)
+	defer p.disconnect(errPeerDisconnected)
+
+	version := encodePayload(verzion{Version: nodeVersion, Nonce: localNonce + 1, UserAgent: "/test/", AddrFrom: "10.0.0.5:3005"})
+	assert.NoError(t, writeMessage(local, message{"version", version}))
+	for _, command := range []string{"verack", "version"} {
+		msg, err := readMessage(local)
+		assert.NoError(t, err)
+		assert.Equal(t, command, msg.Command)
+	}
+	//等待version消息处理完
+	handleLock.Lock()
+	handleLock.Unlock()
+
+	//对方声明的地址只作为未连接过的地址加入地址簿
+	assert.Equal(t, p, peerManager.find("10.0.0.5:3005"))
+	assert.Equal(t, []string{"10.0.0.5:3005"}, peerManager.KnownAddresses())
+	peerManager.mu.Lock()
+	assert.True(t, peerManager.addrs["10.0.0.5:3005"].LastSuccess.IsZero())
+	peerManager.mu.Unlock()
+}
@@ -0,0 +1,604 @@
+/*
+	先同步区块头的区块下载（headers-first）
+	1、向对方发送getheaders消息，其中的区块定位器（block locator）是本节点链上从链尾向前、间隔逐渐加倍的区块哈希，
+	   对方从定位器中第一个在其主链上的区块之后开始，最多回复maxHeadersItems个主链区块头（headers消息）
+	2、收到的区块头在下载区块数据之前先验证：与前一个区块头相连、高度连续、目标值符合难度调整规则、时间戳有效、工作量证明有效，
+	   验证通过的区块头保存在内存中，收到满额的headers消息时继续向对方请求后续的区块头，
+   对方的区块头同步完成后只保留累计工作量最大、且超过本节点主链的分支，内存中的区块头最多maxHeadersInMemory个
+	3、对方的区块头链同步完成、且累计工作量超过本节点的主链时，按高度从低到高排出需要下载的区块，
+	   在从下一个需要链接的区块开始的blockDownloadWindow个区块范围内（滑动窗口），同时向多个节点请求区块数据，
+	   每个节点最多同时下载maxBlocksInFlightPerPeer个区块，超过blockDownloadTimeout没有收到的区块改为向其他节点请求
+	4、收到的区块先暂存，按高度顺序依次交给AddBlock链接，链接后窗口向后移动
+ */
+package BlockInfo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+//一条headers消息中最多包含的区块头数
+const maxHeadersItems = 2000
+//区块定位器中逐个列出的最近区块数，之后的间隔逐渐加倍
+const locatorDenseItems = 10
+//内存中最多保存的区块头数，达到上限后不再请求后续的区块头，先下载已有的区块，下载完成后再继续同步
+const maxHeadersInMemory = 20 * maxHeadersItems
+
+const blockDownloadWindow = 128
+const maxBlocksInFlightPerPeer = 16
+const blockDownloadTimeout = 20 * time.Second
+//检查下载超时的间隔
+const blockDownloadTick = time.Second
+
+var errHeadersNotConnected = errors.New("headers do not connect to a known block")
+var errTooManyHeaders = errors.New("too many headers")
+
+type getheaders struct {
+	Locator  [][]byte
+}
+
+//Headers中每一项都是按固定格式序列化的区块头
+type headers struct {
+	Headers  [][]byte
+}
+
+//正在下载的区块：向哪个节点请求、何时请求
+type blockRequest struct {
+	peer *peer
+	sent time.Time
+}
+
+//已下载、等待按高度顺序链接的区块，以及发送该区块的节点
+type receivedBlock struct {
+	block *Block
+	from  *peer
+}
+
+/*
+	区块下载的状态，只在持有handleLock时访问
+	headers：已验证、但区块数据还没有链接到区块链的区块头，key为区块哈希的十六进制
+	bestHeader：headers中累计工作量最大的区块头
+	queue：需要下载的区块，按高度从低到高排列，queue[0]为下一个需要链接的区块
+	inFlight：已请求还没有收到的区块
+	received：已收到、等待链接的区块
+	failed：超时或对方无法提供的区块，记录上次请求的节点，重新请求时优先选择其他节点
+ */
+type blockDownloader struct {
+	headers    map[string]*blockIndex
+	bestHeader *blockIndex
+	queue      []*blockIndex
+	inFlight   map[string]*blockRequest
+	received   map[string]*receivedBlock
+	failed     map[string]*peer
+}
+
+var blockSync = newBlockDownloader()
+
+func newBlockDownloader() *blockDownloader {
+	return &blockDownloader{
+		headers:  make(map[string]*blockIndex),
+		inFlight: make(map[string]*blockRequest),
+		received: make(map[string]*receivedBlock),
+		failed:   make(map[string]*peer),
+	}
+}
+
+//按哈希查找区块索引，先查找内存中的区块头，再查找数据库中的区块索引，都不存在时返回nil
+func (d *blockDownloader) index(bc *Blockchain, hash []byte) (*blockIndex, error) {
+	if bi, ok := d.headers[hex.EncodeToString(hash)]; ok {
+		return bi, nil
+	}
+
+	return bc.getBlockIndex(hash)
+}
+
+/*
+	生成从区块索引from向前的区块定位器
+	最近的locatorDenseItems个区块逐个列出，之后每次向前的间隔加倍，最后一项总是创世块
+ */
+func (d *blockDownloader) locator(bc *Blockchain, from *blockIndex) ([][]byte, error) {
+	var locator [][]byte
+
+	step := 1
+	index := from
+	for {
+		locator = append(locator, index.Hash)
+		if len(index.PrevHash) == 0 {
+			return locator, nil
+		}
+		if len(locator) >= locatorDenseItems {
+			step *= 2
+		}
+
+		for i := 0; i < step && len(index.PrevHash) > 0; i++ {
+			prev, err := d.index(bc, index.PrevHash)
+			if err != nil {
+				return nil, err
+			}
+			if prev == nil {
+				return nil, ErrPrevBlockNotFound
+			}
+			index = prev
+		}
+	}
+}
+
+/*
+	向节点p请求区块头
+	已有比主链累计工作量更大的区块头时从该区块头开始定位，否则从主链链尾开始
+ */
+func sendGetHeaders(p *peer, bc *Blockchain) error {
+	from, err := bc.getBlockIndex(bc.tip)
+	if err != nil {
+		return err
+	}
+	if from == nil {
+		return ErrBlockNotFound
+	}
+	if blockSync.bestHeader != nil && blockSync.bestHeader.totalWork().Cmp(from.totalWork()) > 0 {
+		from = blockSync.bestHeader
+	}
+
+	locator, err := blockSync.locator(bc, from)
+	if err != nil {
+		return err
+	}
+	payload := encodePayload(getheaders{locator})
+
+	fmt.Println("command getheaders")
+	return p.queueMessage(message{"getheaders", payload})
+}
+
+/*
+	在主链上查找区块定位器中第一个存在的区块，返回其高度
+	定位器中的区块都不在主链上时返回-1，即从创世块开始
+ */
+func (bc *Blockchain) findLocatorFork(locator [][]byte) (int, error) {
+	fork := -1
+
+	err := bc.Db.View(func(tx StoreTx) error {
+		for _, hash := range locator {
+			bi, err := getBlockIndex(tx, hash)
+			if err != nil {
+				return err
+			}
+			if bi != nil && bytes.Equal(getHashByHeight(tx, bi.Height), hash) {
+				fork = bi.Height
+				return nil
+			}
+		}
+		return nil
+	})
+
+	return fork, err
+}
+
+//获取主链上高度从from到to（包含）的区块头，区块数据被修剪的区块也保留了区块头
+func (bc *Blockchain) GetHeaders(from, to int) ([]*BlockHeader, error) {
+	var result []*BlockHeader
+
+	err := bc.Db.View(func(tx StoreTx) error {
+		for height := from; height <= to; height++ {
+			hash := getHashByHeight(tx, height)
+			if hash == nil {
+				return ErrBlockNotFound
+			}
+			header, err := getBlockHeader(tx, hash)
+			if err != nil {
+				return err
+			}
+			result = append(result, header)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+//回复对方的getheaders，发送定位器之后最多maxHeadersItems个主链区块头
+func handleGetHeaders(p *peer, request []byte, bc *Blockchain) error {
+	var payload getheaders
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+
+	fork, err := bc.findLocatorFork(payload.Locator)
+	if err != nil {
+		return err
+	}
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	to := bestHeight
+	if to > fork+maxHeadersItems {
+		to = fork + maxHeadersItems
+	}
+	list, err := bc.GetHeaders(fork+1, to)
+	if err != nil {
+		return err
+	}
+
+	var data headers
+	for _, header := range list {
+		data.Headers = append(data.Headers, header.Serialize())
+	}
+	response := encodePayload(data)
+
+	fmt.Println("command headers")
+	return p.queueMessage(message{"headers", response})
+}
+
+/*
+	验证父区块parent之后的区块头header，不依赖区块数据
+	1、父区块不是无效区块，高度为父区块高度+1
+	2、目标值符合难度调整规则，时间戳晚于前面区块的中位时间，且不晚于当前时间太多
+	3、工作量证明有效
+ */
+func (d *blockDownloader) checkHeader(bc *Blockchain, header *BlockHeader, parent *blockIndex) (*blockIndex, error) {
+	block := &Block{*header, header.Hash(), nil}
+
+	if parent.Invalid {
+		return nil, invalidBlock(block, ErrPrevBlockInvalid)
+	}
+	if header.Height != parent.Height+1 {
+		return nil, invalidBlock(block, ErrBadHeight)
+	}
+	lookup := func(hash []byte) (*blockIndex, error) {
+		return d.index(bc, hash)
+	}
+	bits, err := nextBits(parent, lookup)
+	if err != nil {
+		return nil, err
+	}
+	if header.Bits != bits {
+		return nil, invalidBlock(block, ErrBadDifficulty)
+	}
+	err = checkBlockTime(header, parent, lookup)
+	if err != nil {
+		return nil, invalidBlock(block, err)
+	}
+	if !NewProofOfWork(block).Validate() {
+		return nil, invalidBlock(block, ErrInvalidPoW)
+	}
+
+	return newBlockIndex(block, parent), nil
+}
+
+/*
+	处理对方发来的区块头
+	1、逐个验证区块头，区块头无效时封禁对方，与已知区块不相连时忽略
+	2、记录累计工作量最大的区块头，并更新对方的区块高度
+	3、收到满额的区块头、且内存中的区块头未达到上限时继续向对方请求，
+	   否则丢弃累计工作量不是最大、或不超过主链的分支，开始下载区块数据
+ */
+func handleHeaders(p *peer, request []byte, bc *Blockchain) error {
+	var payload headers
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+	if len(payload.Headers) > maxHeadersItems {
+		p.misbehaving(banScoreMalformedData, errTooManyHeaders)
+		return nil
+	}
+	fmt.Printf("Received %d headers\n", len(payload.Headers))
+	if len(payload.Headers) == 0 {
+		return nil
+	}
+
+	d := blockSync
+	var last *blockIndex
+	for i, data := range payload.Headers {
+		header, err := DeserializeBlockHeader(data)
+		if err != nil {
+			return &malformedPayloadError{err}
+		}
+
+		parent := last
+		if i == 0 || !bytes.Equal(header.PrevBlockHash, last.Hash) {
+			parent, err = d.index(bc, header.PrevBlockHash)
+			if err != nil {
+				return err
+			}
+		}
+		if parent == nil {
+			//之前同步的分支可能已被丢弃，从本节点的定位器重新请求
+			fmt.Printf("%s from %s\n", errHeadersNotConnected, p)
+			return sendGetHeaders(p, bc)
+		}
+
+		known, err := d.index(bc, header.Hash())
+		if err != nil {
+			return err
+		}
+		if known != nil {
+			last = known
+			continue
+		}
+
+		last, err = d.checkHeader(bc, header, parent)
+		if err != nil {
+			//时间戳太新可能只是双方的时钟不一致，不封禁对方
+			var invalid *BlockValidationError
+			if errors.As(err, &invalid) && !errors.Is(err, ErrTimeTooNew) {
+				p.misbehaving(banScoreInvalidBlock, err)
+				return nil
+			}
+			return err
+		}
+		d.headers[hex.EncodeToString(last.Hash)] = last
+	}
+
+	p.mu.Lock()
+	if last.Height > p.startHeight {
+		p.startHeight = last.Height
+	}
+	p.mu.Unlock()
+
+	if d.bestHeader == nil || last.totalWork().Cmp(d.bestHeader.totalWork()) > 0 {
+		d.bestHeader = last
+	}
+
+	if len(payload.Headers) == maxHeadersItems && len(d.headers) < maxHeadersInMemory {
+		return sendGetHeaders(p, bc)
+	}
+
+	err = d.pruneHeaders(bc)
+	if err != nil {
+		return err
+	}
+	err = d.updateQueue(bc)
+	if err != nil {
+		return err
+	}
+	d.schedule()
+
+	return nil
+}
+
+/*
+	只保留从bestHeader沿父区块向前的区块头，其他分支的累计工作量不会超过bestHeader
+	bestHeader的累计工作量不超过主链链尾时，内存中的区块头都不再需要
+ */
+func (d *blockDownloader) pruneHeaders(bc *Blockchain) error {
+	if d.bestHeader == nil {
+		return nil
+	}
+	tip, err := bc.getBlockIndex(bc.tip)
+	if err != nil {
+		return err
+	}
+
+	kept := make(map[string]*blockIndex)
+	if tip == nil || d.bestHeader.totalWork().Cmp(tip.totalWork()) > 0 {
+		for key := hex.EncodeToString(d.bestHeader.Hash); ; {
+			bi, ok := d.headers[key]
+			if !ok {
+				break
+			}
+			kept[key] = bi
+			key = hex.EncodeToString(bi.PrevHash)
+		}
+	} else {
+		d.bestHeader = nil
+	}
+	d.headers = kept
+
+	return nil
+}
+
+/*
+	累计工作量最大的区块头超过主链链尾时，重新排出需要下载的区块：
+	从该区块头沿父区块向前，直到区块已在数据库中，再按高度从低到高排列
+ */
+func (d *blockDownloader) updateQueue(bc *Blockchain) error {
+	if d.bestHeader == nil {
+		return nil
+	}
+	tip, err := bc.getBlockIndex(bc.tip)
+	if err != nil {
+		return err
+	}
+	if tip != nil && d.bestHeader.totalWork().Cmp(tip.totalWork()) <= 0 {
+		return nil
+	}
+
+	var queue []*blockIndex
+	for bi := d.bestHeader; ; {
+		queue = append([]*blockIndex{bi}, queue...)
+		prev, ok := d.headers[hex.EncodeToString(bi.PrevHash)]
+		if !ok {
+			break
+		}
+		bi = prev
+	}
+	d.queue = queue
+
+	//不在新队列中的已收到区块（例如另一条分支上的区块）不再链接
+	wanted := make(map[string]bool)
+	for _, bi := range queue {
+		wanted[hex.EncodeToString(bi.Hash)] = true
+	}
+	for key := range d.received {
+		if !wanted[key] {
+			delete(d.received, key)
+		}
+	}
+
+	fmt.Printf("Downloading %d blocks up to height %d\n", len(queue), d.bestHeader.Height)
+	return nil
+}
+
+//节点p是否能提供高度为height的区块
+func canServeBlock(p *peer, height int) bool {
+	if !p.handshakeComplete() {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.startHeight < height {
+		return false
+	}
+	if p.services&SFNodeNetwork != 0 {
+		return true
+	}
+
+	return p.services&SFNodeNetworkLimited != 0 && p.pruneHeight <= height
+}
+
+/*
+	为窗口内还没有请求的区块选择节点并发送getdata
+	选择正在下载的区块最少、且未超过maxBlocksInFlightPerPeer的节点，上次请求失败的节点只在没有其他节点时选择
+ */
+func (d *blockDownloader) schedule() {
+	window := d.queue
+	if len(window) > blockDownloadWindow {
+		window = window[:blockDownloadWindow]
+	}
+	if len(window) == 0 {
+		return
+	}
+
+	peers := peerManager.allPeers()
+	counts := make(map[*peer]int)
+	for _, req := range d.inFlight {
+		counts[req.peer]++
+	}
+
+	for _, bi := range window {
+		key := hex.EncodeToString(bi.Hash)
+		if d.inFlight[key] != nil || d.received[key] != nil {
+			continue
+		}
+
+		var best, fallback *peer
+		for _, p := range peers {
+			if counts[p] >= maxBlocksInFlightPerPeer || !canServeBlock(p, bi.Height) {
+				continue
+			}
+			if p == d.failed[key] {
+				fallback = p
+				continue
+			}
+			if best == nil || counts[p] < counts[best] {
+				best = p
+			}
+		}
+		if best == nil {
+			best = fallback
+		}
+		if best == nil {
+			continue
+		}
+
+		if sendGetData(best, "block", bi.Hash) != nil {
+			continue
+		}
+		d.inFlight[key] = &blockRequest{best, time.Now()}
+		counts[best]++
+	}
+}
+
+//是否正在下载该区块
+func (d *blockDownloader) requested(hash []byte) bool {
+	return d.inFlight[hex.EncodeToString(hash)] != nil
+}
+
+//对方无法提供请求的区块，改为向其他节点请求
+func (d *blockDownloader) notFound(p *peer, hash []byte) {
+	key := hex.EncodeToString(hash)
+	req := d.inFlight[key]
+	if req == nil || req.peer != p {
+		return
+	}
+
+	delete(d.inFlight, key)
+	d.failed[key] = p
+	d.schedule()
+}
+
+/*
+	检查正在下载的区块，对方已断开或超过blockDownloadTimeout没有收到的区块改为向其他节点请求
+ */
+func (d *blockDownloader) checkTimeouts() {
+	for key, req := range d.inFlight {
+		if !req.peer.disconnected() && time.Since(req.sent) < blockDownloadTimeout {
+			continue
+		}
+
+		fmt.Printf("Block %s from %s timed out\n", key, req.peer)
+		delete(d.inFlight, key)
+		d.failed[key] = req.peer
+	}
+}
+
+/*
+	收到正在下载的区块后暂存，再按高度顺序链接窗口开头已收到的区块
+	区块未通过验证时放弃本次下载，之后依赖该区块的区块头都会被拒绝
+ */
+func (d *blockDownloader) blockReceived(p *peer, block *Block, bc *Blockchain) error {
+	key := hex.EncodeToString(block.Hash)
+	delete(d.inFlight, key)
+	delete(d.failed, key)
+	d.received[key] = &receivedBlock{block, p}
+
+	for len(d.queue) > 0 {
+		next := hex.EncodeToString(d.queue[0].Hash)
+		rb := d.received[next]
+		if rb == nil {
+			break
+		}
+		delete(d.received, next)
+
+		err := acceptBlock(rb.from, rb.block, bc)
+		if err != nil {
+			d.reset()
+			return err
+		}
+		delete(d.headers, next)
+		d.queue = d.queue[1:]
+	}
+
+	if len(d.queue) > 0 {
+		d.schedule()
+		return nil
+	}
+
+	//下载完成，内存中剩余的区块头累计工作量都不超过已链接的链尾，不再需要
+	d.reset()
+	fmt.Println("Block download is complete")
+	err := relayInventory("block", [][]byte{bc.tip}, nil)
+	if err != nil {
+		return err
+	}
+
+	//区块头达到内存上限时对方可能还有后续的区块头
+	return sendGetHeaders(p, bc)
+}
+
+//放弃正在进行的下载
+func (d *blockDownloader) reset() {
+	*d = *newBlockDownloader()
+}
+
+//定时检查下载超时并重新分配区块
+func downloadBlocks() {
+	for range time.Tick(blockDownloadTick) {
+		handleLock.Lock()
+		blockSync.checkTimeouts()
+		blockSync.schedule()
+		handleLock.Unlock()
+	}
+}
@@ -0,0 +1,128 @@
+package BlockInfo
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderSync(t *testing.T) {
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	address := string(wallet.GetAddress())
+
+	bc, err := CreateBlockchainInStore(address, NewMemoryStore())
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+	genesis, err := bc.GetBlock(bc.tip)
+	assert.NoError(t, err)
+	for height := 1; height <= 2; height++ {
+		coinbase, err := NewCoinbaseTX(address, "", height, 0)
+		assert.NoError(t, err)
+		_, err = bc.MineBlock([]*Transaction{coinbase})
+		assert.NoError(t, err)
+	}
+
+	//只有创世块的节点
+	other, err := CreateBlockchainWithGenesisInStore(&genesis, NewMemoryStore())
+	assert.NoError(t, err)
+	defer other.Db.Close()
+
+	d := newBlockDownloader()
+	tip, err := bc.getBlockIndex(bc.tip)
+	assert.NoError(t, err)
+	locator, err := d.locator(bc, tip)
+	assert.NoError(t, err)
+	hashes, err := bc.GetBlockHashes(0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{hashes[2], hashes[1], hashes[0]}, locator)
+
+	fork, err := other.findLocatorFork(locator)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, fork)
+	fork, err = bc.findLocatorFork([][]byte{[]byte("unknown"), hashes[1]})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fork)
+
+	list, err := bc.GetHeaders(fork+1, 2)
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+	assert.Equal(t, hashes[2], list[0].Hash())
+
+	//在只有创世块的节点上验证高度1的区块头
+	headers, err := bc.GetHeaders(1, 2)
+	assert.NoError(t, err)
+	parent, err := other.getBlockIndex(hashes[0])
+	assert.NoError(t, err)
+	index, err := d.checkHeader(other, headers[0], parent)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, index.Height)
+	assert.True(t, index.totalWork().Cmp(parent.totalWork()) > 0)
+
+	_, err = d.checkHeader(other, headers[1], parent)
+	assert.ErrorIs(t, err, ErrBadHeight)
+
+	tampered := *headers[0]
+	tampered.Nonce++
+	_, err = d.checkHeader(other, &tampered, parent)
+	assert.ErrorIs(t, err, ErrInvalidPoW)
+}
+
+func TestHandleHeadersKeepsBestBranch(t *testing.T) {
+	defer blockSync.reset()
+
+	newChain := func(genesis *Block, blocks int) *Blockchain {
+		wallet, err := NewWallet()
+		assert.NoError(t, err)
+		address := string(wallet.GetAddress())
+		var bc *Blockchain
+		if genesis == nil {
+			bc, err = CreateBlockchainInStore(address, NewMemoryStore())
+		} else {
+			bc, err = CreateBlockchainWithGenesisInStore(genesis, NewMemoryStore())
+		}
+		assert.NoError(t, err)
+		for height := 1; height <= blocks; height++ {
+			_, err = bc.MineBlock([]*Transaction{newTestCoinbase(t, address, height, 0)})
+			assert.NoError(t, err)
+		}
+		return bc
+	}
+	send := func(p *peer, from *Blockchain, to *Blockchain, height int) {
+		list, err := from.GetHeaders(1, height)
+		assert.NoError(t, err)
+		var data headers
+		for _, header := range list {
+			data.Headers = append(data.Headers, header.Serialize())
+		}
+		payload := encodePayload(data)
+		assert.NoError(t, handleHeaders(p, payload, to))
+	}
+
+	best := newChain(nil, 2)
+	defer best.Db.Close()
+	genesis, err := best.GetBlockByHeight(0)
+	assert.NoError(t, err)
+	side := newChain(&genesis, 3)
+	defer side.Db.Close()
+	local := newChain(&genesis, 0)
+	defer local.Db.Close()
+	p, _ := newTestPeer(t)
+
+	//累计工作量更小的分支在同步完成后被丢弃
+	send(p, best, local, 2)
+	send(p, side, local, 1)
+	assert.Len(t, blockSync.headers, 2)
+	assert.Equal(t, best.tip, blockSync.bestHeader.Hash)
+	sideHash, err := side.GetBlockHashByHeight(1)
+	assert.NoError(t, err)
+	_, ok := blockSync.headers[hex.EncodeToString(sideHash)]
+	assert.False(t, ok)
+
+	//累计工作量不超过本节点主链的区块头都不保存
+	blockSync.reset()
+	send(p, best, side, 2)
+	assert.Empty(t, blockSync.headers)
+	assert.Nil(t, blockSync.bestHeader)
+}
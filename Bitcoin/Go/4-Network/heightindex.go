@@ -0,0 +1,151 @@
+/*
+	主链的高度索引
+	key为uint32(区块高度，大端序)，value为主链上该高度的区块哈希
+	区块链接到主链时写入，从主链断开时删除，链重组后始终与当前主链一致，
+	可以按高度直接访问区块，而不需要从链尾逐个遍历
+ */
+package BlockInfo
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const heightIndexBucket = "heightindex"
+
+//高度采用大端序，使游标按高度从低到高遍历
+func heightKey(height int) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, uint32(height))
+
+	return key
+}
+
+//将heightKey的结果还原为高度，数据为空时返回0
+func decodeHeight(data []byte) int {
+	if len(data) != 4 {
+		return 0
+	}
+
+	return int(binary.BigEndian.Uint32(data))
+}
+
+func putHeightIndex(tx StoreTx, height int, hash []byte) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(heightIndexBucket))
+	if err != nil {
+		return err
+	}
+
+	return b.Put(heightKey(height), hash)
+}
+
+func deleteHeightIndex(tx StoreTx, height int) error {
+	b := tx.Bucket([]byte(heightIndexBucket))
+	if b == nil {
+		return nil
+	}
+
+	return b.Delete(heightKey(height))
+}
+
+//获取主链上高度为height的区块哈希，不存在时返回nil
+func getHashByHeight(tx StoreTx, height int) []byte {
+	b := tx.Bucket([]byte(heightIndexBucket))
+	if b == nil || height < 0 {
+		return nil
+	}
+
+	hash := b.Get(heightKey(height))
+	if hash == nil {
+		return nil
+	}
+
+	return append([]byte{}, hash...)
+}
+
+//获取主链上高度为height的区块哈希
+func (bc *Blockchain) GetBlockHashByHeight(height int) ([]byte, error) {
+	var hash []byte
+
+	err := bc.Db.View(func(tx StoreTx) error {
+		hash = getHashByHeight(tx, height)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if hash == nil {
+		return nil, ErrBlockNotFound
+	}
+
+	return hash, nil
+}
+
+//获取主链上高度为height的区块
+func (bc *Blockchain) GetBlockByHeight(height int) (Block, error) {
+	hash, err := bc.GetBlockHashByHeight(height)
+	if err != nil {
+		return Block{}, err
+	}
+
+	return bc.GetBlock(hash)
+}
+
+/*
+	获取主链上高度从from到to（包含）的区块哈希，按高度从低到高排列
+	范围超出主链时只返回主链上存在的部分
+ */
+func (bc *Blockchain) GetBlockHashes(from, to int) ([][]byte, error) {
+	var blocks [][]byte
+
+	if from < 0 {
+		from = 0
+	}
+
+	err := bc.Db.View(func(tx StoreTx) error {
+		b := tx.Bucket([]byte(heightIndexBucket))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for k, v := c.Seek(heightKey(from)); k != nil; k, v = c.Next() {
+			if int(binary.BigEndian.Uint32(k)) > to {
+				break
+			}
+			blocks = append(blocks, append([]byte{}, v...))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+//为没有高度索引的旧数据库建立主链的高度索引，从链尾遍历区块头到创世块
+func (bc *Blockchain) ensureHeightIndex() error {
+	_, err := bc.GetBlockHashByHeight(0)
+	if err != ErrBlockNotFound {
+		return err
+	}
+
+	fmt.Println("Building height index...")
+	return bc.Db.Update(func(tx StoreTx) error {
+		hash := bc.tip
+		for len(hash) > 0 {
+			header, err := getBlockHeader(tx, hash)
+			if err != nil {
+				return err
+			}
+
+			err = putHeightIndex(tx, header.Height, hash)
+			if err != nil {
+				return err
+			}
+			hash = header.PrevBlockHash
+		}
+		return nil
+	})
+}
@@ -0,0 +1,60 @@
+package BlockInfo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeightIndex(t *testing.T) {
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	address := string(wallet.GetAddress())
+	store := NewMemoryStore()
+	bc, err := CreateBlockchainInStore(address, store)
+	assert.NoError(t, err)
+	hashes := [][]byte{bc.tip}
+	for height := 1; height <= 3; height++ {
+		block, err := bc.MineBlock([]*Transaction{newTestCoinbase(t, address, height, 0)})
+		assert.NoError(t, err)
+		hashes = append(hashes, block.Hash)
+	}
+
+	check := func(bc *Blockchain) {
+		for height, hash := range hashes {
+			indexed, err := bc.GetBlockHashByHeight(height)
+			assert.NoError(t, err)
+			assert.Equal(t, hash, indexed)
+		}
+		_, err := bc.GetBlockHashByHeight(4)
+		assert.Equal(t, ErrBlockNotFound, err)
+		_, err = bc.GetBlockHashByHeight(-1)
+		assert.Equal(t, ErrBlockNotFound, err)
+
+		block, err := bc.GetBlockByHeight(2)
+		assert.NoError(t, err)
+		assert.Equal(t, hashes[2], block.Hash)
+		assert.Equal(t, 2, block.Height)
+
+		blocks, err := bc.GetBlockHashes(1, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, hashes[1:3], blocks)
+		blocks, err = bc.GetBlockHashes(-5, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, hashes, blocks, "Range is limited to the main chain")
+		blocks, err = bc.GetBlockHashes(5, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, blocks)
+	}
+	check(bc)
+
+	//旧数据库没有高度索引，打开时从链尾遍历区块头建立
+	err = store.Update(func(tx StoreTx) error {
+		return tx.DeleteBucket([]byte(heightIndexBucket))
+	})
+	assert.NoError(t, err)
+	bc, err = OpenBlockchain(store)
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+	check(bc)
+}
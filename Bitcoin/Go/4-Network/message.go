@@ -0,0 +1,101 @@
+/*
+	网络消息
+	节点之间通过长连接收发消息，每条消息由24个字节的消息头和消息体组成：
+	networkMagic（4个字节）| 命令（12个字节，不足补0）| uint32(消息体长度，小端序) | 校验和（4个字节）| 消息体
+	校验和为消息体两次SHA-256的前4个字节，消息体长度不能超过maxMessageSize，
+	读取时先读取定长的消息头，检查长度之后才为消息体分配内存，对方无法通过声明超长的消息耗尽节点内存
+ */
+package BlockInfo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+//网络标识，不同网络的节点之间的消息会被拒绝，导出的区块文件也使用相同的标识
+var networkMagic = []byte{0xf9, 0xbe, 0xb4, 0xd9}
+
+const messageHeaderLen = 4 + commandLength + 4 + 4
+
+//消息体的最大长度，需要能容纳最大的区块
+const maxMessageSize = 4 * maxBlockSize
+
+var (
+	ErrBadMagic           = errors.New("message is not from this network")
+	ErrMessageTooLarge    = errors.New("message exceeds the maximum message size")
+	ErrBadMessageChecksum = errors.New("message checksum does not match its payload")
+	ErrBadCommand         = errors.New("message command is too long")
+)
+
+type message struct {
+	Command string
+	Payload []byte
+}
+
+func messageChecksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+
+	return second[:4]
+}
+
+//将消息写入w，消息头和消息体一次写入
+func writeMessage(w io.Writer, msg message) error {
+	if len(msg.Command) > commandLength {
+		return ErrBadCommand
+	}
+	if len(msg.Payload) > maxMessageSize {
+		return ErrMessageTooLarge
+	}
+
+	var buf bytes.Buffer
+	buf.Write(networkMagic)
+	buf.Write(commandToBytes(msg.Command))
+	writeUint32(&buf, uint32(len(msg.Payload)))
+	buf.Write(messageChecksum(msg.Payload))
+	buf.Write(msg.Payload)
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+/*
+	从r中读取一条消息
+	1、读取定长的消息头，检查网络标识和消息体长度
+	2、读取消息体，检查校验和
+	连接在消息边界关闭时返回io.EOF，消息不完整时返回io.ErrUnexpectedEOF
+ */
+func readMessage(r io.Reader) (message, error) {
+	header := make([]byte, messageHeaderLen)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return message{}, err
+	}
+
+	if !bytes.Equal(header[:4], networkMagic) {
+		return message{}, ErrBadMagic
+	}
+	command := bytesToCommand(header[4 : 4+commandLength])
+	size := binary.LittleEndian.Uint32(header[4+commandLength:])
+	checksum := header[4+commandLength+4:]
+	if size > maxMessageSize {
+		return message{}, ErrMessageTooLarge
+	}
+
+	payload := make([]byte, size)
+	_, err = io.ReadFull(r, payload)
+	if err == io.EOF {
+		return message{}, io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return message{}, err
+	}
+	if !bytes.Equal(messageChecksum(payload), checksum) {
+		return message{}, ErrBadMessageChecksum
+	}
+
+	return message{command, payload}, nil
+}
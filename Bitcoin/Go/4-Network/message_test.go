@@ -0,0 +1,50 @@
+package BlockInfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageEncoding(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, writeMessage(&buf, message{"getdata", []byte{1, 2, 3}}))
+	assert.NoError(t, writeMessage(&buf, message{"verack", nil}))
+	data := append([]byte{}, buf.Bytes()...)
+
+	r := bytes.NewReader(data)
+	msg, err := readMessage(r)
+	assert.NoError(t, err)
+	assert.Equal(t, message{"getdata", []byte{1, 2, 3}}, msg)
+	msg, err = readMessage(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "verack", msg.Command)
+	assert.Empty(t, msg.Payload)
+	_, err = readMessage(r)
+	assert.Equal(t, io.EOF, err)
+
+	_, err = readMessage(bytes.NewReader(data[:messageHeaderLen+1]))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	corrupted := append([]byte{}, data...)
+	corrupted[messageHeaderLen] ^= 1
+	_, err = readMessage(bytes.NewReader(corrupted))
+	assert.Equal(t, ErrBadMessageChecksum, err)
+
+	corrupted = append([]byte{}, data...)
+	corrupted[0] ^= 1
+	_, err = readMessage(bytes.NewReader(corrupted))
+	assert.Equal(t, ErrBadMagic, err)
+
+	//声明超长的消息在读取消息体之前被拒绝
+	corrupted = append([]byte{}, data[:messageHeaderLen]...)
+	binary.LittleEndian.PutUint32(corrupted[4+commandLength:], maxMessageSize+1)
+	_, err = readMessage(bytes.NewReader(corrupted))
+	assert.Equal(t, ErrMessageTooLarge, err)
+
+	assert.Equal(t, ErrBadCommand, writeMessage(&buf, message{"averylongcommand", nil}))
+	assert.Equal(t, ErrMessageTooLarge, writeMessage(&buf, message{"block", make([]byte, maxMessageSize+1)}))
+}
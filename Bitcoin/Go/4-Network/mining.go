@@ -0,0 +1,97 @@
+/*
+	矿工打包区块
+	从交易池中按手续费率（手续费/交易字节数）从高到低选择交易，区块序列化后的大小不超过maxBlockSize
+ */
+package BlockInfo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+//区块序列化后允许的最大字节数
+const maxBlockSize = 1000000
+//为区块头、交易数量和coinbase交易预留的字节数
+const blockReservedSize = 1000
+
+//交易池中的交易及其手续费和序列化后的大小
+type mempoolEntry struct {
+	tx   *Transaction
+	fee  int
+	size int
+}
+
+//手续费率是否高于另一笔交易，即 a.fee/a.size > b.fee/b.size
+func (a mempoolEntry) higherFeeRate(b mempoolEntry) bool {
+	return a.fee*b.size > b.fee*a.size
+}
+
+/*
+	从交易池中选择打包进新区块的交易，返回选中的交易及其手续费总额
+	1、计算每笔交易的手续费和大小，签名验证不通过或输入已不在UTXO集中的交易从交易池中移除
+	2、按手续费率从高到低排序，手续费率相同时按交易ID排序，保证结果确定
+	3、依次加入区块，跳过与已选交易花费同一输出的交易，以及加入后超过区块大小限制的交易
+ */
+func selectTransactions(bc *Blockchain, pool map[string]Transaction) ([]*Transaction, int) {
+	utxoSet := UTXOSet{bc}
+	var entries []mempoolEntry
+
+	for id := range pool {
+		tx := pool[id]
+
+		fee, err := utxoSet.TransactionFee(&tx)
+		if err == nil {
+			err = bc.VerifyTransaction(&tx)
+		}
+		if err != nil {
+			fmt.Printf("Transaction %s is removed from mempool\n", id)
+			delete(pool, id)
+			continue
+		}
+
+		entries = append(entries, mempoolEntry{&tx, fee, len(tx.Serialize())})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].higherFeeRate(entries[j]) {
+			return true
+		}
+		if entries[j].higherFeeRate(entries[i]) {
+			return false
+		}
+		return bytes.Compare(entries[i].tx.ID, entries[j].tx.ID) < 0
+	})
+
+	var txs []*Transaction
+	fees := 0
+	size := blockReservedSize
+	spent := make(map[string]bool)
+
+	for _, entry := range entries {
+		if size+entry.size > maxBlockSize {
+			continue
+		}
+
+		conflict := false
+		for _, vin := range entry.tx.Vin {
+			if spent[fmt.Sprintf("%s:%d", hex.EncodeToString(vin.Txid), vin.VoutIndex)] {
+				conflict = true
+				break
+			}
+		}
+		if conflict {
+			continue
+		}
+
+		for _, vin := range entry.tx.Vin {
+			spent[fmt.Sprintf("%s:%d", hex.EncodeToString(vin.Txid), vin.VoutIndex)] = true
+		}
+		txs = append(txs, entry.tx)
+		fees += entry.fee
+		size += entry.size
+	}
+
+	return txs, fees
+}
@@ -0,0 +1,76 @@
+/*
+	共识参数
+	区块奖励从InitialSubsidy开始，每HalvingInterval个区块减半，所有区块奖励的总和不超过MaxSupply
+	coinbase交易的输出在CoinbaseMaturity个区块之后才能花费
+	节点只从TrustedSnapshots中列出的UTXO快照启动，每条链的创世区块由createblockchain生成（奖励地址各不相同），
+	因此这里没有预置快照，可以将自己网络中的快照加入TrustedSnapshots后重新编译，
+	也可以在loadutxo时通过-height、-hash、-checksum指定从可信的节点运营者处得到的快照
+ */
+package BlockInfo
+
+type ConsensusParams struct {
+	InitialSubsidy   int //创世纪块的区块奖励
+	HalvingInterval  int //区块奖励减半的间隔（区块数）
+	MaxSupply        int //币的最大发行总量
+	CoinbaseMaturity int //coinbase交易的输出需要经过多少个区块才能花费
+	TrustedSnapshots []SnapshotCheckpoint //可信的UTXO快照，由dumputxo输出的区块高度、区块哈希和校验和得到
+}
+
+//当前区块链使用的共识参数，奖励按整数减半（10、5、2、1），最大发行总量为 210000 * (10+5+2+1)
+var params = ConsensusParams{
+	InitialSubsidy:   10,
+	HalvingInterval:  210000,
+	MaxSupply:        3780000,
+	CoinbaseMaturity: 100,
+}
+
+//将快照加入可信快照，用于加载不在TrustedSnapshots中、但通过其他可信途径得知区块哈希和校验和的快照
+func AddTrustedSnapshot(c SnapshotCheckpoint) {
+	params.TrustedSnapshots = append(params.TrustedSnapshots, c)
+}
+
+//按减半规则计算高度为height的区块奖励，不考虑最大发行总量
+func (p ConsensusParams) scheduledSubsidy(height int) int {
+	halvings := height / p.HalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+
+	return p.InitialSubsidy >> uint(halvings)
+}
+
+/*
+	计算高度0到height（包含）所有区块奖励的总和
+	每个减半周期内的区块奖励相同，按周期累加，不需要逐个区块计算
+ */
+func (p ConsensusParams) SupplyAt(height int) int {
+	supply := 0
+
+	for start := 0; start <= height; start += p.HalvingInterval {
+		subsidy := p.scheduledSubsidy(start)
+		if subsidy == 0 {
+			break
+		}
+
+		blocks := p.HalvingInterval
+		if start+blocks > height+1 {
+			blocks = height + 1 - start
+		}
+		supply += subsidy * blocks
+	}
+
+	if supply > p.MaxSupply {
+		supply = p.MaxSupply
+	}
+
+	return supply
+}
+
+//计算高度为height的区块奖励，达到最大发行总量后只发放剩余的部分
+func (p ConsensusParams) BlockSubsidy(height int) int {
+	if height < 0 {
+		return 0
+	}
+
+	return p.SupplyAt(height) - p.SupplyAt(height-1)
+}
@@ -0,0 +1,23 @@
+package BlockInfo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockSubsidy(t *testing.T) {
+	p := ConsensusParams{InitialSubsidy: 10, HalvingInterval: 3, MaxSupply: 40}
+
+	var subsidies []int
+	for height := 0; height < 15; height++ {
+		subsidies = append(subsidies, p.BlockSubsidy(height))
+	}
+
+	assert.Equal(t, []int{10, 10, 10, 5, 5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, subsidies, "Subsidy halves and stops at the max supply")
+	assert.Equal(t, 40, p.SupplyAt(100), "Supply never exceeds the max supply")
+
+	assert.Equal(t, 10, params.BlockSubsidy(params.HalvingInterval-1), "Subsidy before the first halving")
+	assert.Equal(t, 5, params.BlockSubsidy(params.HalvingInterval), "Subsidy after the first halving")
+	assert.Equal(t, params.MaxSupply, params.SupplyAt(64*params.HalvingInterval), "Schedule issues the max supply")
+}
@@ -0,0 +1,279 @@
+/*
+	网络消息体的规范编码，varint、varbytes的格式见encoding.go，字符串按varbytes编码，定长整数都采用小端序
+	不依赖Go的gob编码，其他语言按以下格式实现即可与节点通信
+
+	version：  uint32(Version) | uint64(Services) | int64(Timestamp) | uint64(Nonce) | varbytes(UserAgent) |
+	           uint32(StartHeight) | uint32(PruneHeight) | varbytes(AddrFrom)
+	addr：     varint(地址数) | varbytes(地址)...
+	inv：      varbytes(Type) | varint(条目数) | varbytes(哈希)...
+	getdata、notfound：varbytes(Type) | varbytes(ID)
+	block：    序列化的区块（见encoding.go），占满整个消息体
+	tx：       序列化的交易，占满整个消息体
+	getheaders：varint(定位器哈希数) | varbytes(哈希)...
+	headers：  varint(区块头数) | varbytes(序列化的区块头)...
+
+	消息体末尾多余的字节视为格式错误
+ */
+package BlockInfo
+
+import (
+	"bytes"
+	"io"
+)
+
+//可以编码为消息体的消息
+type payloadEncoder interface {
+	encode(buf *bytes.Buffer)
+}
+
+//可以从消息体解码的消息
+type payloadDecoder interface {
+	decode(r *bytes.Reader) error
+}
+
+func encodePayload(payload payloadEncoder) []byte {
+	var buf bytes.Buffer
+	payload.encode(&buf)
+	return buf.Bytes()
+}
+
+//将消息体解码到payload，数据无法解码时返回malformedPayloadError
+func decodePayload(request []byte, payload payloadDecoder) error {
+	err := decodeAll(request, payload.decode)
+	if err != nil {
+		return &malformedPayloadError{err}
+	}
+
+	return nil
+}
+
+func writeVarString(buf *bytes.Buffer, s string) {
+	writeVarBytes(buf, []byte(s))
+}
+
+func readVarString(r *bytes.Reader) (string, error) {
+	data, err := readVarBytes(r)
+	return string(data), err
+}
+
+func writeByteList(buf *bytes.Buffer, list [][]byte) {
+	writeVarInt(buf, uint64(len(list)))
+	for _, item := range list {
+		writeVarBytes(buf, item)
+	}
+}
+
+func readByteList(r *bytes.Reader) ([][]byte, error) {
+	n, err := readCount(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var list [][]byte
+	for i := 0; i < n; i++ {
+		item, err := readVarBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, item)
+	}
+
+	return list, nil
+}
+
+//读取剩余的全部字节
+func readRest(r *bytes.Reader) ([]byte, error) {
+	data := make([]byte, r.Len())
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, ErrMalformedData
+	}
+	return data, nil
+}
+
+func (v verzion) encode(buf *bytes.Buffer) {
+	writeUint32(buf, uint32(v.Version))
+	writeUint64(buf, uint64(v.Services))
+	writeUint64(buf, uint64(v.Timestamp))
+	writeUint64(buf, v.Nonce)
+	writeVarString(buf, v.UserAgent)
+	writeUint32(buf, uint32(v.StartHeight))
+	writeUint32(buf, uint32(v.PruneHeight))
+	writeVarString(buf, v.AddrFrom)
+}
+
+func (v *verzion) decode(r *bytes.Reader) error {
+	version, err := readUint32(r)
+	if err != nil {
+		return err
+	}
+	services, err := readUint64(r)
+	if err != nil {
+		return err
+	}
+	timestamp, err := readUint64(r)
+	if err != nil {
+		return err
+	}
+	nonce, err := readUint64(r)
+	if err != nil {
+		return err
+	}
+	userAgent, err := readVarString(r)
+	if err != nil {
+		return err
+	}
+	startHeight, err := readUint32(r)
+	if err != nil {
+		return err
+	}
+	pruneHeight, err := readUint32(r)
+	if err != nil {
+		return err
+	}
+	addrFrom, err := readVarString(r)
+	if err != nil {
+		return err
+	}
+
+	*v = verzion{int(version), ServiceFlag(services), int64(timestamp), nonce, userAgent, int(startHeight), int(pruneHeight), addrFrom}
+	return nil
+}
+
+func (a addr) encode(buf *bytes.Buffer) {
+	writeVarInt(buf, uint64(len(a.AddrList)))
+	for _, address := range a.AddrList {
+		writeVarString(buf, address)
+	}
+}
+
+func (a *addr) decode(r *bytes.Reader) error {
+	n, err := readCount(r)
+	if err != nil {
+		return err
+	}
+
+	var list []string
+	for i := 0; i < n; i++ {
+		address, err := readVarString(r)
+		if err != nil {
+			return err
+		}
+		list = append(list, address)
+	}
+
+	a.AddrList = list
+	return nil
+}
+
+func (i inv) encode(buf *bytes.Buffer) {
+	writeVarString(buf, i.Type)
+	writeByteList(buf, i.Items)
+}
+
+func (i *inv) decode(r *bytes.Reader) error {
+	kind, err := readVarString(r)
+	if err != nil {
+		return err
+	}
+	items, err := readByteList(r)
+	if err != nil {
+		return err
+	}
+
+	*i = inv{kind, items}
+	return nil
+}
+
+func (g getdata) encode(buf *bytes.Buffer) {
+	writeVarString(buf, g.Type)
+	writeVarBytes(buf, g.ID)
+}
+
+func (g *getdata) decode(r *bytes.Reader) error {
+	kind, err := readVarString(r)
+	if err != nil {
+		return err
+	}
+	id, err := readVarBytes(r)
+	if err != nil {
+		return err
+	}
+
+	*g = getdata{kind, id}
+	return nil
+}
+
+func (n notfound) encode(buf *bytes.Buffer) {
+	writeVarString(buf, n.Type)
+	writeVarBytes(buf, n.ID)
+}
+
+func (n *notfound) decode(r *bytes.Reader) error {
+	kind, err := readVarString(r)
+	if err != nil {
+		return err
+	}
+	id, err := readVarBytes(r)
+	if err != nil {
+		return err
+	}
+
+	*n = notfound{kind, id}
+	return nil
+}
+
+func (b block) encode(buf *bytes.Buffer) {
+	buf.Write(b.Block)
+}
+
+func (b *block) decode(r *bytes.Reader) error {
+	data, err := readRest(r)
+	if err != nil {
+		return err
+	}
+
+	b.Block = data
+	return nil
+}
+
+func (t tx) encode(buf *bytes.Buffer) {
+	buf.Write(t.Transaction)
+}
+
+func (t *tx) decode(r *bytes.Reader) error {
+	data, err := readRest(r)
+	if err != nil {
+		return err
+	}
+
+	t.Transaction = data
+	return nil
+}
+
+func (g getheaders) encode(buf *bytes.Buffer) {
+	writeByteList(buf, g.Locator)
+}
+
+func (g *getheaders) decode(r *bytes.Reader) error {
+	locator, err := readByteList(r)
+	if err != nil {
+		return err
+	}
+
+	g.Locator = locator
+	return nil
+}
+
+func (h headers) encode(buf *bytes.Buffer) {
+	writeByteList(buf, h.Headers)
+}
+
+func (h *headers) decode(r *bytes.Reader) error {
+	list, err := readByteList(r)
+	if err != nil {
+		return err
+	}
+
+	h.Headers = list
+	return nil
+}
@@ -0,0 +1,38 @@
+package BlockInfo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPayloadEncoding(t *testing.T) {
+	v := verzion{nodeVersion, SFNodeNetwork, 1600000000, 42, "/test/", 7, 3, "localhost:3000"}
+	var decodedVersion verzion
+	assert.NoError(t, decodePayload(encodePayload(v), &decodedVersion))
+	assert.Equal(t, v, decodedVersion)
+
+	list := inv{"block", [][]byte{{1, 2}, {3}}}
+	var decodedInv inv
+	assert.NoError(t, decodePayload(encodePayload(list), &decodedInv))
+	assert.Equal(t, list, decodedInv)
+
+	addrs := addr{[]string{"a:1", "b:2"}}
+	var decodedAddr addr
+	assert.NoError(t, decodePayload(encodePayload(addrs), &decodedAddr))
+	assert.Equal(t, addrs, decodedAddr)
+
+	request := getdata{"tx", []byte{9}}
+	var decodedGetData getdata
+	assert.NoError(t, decodePayload(encodePayload(request), &decodedGetData))
+	assert.Equal(t, request, decodedGetData)
+
+	//区块和交易占满整个消息体，不再额外编码
+	data := block{[]byte{1, 2, 3}}
+	assert.Equal(t, data.Block, encodePayload(data))
+
+	err := decodePayload(append(encodePayload(request), 0), &decodedGetData)
+	assert.ErrorIs(t, err, ErrMalformedData, "Trailing bytes are rejected")
+	err = decodePayload([]byte{0x05, 0x01}, &decodedInv)
+	assert.ErrorIs(t, err, ErrMalformedData, "Truncated payload is rejected")
+}
@@ -0,0 +1,292 @@
+/*
+	节点连接
+	与每个对方节点只保持一个长连接，双方都通过这个连接收发消息，
+	每个连接有一个读循环和一个写循环：读循环逐条读取消息并交给handleMessage处理，
+	写循环从发送队列中取出消息写入连接，发送队列已满或写入超时的节点（处理过慢）会被断开，
+	连接空闲超过peerIdleTimeout也会被断开，对消息的回复总是通过收到消息的连接发送
+	连接建立后双方先交换version和verack消息（握手），握手完成之前不处理对方的其他消息，
+	发往对方的其他消息也先保存在pending中，握手完成后再发送
+ */
+package BlockInfo
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const dialTimeout = 10 * time.Second
+const peerWriteTimeout = 30 * time.Second
+const peerIdleTimeout = 30 * time.Minute
+const handshakeTimeout = time.Minute
+
+//每个节点发送队列中最多等待的消息数
+const peerSendQueueLen = 64
+
+var errPeerDisconnected = errors.New("peer is disconnected")
+var errPeerSendQueueFull = errors.New("peer send queue is full")
+var errHandshakeTimeout = errors.New("peer did not complete the handshake in time")
+var errHandshakeIncomplete = errors.New("peer sent a message before completing the handshake")
+
+/*
+	对方节点
+	addr：对方的监听地址，对方主动建立的连接在收到version消息之前为连接的远端地址
+	host：连接的远端IP，用于封禁，不受对方声明的监听地址影响
+	inbound：是否为对方主动建立的连接
+	versionSent、versionReceived、verackReceived：握手的进度，三者都为true时握手完成
+	version：双方协商的协议版本，即双方版本中较低的一个
+	services、userAgent、startHeight、pruneHeight：对方在version消息中声明的信息
+	banScore：对方的不良行为分数，达到banThreshold时被封禁
+	addr在登记后由PeerManager修改，读取时需要持有mu
+ */
+type peer struct {
+	addr    string
+	host    string
+	inbound bool
+	conn    net.Conn
+	send    chan message
+	quit    chan struct{}
+	once    sync.Once
+
+	mu              sync.Mutex
+	versionSent     bool
+	versionReceived bool
+	verackReceived  bool
+	pending         []message
+	version         int
+	services        ServiceFlag
+	userAgent       string
+	startHeight     int
+	pruneHeight     int
+	banScore        int
+	connectedAt     time.Time
+}
+
+func newPeer(conn net.Conn, addr string, inbound bool) *peer {
+	return &peer{
+		addr:    addr,
+		host:    addrHost(conn.RemoteAddr().String()),
+		inbound: inbound,
+		conn:    conn,
+		send:    make(chan message, peerSendQueueLen),
+		quit:    make(chan struct{}),
+
+		connectedAt: time.Now(),
+	}
+}
+
+/*
+	在peerManager中登记节点并启动读写循环
+	同一地址已有连接时返回已有的节点，地址被封禁或连接数已达上限时返回错误，这两种情况下新的连接都被关闭
+ */
+func startPeer(p *peer, bc *Blockchain) (*peer, error) {
+	existing, err := peerManager.add(p)
+	if err != nil {
+		p.conn.Close()
+		return nil, err
+	}
+	if existing != p {
+		p.conn.Close()
+		return existing, nil
+	}
+
+	go p.writeLoop()
+	go p.readLoop(bc)
+
+	//主动建立连接的一方先发送version消息
+	if !p.inbound {
+		err := p.sendVersion(bc)
+		if err != nil {
+			p.disconnect(err)
+		}
+	}
+	time.AfterFunc(handshakeTimeout, func() {
+		if !p.handshakeComplete() {
+			p.disconnect(errHandshakeTimeout)
+		}
+	})
+
+	return p, nil
+}
+
+//连接地址为addr的节点，已连接时直接返回
+func connectPeer(addr string, bc *Blockchain) (*peer, error) {
+	if p := peerManager.find(addr); p != nil {
+		return p, nil
+	}
+
+	conn, err := net.DialTimeout(protocol, addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return startPeer(newPeer(conn, addr, false), bc)
+}
+
+//地址addr中的主机部分（IP或主机名），没有端口时返回addr本身
+func addrHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+
+	return host
+}
+
+//连接是否已断开
+func (p *peer) disconnected() bool {
+	select {
+	case <-p.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *peer) String() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.addr
+}
+
+func (p *peer) info() PeerInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return PeerInfo{
+		Addr:        p.addr,
+		Inbound:     p.inbound,
+		Version:     p.version,
+		UserAgent:   p.userAgent,
+		Services:    p.services,
+		StartHeight: p.startHeight,
+		BanScore:    p.banScore,
+		ConnectedAt: p.connectedAt,
+	}
+}
+
+/*
+	对方发送了无效的数据，增加其不良行为分数
+	分数达到banThreshold时断开连接，并封禁连接的远端IP（回环地址除外）
+ */
+func (p *peer) misbehaving(score int, reason error) {
+	p.mu.Lock()
+	p.banScore += score
+	banScore := p.banScore
+	addr := p.addr
+	p.mu.Unlock()
+
+	fmt.Printf("Misbehaving %s (score %d): %v\n", addr, banScore, reason)
+	if banScore < banThreshold {
+		return
+	}
+
+	p.disconnect(fmt.Errorf("%v: %v", errPeerBanned, reason))
+	peerManager.Ban(p.host, banDuration)
+}
+
+//握手是否已经完成
+func (p *peer) handshakeComplete() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.versionSent && p.versionReceived && p.verackReceived
+}
+
+func isHandshakeMessage(command string) bool {
+	return command == "version" || command == "verack"
+}
+
+/*
+	将消息放入发送队列，队列已满时断开节点，不会阻塞调用者
+	握手完成之前，除version和verack之外的消息先保存在pending中
+ */
+func (p *peer) queueMessage(msg message) error {
+	p.mu.Lock()
+	if !(p.versionSent && p.versionReceived && p.verackReceived) && !isHandshakeMessage(msg.Command) {
+		if len(p.pending) >= peerSendQueueLen {
+			p.mu.Unlock()
+			p.disconnect(errPeerSendQueueFull)
+			return errPeerSendQueueFull
+		}
+		p.pending = append(p.pending, msg)
+		p.mu.Unlock()
+		return nil
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.quit:
+		return errPeerDisconnected
+	default:
+	}
+
+	select {
+	case p.send <- msg:
+		return nil
+	default:
+		p.disconnect(errPeerSendQueueFull)
+		return errPeerSendQueueFull
+	}
+}
+
+//断开连接并从peerManager中删除，可以重复调用
+func (p *peer) disconnect(reason error) {
+	p.once.Do(func() {
+		peerManager.remove(p)
+
+		close(p.quit)
+		p.conn.Close()
+		fmt.Printf("Disconnected %s: %v\n", p, reason)
+	})
+}
+
+func (p *peer) writeLoop() {
+	for {
+		select {
+		case <-p.quit:
+			return
+		case msg := <-p.send:
+			p.conn.SetWriteDeadline(time.Now().Add(peerWriteTimeout))
+			err := writeMessage(p.conn, msg)
+			if err != nil {
+				p.disconnect(err)
+				return
+			}
+		}
+	}
+}
+
+/*
+	逐条读取对方发来的消息并处理
+	消息格式错误（网络标识、长度或校验和不正确）或握手完成之前收到其他消息时断开连接，
+	消息处理失败时只记录日志并丢弃该消息
+ */
+func (p *peer) readLoop(bc *Blockchain) {
+	r := bufio.NewReader(p.conn)
+
+	for {
+		p.conn.SetReadDeadline(time.Now().Add(peerIdleTimeout))
+		msg, err := readMessage(r)
+		if err != nil {
+			p.disconnect(err)
+			return
+		}
+
+		fmt.Printf("Received %s command from %s\n", msg.Command, p)
+		if !isHandshakeMessage(msg.Command) && !p.handshakeComplete() {
+			p.disconnect(errHandshakeIncomplete)
+			return
+		}
+
+		err = handleMessage(p, msg, bc)
+		if err != nil {
+			log.Printf("Dropped %s command from %s: %v\n", msg.Command, p, err)
+		}
+	}
+}
@@ -0,0 +1,465 @@
+/*
+	节点管理
+	PeerManager记录已连接的节点和地址簿：
+	1、限制主动建立的连接（outbound）和对方建立的连接（inbound）的数量
+	2、地址簿记录已知节点的地址、最近一次收到该地址和最近一次成功握手的时间，以及连续连接失败的次数，
+	   最多保存maxKnownAddresses个地址，超出时先淘汰连接失败次数多、没有成功握手过、最近没有收到的地址；
+	   地址簿每隔peersSaveInterval以及节点退出时保存在peers_<节点ID>.dat文件中，节点重启后从地址簿中选择节点连接
+	3、对方发送无效的区块、交易或无法解码的消息时增加其不良行为分数，分数达到banThreshold时断开连接，
+	   并在banDuration内拒绝来自该IP的连接
+	封禁按连接的远端IP记录，而不是对方在version消息中声明的监听地址：声明的地址没有经过验证，
+	按声明的地址封禁会使攻击者冒用其他节点的地址使其被封禁，按IP+端口封禁则可以通过更换源端口绕过
+	回环地址（同一台机器上的节点，例如本地演示网络）不封禁，只断开发送无效数据的连接：
+	本机的所有节点共用回环IP，封禁它会使一个节点的不良行为导致本机的其他节点都被拒绝
+ */
+package BlockInfo
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const peersFile = "peers_%s.dat"
+
+//地址簿最多保存的地址数量，以及保存地址簿的时间间隔
+const maxKnownAddresses = 2000
+const peersSaveInterval = 2 * time.Minute
+
+const maxOutboundPeers = 8
+const maxInboundPeers = 117
+
+//不良行为分数达到banThreshold时封禁
+const banThreshold = 100
+const banDuration = 24 * time.Hour
+
+//不良行为的分数
+const (
+	banScoreInvalidBlock  = 100
+	banScoreInvalidTx     = 10
+	banScoreMalformedData = 10
+)
+
+var (
+	errTooManyPeers = errors.New("too many peer connections")
+	errPeerBanned   = errors.New("peer is banned")
+	errPeerNotFound = errors.New("peer is not connected")
+)
+
+/*
+	地址簿中的一个地址
+	LastSeen：最近一次从该地址收到消息或从其他节点得知该地址的时间
+	LastSuccess：最近一次与该地址成功握手的时间
+	Failures：最近一次成功握手之后连接该地址失败的次数
+ */
+type KnownAddress struct {
+	Addr        string
+	LastSeen    time.Time
+	LastSuccess time.Time
+	Failures    int
+}
+
+//地址簿文件的内容，Bans为被封禁的IP及封禁结束的时间
+type peersFileContent struct {
+	Addrs []*KnownAddress
+	Bans  map[string]time.Time
+}
+
+//已连接节点的信息，用于getpeerinfo
+type PeerInfo struct {
+	Addr        string
+	Inbound     bool
+	Version     int
+	UserAgent   string
+	Services    ServiceFlag
+	StartHeight int
+	BanScore    int
+	ConnectedAt time.Time
+}
+
+type PeerManager struct {
+	mu    sync.Mutex
+	peers map[string]*peer
+	addrs map[string]*KnownAddress
+	bans  map[string]time.Time
+	file  string
+	dirty bool //地址簿在上次保存之后有改动
+}
+
+//当前节点的节点管理，由StartServer根据节点ID加载地址簿
+var peerManager = NewPeerManager()
+
+//创建不保存地址簿的节点管理
+func NewPeerManager() *PeerManager {
+	return &PeerManager{peers: make(map[string]*peer), addrs: make(map[string]*KnownAddress), bans: make(map[string]time.Time)}
+}
+
+//创建节点管理并加载节点nodeID的地址簿，地址簿文件不存在时从空的地址簿开始
+func LoadPeerManager(nodeID string) (*PeerManager, error) {
+	return loadPeerManager(fmt.Sprintf(peersFile, nodeID))
+}
+
+func loadPeerManager(file string) (*PeerManager, error) {
+	pm := NewPeerManager()
+	pm.file = file
+
+	data, err := ioutil.ReadFile(pm.file)
+	if os.IsNotExist(err) {
+		return pm, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var content peersFileContent
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&content)
+	if err != nil {
+		return nil, err
+	}
+	for _, ka := range content.Addrs {
+		pm.addrs[ka.Addr] = ka
+	}
+	for host, until := range content.Bans {
+		pm.bans[host] = until
+	}
+
+	return pm, nil
+}
+
+//保存地址簿，没有地址簿文件或上次保存之后没有改动时不做任何操作
+func (pm *PeerManager) Save() error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if pm.file == "" || !pm.dirty {
+		return nil
+	}
+
+	content := peersFileContent{Addrs: make([]*KnownAddress, 0, len(pm.addrs)), Bans: pm.bans}
+	for _, ka := range pm.addrs {
+		content.Addrs = append(content.Addrs, ka)
+	}
+
+	var buff bytes.Buffer
+	err := gob.NewEncoder(&buff).Encode(content)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(pm.file, buff.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	pm.dirty = false
+
+	return nil
+}
+
+//每隔peersSaveInterval保存一次地址簿，直到quit被关闭
+func (pm *PeerManager) saveLoop(quit <-chan struct{}) {
+	ticker := time.NewTicker(peersSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := pm.Save()
+			if err != nil {
+				log.Printf("Failed to save address book: %v\n", err)
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+func (pm *PeerManager) knownAddressLocked(addr string) *KnownAddress {
+	ka, ok := pm.addrs[addr]
+	if !ok {
+		ka = &KnownAddress{Addr: addr}
+		pm.addrs[addr] = ka
+	}
+	pm.dirty = true
+
+	return ka
+}
+
+/*
+	地址簿超过maxKnownAddresses个地址时淘汰多出的地址
+	按连接失败次数从多到少、没有成功握手过的在前、最近成功握手和最近收到的时间从早到晚的顺序淘汰
+ */
+func (pm *PeerManager) evictLocked() {
+	if len(pm.addrs) <= maxKnownAddresses {
+		return
+	}
+
+	known := make([]*KnownAddress, 0, len(pm.addrs))
+	for _, ka := range pm.addrs {
+		known = append(known, ka)
+	}
+	sort.Slice(known, func(i, j int) bool {
+		a, b := known[i], known[j]
+		if a.Failures != b.Failures {
+			return a.Failures > b.Failures
+		}
+		if !a.LastSuccess.Equal(b.LastSuccess) {
+			return a.LastSuccess.Before(b.LastSuccess)
+		}
+		if !a.LastSeen.Equal(b.LastSeen) {
+			return a.LastSeen.Before(b.LastSeen)
+		}
+		return a.Addr < b.Addr
+	})
+	for _, ka := range known[:len(known)-maxKnownAddresses] {
+		delete(pm.addrs, ka.Addr)
+	}
+}
+
+//将从其他节点得知的地址加入地址簿，返回之前不在地址簿中且没有被淘汰的地址
+func (pm *PeerManager) AddAddresses(addrs ...string) []string {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	var fresh []string
+	now := time.Now()
+	for _, addr := range addrs {
+		if addr == "" || addr == nodeListenAddress {
+			continue
+		}
+		if _, ok := pm.addrs[addr]; !ok {
+			fresh = append(fresh, addr)
+		}
+		pm.knownAddressLocked(addr).LastSeen = now
+	}
+	pm.evictLocked()
+
+	var kept []string
+	for _, addr := range fresh {
+		if _, ok := pm.addrs[addr]; ok {
+			kept = append(kept, addr)
+		}
+	}
+
+	return kept
+}
+
+//记录与addr成功握手
+func (pm *PeerManager) markSuccess(addr string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	ka := pm.knownAddressLocked(addr)
+	ka.LastSeen = time.Now()
+	ka.LastSuccess = ka.LastSeen
+	ka.Failures = 0
+	pm.evictLocked()
+}
+
+//记录连接地址簿中的addr失败，不在地址簿中的地址不做记录
+func (pm *PeerManager) markFailed(addr string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	ka, ok := pm.addrs[addr]
+	if !ok {
+		return
+	}
+	ka.Failures++
+	pm.dirty = true
+}
+
+/*
+	地址簿中的地址，最近成功握手的在前
+	不包括本节点和IP被封禁的地址
+ */
+func (pm *PeerManager) KnownAddresses() []string {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	var known []*KnownAddress
+	now := time.Now()
+	for _, ka := range pm.addrs {
+		if ka.Addr != nodeListenAddress && !pm.isBannedLocked(addrHost(ka.Addr), now) {
+			known = append(known, ka)
+		}
+	}
+	sort.Slice(known, func(i, j int) bool {
+		if !known[i].LastSuccess.Equal(known[j].LastSuccess) {
+			return known[i].LastSuccess.After(known[j].LastSuccess)
+		}
+		return known[i].Addr < known[j].Addr
+	})
+
+	addrs := make([]string, len(known))
+	for i, ka := range known {
+		addrs[i] = ka.Addr
+	}
+
+	return addrs
+}
+
+//IP在now时是否被封禁
+func (pm *PeerManager) isBannedLocked(host string, now time.Time) bool {
+	until, ok := pm.bans[host]
+
+	return ok && now.Before(until)
+}
+
+//封禁IP host并断开来自该IP的所有连接，回环地址不封禁
+func (pm *PeerManager) Ban(host string, duration time.Duration) {
+	if isLoopback(host) {
+		return
+	}
+
+	pm.mu.Lock()
+	pm.bans[host] = time.Now().Add(duration)
+	pm.dirty = true
+	var banned []*peer
+	for _, p := range pm.peers {
+		if p.host == host {
+			banned = append(banned, p)
+		}
+	}
+	pm.mu.Unlock()
+
+	for _, p := range banned {
+		p.disconnect(errPeerBanned)
+	}
+}
+
+//host是否为本机的回环地址
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+func (pm *PeerManager) find(addr string) *peer {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	return pm.peers[addr]
+}
+
+func (pm *PeerManager) countLocked(inbound bool) int {
+	count := 0
+	for _, p := range pm.peers {
+		if p.inbound == inbound {
+			count++
+		}
+	}
+
+	return count
+}
+
+/*
+	登记新的连接
+	同一地址已有连接时返回已有的节点，连接的远端IP被封禁或连接数已达上限时返回错误
+ */
+func (pm *PeerManager) add(p *peer) (*peer, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if existing, ok := pm.peers[p.addr]; ok {
+		return existing, nil
+	}
+	if pm.isBannedLocked(p.host, time.Now()) {
+		return nil, errPeerBanned
+	}
+
+	limit := maxOutboundPeers
+	if p.inbound {
+		limit = maxInboundPeers
+	}
+	if pm.countLocked(p.inbound) >= limit {
+		return nil, errTooManyPeers
+	}
+
+	pm.peers[p.addr] = p
+
+	return p, nil
+}
+
+/*
+	收到对方的监听地址后，以监听地址重新登记对方主动建立的连接
+	已有其他连接使用该地址时保留原来的登记，监听地址只用于查找连接和地址簿，不影响封禁
+ */
+func (pm *PeerManager) rename(p *peer, addr string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.addr == addr {
+		return
+	}
+	if _, ok := pm.peers[addr]; ok {
+		return
+	}
+	if pm.peers[p.addr] == p {
+		delete(pm.peers, p.addr)
+	}
+	p.addr = addr
+	pm.peers[addr] = p
+}
+
+func (pm *PeerManager) remove(p *peer) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	p.mu.Lock()
+	addr := p.addr
+	p.mu.Unlock()
+
+	if pm.peers[addr] == p {
+		delete(pm.peers, addr)
+	}
+}
+
+func (pm *PeerManager) allPeers() []*peer {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	peers := make([]*peer, 0, len(pm.peers))
+	for _, p := range pm.peers {
+		peers = append(peers, p)
+	}
+
+	return peers
+}
+
+//已连接节点的信息，按地址排序
+func (pm *PeerManager) PeerInfo() []PeerInfo {
+	var infos []PeerInfo
+	for _, p := range pm.allPeers() {
+		infos = append(infos, p.info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Addr < infos[j].Addr
+	})
+
+	return infos
+}
+
+//断开与addr的连接
+func (pm *PeerManager) Disconnect(addr string) error {
+	p := pm.find(addr)
+	if p == nil {
+		return errPeerNotFound
+	}
+	p.disconnect(errors.New("disconnected by user"))
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package BlockInfo
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeerManagerLimits(t *testing.T) {
+	pm := NewPeerManager()
+
+	for i := 0; i < maxOutboundPeers; i++ {
+		_, conn := net.Pipe()
+		p, err := pm.add(newPeer(conn, string(rune('a'+i)), false))
+		assert.NoError(t, err)
+		assert.NotNil(t, p)
+	}
+	_, conn := net.Pipe()
+	_, err := pm.add(newPeer(conn, "z", false))
+	assert.Equal(t, errTooManyPeers, err)
+
+	//对方主动建立的连接单独计数
+	_, conn = net.Pipe()
+	inbound := newPeer(conn, "in", true)
+	p, err := pm.add(inbound)
+	assert.NoError(t, err)
+	assert.Equal(t, inbound, p)
+
+	//同一地址已有连接时返回已有的节点
+	p, err = pm.add(newPeer(conn, "a", false))
+	assert.NoError(t, err)
+	assert.Equal(t, pm.find("a"), p)
+
+	pm.rename(inbound, "localhost:3001")
+	assert.Nil(t, pm.find("in"))
+	assert.Equal(t, inbound, pm.find("localhost:3001"))
+	pm.remove(inbound)
+	assert.Nil(t, pm.find("localhost:3001"))
+}
+
+//远端地址为remote的连接
+type remoteConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c remoteConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func newRemotePeer(ip string, port int, addr string) *peer {
+	_, conn := net.Pipe()
+
+	return newPeer(remoteConn{conn, &net.TCPAddr{IP: net.ParseIP(ip), Port: port}}, addr, true)
+}
+
+func TestPeerManagerBan(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "peers.dat")
+	pm, err := loadPeerManager(file)
+	assert.NoError(t, err)
+	defer func(saved *PeerManager) { peerManager = saved }(peerManager)
+	peerManager = pm
+
+	fresh := pm.AddAddresses("10.0.0.1:3001", "10.0.0.2:3002")
+	assert.Equal(t, []string{"10.0.0.1:3001", "10.0.0.2:3002"}, fresh)
+	assert.Empty(t, pm.AddAddresses("10.0.0.2:3002"))
+	pm.markSuccess("10.0.0.2:3002")
+	assert.Equal(t, []string{"10.0.0.2:3002", "10.0.0.1:3001"}, pm.KnownAddresses())
+
+	//攻击者冒用诚实节点的监听地址，封禁的是攻击者连接的IP
+	honest := newRemotePeer("10.0.0.1", 3001, "10.0.0.1:3001")
+	_, err = pm.add(honest)
+	assert.NoError(t, err)
+	attacker := newRemotePeer("10.0.0.2", 50000, "10.0.0.2:50000")
+	_, err = pm.add(attacker)
+	assert.NoError(t, err)
+	pm.rename(attacker, "10.0.0.1:3001")
+	attacker.misbehaving(banThreshold, errors.New("invalid block"))
+
+	assert.False(t, honest.disconnected())
+	assert.True(t, attacker.disconnected())
+	assert.Equal(t, []string{"10.0.0.1:3001"}, pm.KnownAddresses())
+
+	//更换源端口后仍然被拒绝
+	_, err = pm.add(newRemotePeer("10.0.0.2", 50001, "10.0.0.2:50001"))
+	assert.Equal(t, errPeerBanned, err)
+
+	//地址簿和封禁保存后在重新加载时仍然有效
+	assert.NoError(t, pm.Save())
+	pm, err = loadPeerManager(file)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:3001"}, pm.KnownAddresses())
+	assert.True(t, pm.isBannedLocked("10.0.0.2", time.Now()))
+	assert.False(t, pm.isBannedLocked("10.0.0.2", time.Now().Add(banDuration+time.Minute)))
+}
+
+func TestPeerManagerEviction(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "peers.dat")
+	pm, err := loadPeerManager(file)
+	assert.NoError(t, err)
+
+	addrs := make([]string, maxKnownAddresses)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("10.0.%d.%d:3000", i/256, i%256)
+	}
+	assert.Len(t, pm.AddAddresses(addrs...), maxKnownAddresses)
+	pm.markSuccess(addrs[0])
+	pm.markFailed(addrs[1])
+
+	//地址簿已满时先淘汰连接失败的地址，再淘汰没有成功握手过的地址，成功握手过的地址保留
+	fresh := pm.AddAddresses("10.1.0.1:3000", "10.1.0.2:3000")
+	assert.Len(t, fresh, 2)
+	known := pm.KnownAddresses()
+	assert.Len(t, known, maxKnownAddresses)
+	assert.Equal(t, addrs[0], known[0])
+	assert.NotContains(t, known, addrs[1])
+
+	//地址簿只在Save时写入文件
+	_, err = os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, pm.Save())
+	pm, err = loadPeerManager(file)
+	assert.NoError(t, err)
+	assert.Len(t, pm.KnownAddresses(), maxKnownAddresses)
+}
+
+func TestPeerManagerLoopbackNotBanned(t *testing.T) {
+	pm := NewPeerManager()
+	defer func(saved *PeerManager) { peerManager = saved }(peerManager)
+	peerManager = pm
+
+	//本机的两个节点，其中一个发送了无效区块
+	honest := newRemotePeer("127.0.0.1", 50000, "localhost:3001")
+	_, err := pm.add(honest)
+	assert.NoError(t, err)
+	attacker := newRemotePeer("127.0.0.1", 50001, "localhost:3002")
+	_, err = pm.add(attacker)
+	assert.NoError(t, err)
+	attacker.misbehaving(banThreshold, errors.New("invalid block"))
+
+	assert.True(t, attacker.disconnected())
+	assert.False(t, honest.disconnected())
+	assert.False(t, pm.isBannedLocked("127.0.0.1", time.Now()))
+	_, err = pm.add(newRemotePeer("127.0.0.1", 50002, "127.0.0.1:50002"))
+	assert.NoError(t, err)
+}
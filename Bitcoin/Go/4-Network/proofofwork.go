@@ -4,17 +4,14 @@
 package BlockInfo
 
 import (
-	"BlockChain4Go/tools"
-	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
 	"math"
 	"math/big"
 )
 
-//难度值，表示区块头的哈希值前targetBits必须是0
-const targetBits = 20
-const maxNonce = math.MaxInt64
+const maxNonce = math.MaxUint32
 
 /*
 	工作量证明结构体
@@ -26,64 +23,77 @@ type ProofOfWork struct {
 	target *big.Int
 }
 
-//将区块创建一个新的工作量证明
+//将区块创建一个新的工作量证明，目标值由区块声明的Bits得到
 func NewProofOfWork(b *Block) *ProofOfWork {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-targetBits))
+	target := CompactToBig(b.Bits)
 
 	pow := &ProofOfWork{b, target}
 
 	return pow
 }
 
-//将工作量证明结构进行数据封装，包含PrevBlockHash、Data、Timestamp、targetBits、nonce
-func (pow *ProofOfWork) prepareData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.block.PrevBlockHash,
-			pow.block.HashTransactions(),
-			tools.IntToHex(pow.block.Timestamp),
-			tools.IntToHex(int64(targetBits)),
-			tools.IntToHex(int64(nonce)),
-		},
-		[]byte{},
-		)
+//计算满足目标值的区块平均所需的哈希次数，即区块的工作量 2^256 / (target+1)
+func (pow *ProofOfWork) Work() *big.Int {
+	numerator := new(big.Int).Lsh(big.NewInt(1), 256)
+	denominator := new(big.Int).Add(pow.target, big.NewInt(1))
+
+	return numerator.Div(numerator, denominator)
+}
+
+//将工作量证明结构进行数据封装，即区块头的序列化数据，包含Version、PrevBlockHash、MerkleRoot、Timestamp、Bits、nonce、Height
+func (pow *ProofOfWork) prepareData(nonce uint32) []byte {
+	data := pow.block.BlockHeader.Serialize()
+	binary.LittleEndian.PutUint32(data[nonceOffset:], nonce)
 
 	return data
 }
 
-//对当前工作量证明结构体进行计算，寻找有效的nonce，以符合工作量证明的哈希值
-func (pow *ProofOfWork) Run() (int, []byte)  {
+/*
+	对当前工作量证明结构体进行计算，寻找有效的nonce，以符合工作量证明的哈希值
+	区块头只序列化一次，每次尝试只修改其中nonce的4个字节后重新哈希
+	若nonce用尽仍未找到，则更新时间戳后重新开始
+ */
+func (pow *ProofOfWork) Run() (uint32, []byte)  {
 	var hashInt big.Int
 	var hash [32]byte
-	nonce := 0
 
 	fmt.Printf("Mining a new block ")
-	for nonce < maxNonce  {
-		data := pow.prepareData(nonce)
+	for {
+		data := pow.prepareData(0)
+
+		for nonce := uint64(0); nonce <= maxNonce; nonce++ {
+			binary.LittleEndian.PutUint32(data[nonceOffset:], uint32(nonce))
 
-		hash = sha256.Sum256(data)
-		hashInt.SetBytes(hash[:])
+			hash = sha256.Sum256(data)
+			hashInt.SetBytes(hash[:])
 
-		if hashInt.Cmp(pow.target) == -1 {
-			fmt.Printf("\r%x", hash)
-			break
-		} else {
-			nonce++
+			if hashInt.Cmp(pow.target) == -1 {
+				fmt.Printf("\r%x", hash)
+				fmt.Print("\n\n")
+				return uint32(nonce), hash[:]
+			}
 		}
+
+		pow.block.Timestamp++
 	}
+}
+
+//根据区块当前的nonce重新计算区块哈希
+func (pow *ProofOfWork) Hash() []byte {
+	hash := sha256.Sum256(pow.prepareData(pow.block.Nonce))
 
-	fmt.Print("\n\n")
-	return nonce, hash[:]
+	return hash[:]
 }
 
-//验证工作量证明是否有效
+//验证工作量证明是否有效，区块声明的目标值必须在(0, powLimit]范围内，且区块哈希小于目标值
 func (pow *ProofOfWork) Validate() bool {
 	var hashInt big.Int
 
-	data := pow.prepareData(pow.block.Nonce)
-	hash := sha256.Sum256(data)
-	hashInt.SetBytes(hash[:])
+	if pow.target.Sign() <= 0 || pow.target.Cmp(powLimit) > 0 {
+		return false
+	}
+
+	hashInt.SetBytes(pow.Hash())
 
 	isValid := hashInt.Cmp(pow.target) == -1
 
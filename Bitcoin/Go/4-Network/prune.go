@@ -0,0 +1,196 @@
+/*
+	区块修剪
+	开启修剪模式的节点只保留最近的区块数据：区块体和撤销数据占用的空间超过目标大小时，
+	按高度从低到高删除主链上旧区块的区块体和撤销数据，区块头、区块索引和高度索引始终保留，
+	链尾之前pruneSafetyDepth个区块内的区块不会被修剪，使节点仍然可以处理这个深度之内的链重组，
+	修剪后的节点无法向其他节点提供已修剪的区块，也无法重建UTXO集或交易索引
+ */
+package BlockInfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+//链尾之前至少保留多少个区块的区块数据
+const pruneSafetyDepth = 288
+
+const pruneStateBucket = "prunestate"
+//主链上区块数据仍然保存的最低高度，低于该高度的区块已被修剪
+const pruneHeightKey = "height"
+//区块体和撤销数据占用的字节数，随区块和撤销数据的写入、删除累计更新
+const storedSizeKey = "size"
+
+var ErrBlockPruned = errors.New("block data has been pruned")
+//修剪模式或从UTXO快照启动的节点没有修剪高度之前的区块，无法重建UTXO集或计算发行量
+var ErrMissingHistory = errors.New("blocks below the prune height are not stored on this node (pruned or loaded from a UTXO snapshot)")
+
+//读取修剪高度，没有修剪过的节点返回0
+func getPruneHeight(tx StoreTx) int {
+	b := tx.Bucket([]byte(pruneStateBucket))
+	if b == nil {
+		return 0
+	}
+
+	return decodeHeight(b.Get([]byte(pruneHeightKey)))
+}
+
+func putPruneHeight(tx StoreTx, height int) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(pruneStateBucket))
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte(pruneHeightKey), heightKey(height))
+}
+
+//获取主链上区块数据仍然保存的最低高度，节点只能提供从该高度开始的区块
+func (bc *Blockchain) PruneHeight() (int, error) {
+	var height int
+
+	err := bc.Db.View(func(tx StoreTx) error {
+		height = getPruneHeight(tx)
+		return nil
+	})
+
+	return height, err
+}
+
+//统计区块体和撤销数据占用的字节数
+func storedBlockSize(tx StoreTx) (int, error) {
+	size := 0
+	err := tx.Bucket([]byte(blocksBucket)).ForEach(func(k, v []byte) error {
+		if !bytes.Equal(k, []byte("l")) {
+			size += len(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	ub := tx.Bucket([]byte(undoBucket))
+	if ub == nil {
+		return size, nil
+	}
+	err = ub.ForEach(func(k, v []byte) error {
+		size += len(v)
+		return nil
+	})
+
+	return size, err
+}
+
+/*
+	读取区块体和撤销数据占用的字节数
+	该值保存在prunestate中，没有记录时（旧数据库或重建UTXO集之后）遍历区块体和撤销数据统计
+ */
+func getStoredSize(tx StoreTx) (int, error) {
+	b := tx.Bucket([]byte(pruneStateBucket))
+	if b != nil {
+		data := b.Get([]byte(storedSizeKey))
+		if len(data) == 8 {
+			return int(binary.BigEndian.Uint64(data)), nil
+		}
+	}
+
+	return storedBlockSize(tx)
+}
+
+func putStoredSize(tx StoreTx, size int) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(pruneStateBucket))
+	if err != nil {
+		return err
+	}
+
+	var data [8]byte
+	binary.BigEndian.PutUint64(data[:], uint64(size))
+	return b.Put([]byte(storedSizeKey), data[:])
+}
+
+//将占用的字节数增加delta，需要在写入或删除区块体、撤销数据之前调用，使没有记录时统计的是修改之前的数据
+func addStoredSize(tx StoreTx, delta int) error {
+	size, err := getStoredSize(tx)
+	if err != nil {
+		return err
+	}
+
+	return putStoredSize(tx, size+delta)
+}
+
+//删除占用字节数的记录，在整体删除撤销数据之后调用，下次读取时重新统计
+func resetStoredSize(tx StoreTx) error {
+	b := tx.Bucket([]byte(pruneStateBucket))
+	if b == nil {
+		return nil
+	}
+
+	return b.Delete([]byte(storedSizeKey))
+}
+
+/*
+	修剪旧区块，使区块体和撤销数据占用的空间不超过targetSize个字节，返回被修剪的区块数
+	1、UTXO集必须已经提交到当前链尾，否则不修剪
+	2、占用的空间（prunestate中的累计值，不需要遍历区块）不超过targetSize时不修剪
+	3、从上次的修剪高度开始，按高度从低到高删除主链区块的区块体和撤销数据，
+	   直到占用的空间不超过targetSize，或者到达链尾之前pruneSafetyDepth个区块
+	4、记录新的修剪高度和占用的空间，以上修改在一个事务中完成
+ */
+func (bc *Blockchain) Prune(targetSize int) (int, error) {
+	pruned := 0
+
+	err := bc.Db.Update(func(tx StoreTx) error {
+		if !bytes.Equal(getUTXOBestBlock(tx), bc.tip) {
+			return nil
+		}
+
+		size, err := getStoredSize(tx)
+		if err != nil {
+			return err
+		}
+		if size <= targetSize {
+			return nil
+		}
+
+		tipHeader, err := getBlockHeader(tx, bc.tip)
+		if err != nil {
+			return err
+		}
+
+		b := tx.Bucket([]byte(blocksBucket))
+		ub := tx.Bucket([]byte(undoBucket))
+		height := getPruneHeight(tx)
+		for ; size > targetSize && height <= tipHeader.Height-pruneSafetyDepth; height++ {
+			hash := getHashByHeight(tx, height)
+			if hash == nil {
+				return ErrBlockNotFound
+			}
+
+			size -= len(b.Get(hash))
+			err := b.Delete(hash)
+			if err != nil {
+				return err
+			}
+			if ub != nil {
+				size -= len(ub.Get(hash))
+				err = ub.Delete(hash)
+				if err != nil {
+					return err
+				}
+			}
+			pruned++
+		}
+
+		err = putStoredSize(tx, size)
+		if err != nil {
+			return err
+		}
+		return putPruneHeight(tx, height)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return pruned, nil
+}
@@ -0,0 +1,66 @@
+package BlockInfo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoredSize(t *testing.T) {
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	address := string(wallet.GetAddress())
+	store := NewMemoryStore()
+	bc, err := CreateBlockchainInStore(address, store)
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+
+	//累计值与遍历区块体和撤销数据统计的结果一致
+	check := func() int {
+		var stored, scanned int
+		assert.NoError(t, store.View(func(tx StoreTx) error {
+			var err error
+			if stored, err = getStoredSize(tx); err != nil {
+				return err
+			}
+			scanned, err = storedBlockSize(tx)
+			return err
+		}))
+		assert.Equal(t, scanned, stored)
+		return stored
+	}
+	genesisSize := check()
+	assert.True(t, genesisSize > 0)
+
+	block1, err := bc.MineBlock([]*Transaction{newTestCoinbase(t, address, 1, 0)})
+	assert.NoError(t, err)
+	block2, err := bc.MineBlock([]*Transaction{newTestCoinbase(t, address, 2, 0)})
+	assert.NoError(t, err)
+	size := check()
+	assert.True(t, size > genesisSize)
+
+	assert.NoError(t, bc.disconnectBlock(block2))
+	check()
+	assert.NoError(t, bc.connectBlock(block2, nil))
+	assert.Equal(t, size, check())
+
+	assert.NoError(t, UTXOSet{bc}.Reindex())
+	assert.Equal(t, size, check(), "Total is recounted after the undo data is rebuilt")
+
+	//旧数据库没有记录时统计一次
+	assert.NoError(t, store.Update(func(tx StoreTx) error {
+		return resetStoredSize(tx)
+	}))
+	_, err = bc.MineBlock([]*Transaction{newTestCoinbase(t, address, 3, 0)})
+	assert.NoError(t, err)
+	check()
+
+	pruned, err := bc.Prune(size * 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, pruned)
+	pruned, err = bc.Prune(0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, pruned, "Blocks within pruneSafetyDepth of the tip are kept")
+	_, err = bc.GetBlock(block1.Hash)
+	assert.NoError(t, err)
+}
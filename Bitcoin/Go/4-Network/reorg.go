@@ -0,0 +1,204 @@
+/*
+	分叉处理与链重组
+	当侧链的累计工作量超过主链时，将主链上分叉点之后的区块断开（通过撤销数据回滚UTXO集），
+	再将侧链上的区块依次链接到主链，每个区块的断开和链接都在一个事务中完成
+ */
+package BlockInfo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+/*
+	将父区块为当前链尾的区块链接到主链
+	1、对区块进行完整验证（包括UTXO集和签名）
+	2、在同一个事务中将链尾指向该区块、更新UTXO集并保存撤销数据、将区块写入高度索引，
+	   启用了交易索引时，将区块中的交易写入交易索引，index不为nil时（新接收的区块）区块和区块索引也在该事务中保存，
+	   程序在任何时候退出，数据库中的链尾与UTXO集都保持一致
+ */
+func (bc *Blockchain) connectBlock(block *Block, index *blockIndex) error {
+	err := bc.ValidateBlock(block)
+	if err != nil {
+		return err
+	}
+
+	err = bc.Db.Update(func(tx StoreTx) error {
+		if index != nil {
+			err := putBlock(tx, block)
+			if err != nil {
+				return err
+			}
+			err = putBlockIndex(tx, index)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := tx.Bucket([]byte(blocksBucket)).Put([]byte("l"), block.Hash)
+		if err != nil {
+			return err
+		}
+		err = connectUTXO(tx, block)
+		if err != nil {
+			return err
+		}
+		err = putHeightIndex(tx, block.Height, block.Hash)
+		if err != nil {
+			return err
+		}
+		return putTxIndex(tx, block)
+	})
+	if err != nil {
+		return err
+	}
+
+	bc.tip = block.Hash
+	return nil
+}
+
+/*
+	将当前链尾区块从主链断开
+	在同一个事务中通过区块的撤销数据回滚UTXO集，从高度索引和交易索引中删除该区块，并将链尾指向前一个区块
+ */
+func (bc *Blockchain) disconnectBlock(block *Block) error {
+	if !bytes.Equal(block.Hash, bc.tip) {
+		return fmt.Errorf("block %x is not the chain tip", block.Hash)
+	}
+
+	err := bc.Db.Update(func(tx StoreTx) error {
+		err := disconnectUTXO(tx, block)
+		if err != nil {
+			return err
+		}
+		err = deleteHeightIndex(tx, block.Height)
+		if err != nil {
+			return err
+		}
+		err = deleteTxIndex(tx, block)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket([]byte(blocksBucket)).Put([]byte("l"), block.PrevBlockHash)
+	})
+	if err != nil {
+		return err
+	}
+
+	bc.tip = block.PrevBlockHash
+	return nil
+}
+
+/*
+	查找当前主链与以newTip为链尾的分支的分叉点
+	返回需要从主链断开的区块（从链尾往前）和需要链接到主链的区块（从分叉点往后）
+ */
+func (bc *Blockchain) findFork(newTip *blockIndex) ([]*Block, []*Block, error) {
+	var detach, attach []*Block
+
+	oldIndex, err := bc.getBlockIndex(bc.tip)
+	if err != nil {
+		return nil, nil, err
+	}
+	newIndex := newTip
+
+	for oldIndex != nil && newIndex != nil && !bytes.Equal(oldIndex.Hash, newIndex.Hash) {
+		var block Block
+		if oldIndex.Height >= newIndex.Height {
+			block, err = bc.GetBlock(oldIndex.Hash)
+			if err != nil {
+				return nil, nil, err
+			}
+			detach = append(detach, &block)
+			oldIndex, err = bc.getBlockIndex(oldIndex.PrevHash)
+		} else {
+			block, err = bc.GetBlock(newIndex.Hash)
+			if err != nil {
+				return nil, nil, err
+			}
+			attach = append([]*Block{&block}, attach...)
+			newIndex, err = bc.getBlockIndex(newIndex.PrevHash)
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if oldIndex == nil || newIndex == nil {
+		return nil, nil, ErrPrevBlockNotFound
+	}
+
+	return detach, attach, nil
+}
+
+/*
+	将主链切换到以newTip为链尾的分支
+	1、找到分叉点，依次断开主链上分叉点之后的区块
+	2、依次链接新分支上的区块，若某个区块链接失败，则断开已链接的新分支区块，并重新链接原来的主链区块，
+	   只有区块验证不通过（BlockValidationError）时才将其及后代标记为无效，数据库错误等不代表区块无效
+	3、返回被断开区块中没有被新分支包含的非coinbase交易，用于放回交易池
+ */
+func (bc *Blockchain) reorganize(newTip *blockIndex) ([]*Transaction, error) {
+	detach, attach, err := bc.findFork(newTip)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(detach) > 0 {
+		fmt.Printf("Reorganizing: disconnecting %d blocks, connecting %d blocks\n", len(detach), len(attach))
+	}
+
+	for _, block := range detach {
+		err := bc.disconnectBlock(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, block := range attach {
+		err := bc.connectBlock(block, nil)
+		if err == nil {
+			continue
+		}
+
+		//回滚失败时主链处于不一致的状态，返回回滚的错误，而不是区块验证的错误
+		var invalid *BlockValidationError
+		if errors.As(err, &invalid) {
+			if rollbackErr := bc.markInvalid(block.Hash); rollbackErr != nil {
+				return nil, rollbackErr
+			}
+		}
+		for j := i - 1; j >= 0; j-- {
+			if rollbackErr := bc.disconnectBlock(attach[j]); rollbackErr != nil {
+				return nil, fmt.Errorf("rollback of reorganization failed: %v", rollbackErr)
+			}
+		}
+		for j := len(detach) - 1; j >= 0; j-- {
+			if rollbackErr := bc.connectBlock(detach[j], nil); rollbackErr != nil {
+				return nil, fmt.Errorf("rollback of reorganization failed: %v", rollbackErr)
+			}
+		}
+		return nil, err
+	}
+
+	included := make(map[string]bool)
+	for _, block := range attach {
+		for _, tx := range block.Transactions {
+			included[hex.EncodeToString(tx.ID)] = true
+		}
+	}
+
+	var txs []*Transaction
+	for _, block := range detach {
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() && !included[hex.EncodeToString(tx.ID)] {
+				txs = append(txs, tx)
+			}
+		}
+	}
+
+	return txs, nil
+}
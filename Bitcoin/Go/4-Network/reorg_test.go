@@ -0,0 +1,117 @@
+package BlockInfo
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReorganize(t *testing.T) {
+	params.CoinbaseMaturity = 1
+	defer func() { params.CoinbaseMaturity = 100 }()
+	defer func() { mempool = make(map[string]Transaction) }()
+
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	address := string(wallet.GetAddress())
+	bc, err := CreateBlockchainInStore(address, NewMemoryStore())
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+	genesis := bc.tip
+	_, err = bc.ReindexTransactions()
+	assert.NoError(t, err)
+	utxoSet := UTXOSet{bc}
+
+	spend, err := NewUTXOTransaction(wallet, address, 3, 1, &utxoSet)
+	assert.NoError(t, err)
+	a1 := newTestBlock(t, bc, genesis, []*Transaction{newTestCoinbase(t, address, 1, 1), spend})
+	_, err = bc.AddBlock(a1)
+	assert.NoError(t, err)
+
+	b1 := newTestBlock(t, bc, genesis, []*Transaction{newTestCoinbase(t, address, 1, 0)})
+	disconnected, err := bc.AddBlock(b1)
+	assert.NoError(t, err)
+	assert.Nil(t, disconnected)
+	assert.Equal(t, a1.Hash, bc.tip, "Branch with equal work does not replace the main chain")
+
+	//链重组时被断开的交易放回交易池
+	b2 := newTestBlock(t, bc, b1.Hash, []*Transaction{newTestCoinbase(t, address, 2, 0)})
+	err = acceptBlock(nil, b2, bc)
+	assert.NoError(t, err)
+	assert.Equal(t, b2.Hash, bc.tip, "Branch with more work becomes the main chain")
+	assert.Contains(t, mempool, hex.EncodeToString(spend.ID))
+
+	hash, err := bc.GetBlockHashByHeight(1)
+	assert.NoError(t, err)
+	assert.Equal(t, b1.Hash, hash)
+
+	_, err = utxoSet.FindEntry(a1.Transactions[0].ID, 0)
+	assert.Equal(t, ErrOutputNotFound, err, "Outputs of disconnected blocks are removed")
+	_, err = utxoSet.FindEntry(spend.ID, 0)
+	assert.Equal(t, ErrOutputNotFound, err)
+	entry, err := utxoSet.FindEntry(spend.Vin[0].Txid, spend.Vin[0].VoutIndex)
+	assert.NoError(t, err, "Outputs spent by disconnected blocks are restored")
+	assert.Equal(t, params.BlockSubsidy(0), entry.Output.Value)
+
+	_, err = bc.FindTransaction(spend.ID)
+	assert.Equal(t, ErrTransactionNotFound, err, "Disconnected transactions are removed from the transaction index")
+	_, header, err := bc.GetTransaction(b2.Transactions[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, header.Height)
+}
+
+func TestReorganizeRollback(t *testing.T) {
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	address := string(wallet.GetAddress())
+	bc, err := CreateBlockchainInStore(address, NewMemoryStore())
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+	genesis := bc.tip
+
+	a1, err := bc.MineBlock([]*Transaction{newTestCoinbase(t, address, 1, 0)})
+	assert.NoError(t, err)
+	a2, err := bc.MineBlock([]*Transaction{newTestCoinbase(t, address, 2, 0)})
+	assert.NoError(t, err)
+
+	//b2的coinbase超过区块奖励，只有在链接到主链时才会被发现
+	b1 := newTestBlock(t, bc, genesis, []*Transaction{newTestCoinbase(t, address, 1, 0)})
+	_, err = bc.AddBlock(b1)
+	assert.NoError(t, err)
+	b2 := newTestBlock(t, bc, b1.Hash, []*Transaction{newTestCoinbase(t, address, 2, 1)})
+	_, err = bc.AddBlock(b2)
+	assert.NoError(t, err, "Side chain blocks are only checked without the UTXO set")
+	b3 := newTestBlock(t, bc, b2.Hash, []*Transaction{newTestCoinbase(t, address, 3, 0)})
+	_, err = bc.AddBlock(b3)
+	assert.ErrorIs(t, err, ErrBadCoinbaseValue)
+
+	assert.Equal(t, a2.Hash, bc.tip, "Main chain is restored after a failed reorganization")
+	for height, block := range []*Block{a1, a2} {
+		hash, err := bc.GetBlockHashByHeight(height + 1)
+		assert.NoError(t, err)
+		assert.Equal(t, block.Hash, hash)
+
+		_, err = UTXOSet{bc}.FindEntry(block.Transactions[0].ID, 0)
+		assert.NoError(t, err)
+	}
+	_, err = UTXOSet{bc}.FindEntry(b1.Transactions[0].ID, 0)
+	assert.Equal(t, ErrOutputNotFound, err)
+
+	for _, block := range []*Block{b1, b2, b3} {
+		index, err := bc.getBlockIndex(block.Hash)
+		assert.NoError(t, err)
+		assert.Equal(t, block != b1, index.Invalid, "Invalid block and its descendants are marked invalid")
+	}
+	b4 := newTestBlock(t, bc, b3.Hash, []*Transaction{newTestCoinbase(t, address, 4, 0)})
+	_, err = bc.AddBlock(b4)
+	assert.ErrorIs(t, err, ErrPrevBlockInvalid)
+
+	b1Index, err := bc.getBlockIndex(b1.Hash)
+	assert.NoError(t, err)
+	detach, attach, err := bc.findFork(b1Index)
+	assert.NoError(t, err)
+	assert.Len(t, detach, 2)
+	assert.Len(t, attach, 1)
+	assert.Equal(t, [][]byte{a2.Hash, a1.Hash, b1.Hash}, [][]byte{detach[0].Hash, detach[1].Hash, attach[0].Hash})
+}
@@ -2,59 +2,97 @@ package BlockInfo
 
 import (
 	"bytes"
-	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"log"
+	"math/rand"
 	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
 const protocol = "tcp"
-const nodeVersion = 1
+//协议版本，低于minPeerVersion的节点使用不兼容的消息格式，握手时被拒绝
+const nodeVersion = 4
+const minPeerVersion = 4
 const commandLength = 12
+//收到的一条inv消息中最多包含的条目数
+const maxInvItems = 50000
+//一条addr消息中最多包含的地址数，转发给其他节点的addr消息最多包含addrRelayLimit个地址
+const maxAddrItems = 1000
+const addrRelayLimit = 10
 
+//补齐快照之前的区块时，等待每个区块的时间和检查间隔
+const backfillTimeout = 10 * time.Second
+const backfillPollInterval = 100 * time.Millisecond
+
+//告知其他节点的本节点地址
 var nodeListenAddress string
 var miningAddress string
-var knownNodes = []string{"localhost:3000"}
-var blocksInTransit = [][]byte{}
+//修剪模式下区块数据占用空间的目标字节数，0表示不修剪
+var pruneTarget int
+//启动时连接的种子节点，之后得知的地址保存在peerManager的地址簿中
+var seedNodes []string
 var mempool = make(map[string]Transaction)
+//节点的区块链，由StartServer设置，通过连接收到的消息都在这条链上处理
+var nodeChain *Blockchain
+//各个连接的读循环并发运行，handleLock保证同一时间只处理一条消息（交易池、正在下载的区块等不是并发安全的）
+var handleLock sync.Mutex
+//交易池中有新的交易或链尾改变时唤醒挖矿协程，缓冲为1，挖矿协程正忙时不会阻塞消息处理
+var minerWake = make(chan struct{}, 1)
 
 type addr struct {
 	AddrList []string
 }
 
+/*
+	以下消息都通过收到消息的连接回复，不携带发送方的地址
+	对方自己声明的地址没有经过验证，按地址回复会使节点向第三方发送数据
+ */
 type block struct {
-	AddrFrom string
 	Block []byte
 }
 
-type getblocks struct {
-	AddrFrom string
-}
-
 type getdata struct {
-	AddrFrom string
 	Type string
 	ID []byte
 }
 
 type inv struct {
-	AddrFrom string
 	Type     string
 	Items    [][]byte
 }
 
 type tx struct {
-	AddFrom     string
 	Transaction []byte
 }
 
+type notfound struct {
+	Type     string
+	ID       []byte
+}
+
+/*
+	握手时发送的version消息
+	Services：节点提供的服务
+	Timestamp：发送时节点的当前时间
+	Nonce：节点启动时生成的随机数，收到与自己相同的Nonce说明连接到了自己
+	StartHeight：节点当前的区块高度
+	PruneHeight：节点能够提供的最低区块高度，没有修剪的节点为0
+ */
 type verzion struct {
-	Version    int
-	BestHeight int
-	AddrFrom   string
+	Version     int
+	Services    ServiceFlag
+	Timestamp   int64
+	Nonce       uint64
+	UserAgent   string
+	StartHeight int
+	PruneHeight int
+	AddrFrom    string
 }
 
 func commandToBytes(command string) []byte {
@@ -81,376 +119,708 @@ func extractCommand(requeset []byte) []byte  {
 	return requeset[:commandLength]
 }
 
-func StartServer(nodeID, minerAddress string)  {
-	nodeListenAddress = fmt.Sprintf("localhost:%s", nodeID)
+//无法解码的消息体，errors.Is(err, ErrMalformedData)成立，发送方的不良行为分数会增加
+type malformedPayloadError struct {
+	err error
+}
+
+func (e *malformedPayloadError) Error() string {
+	return "malformed payload: " + e.err.Error()
+}
+
+func (e *malformedPayloadError) Is(target error) bool {
+	return target == ErrMalformedData
+}
+
+/*
+	按配置cfg启动节点
+	cfg.PruneSize大于0时开启修剪模式，区块数据占用的空间保持在cfg.PruneSize个字节以内
+ */
+func StartServer(nodeID string, cfg NodeConfig) error {
+	err := cfg.resolve()
+	if err != nil {
+		return err
+	}
+	nodeListenAddress = cfg.ExternalAddr
 	fmt.Println("myListenAddress:"+nodeListenAddress)
-	miningAddress = minerAddress
+	miningAddress = cfg.MinerAddress
+	pruneTarget = cfg.PruneSize
+	seedNodes = cfg.Seeds
 
-	ln, err := net.Listen(protocol, nodeListenAddress)
+	bc, err := GetBlockchain4db(nodeID)
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+	defer bc.Db.Close()
+	nodeChain = bc
+
+	peerManager, err = LoadPeerManager(nodeID)
+	if err != nil {
+		return err
+	}
+	//节点退出时保存地址簿，运行期间定时保存
+	quit := make(chan struct{})
+	go peerManager.saveLoop(quit)
+	defer func() {
+		close(quit)
+		err := peerManager.Save()
+		if err != nil {
+			log.Printf("Failed to save address book: %v\n", err)
+		}
+	}()
+
+	err = pruneBlocks(bc)
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen(protocol, cfg.ListenAddr)
+	if err != nil {
+		return err
 	}
 
 	defer ln.Close()
 
-	bc := GetBlockchain4db(nodeID)
-	if nodeListenAddress != knownNodes[0] {
-		sendVersion(knownNodes[0], bc)
+	control, err := listenControl(nodeID)
+	if err != nil {
+		return err
 	}
+	defer control.Close()
+	go serveControl(control)
+
+	connectKnownPeers(bc)
+	go downloadBlocks()
+	if len(miningAddress) > 0 {
+		go minerLoop(bc)
+	}
+	//开启修剪模式的节点不需要快照之前的区块数据
+	if pruneTarget <= 0 {
+		go backfillBlocks(bc)
+	}
+
+	//收到中断信号时停止接受连接，返回前保存地址簿、关闭控制接口和数据库
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	stopping := make(chan struct{})
+	go func() {
+		<-interrupt
+		close(stopping)
+		ln.Close()
+	}()
 
 	for  {
 		conn, err := ln.Accept()
 		if err != nil {
-			log.Panic(err)
+			select {
+			case <-stopping:
+				fmt.Println("Shutting down")
+				return nil
+			default:
+				return err
+			}
+		}
+		_, err = startPeer(newPeer(conn, conn.RemoteAddr().String(), true), bc)
+		if err != nil {
+			fmt.Printf("Rejected connection from %s: %v\n", conn.RemoteAddr(), err)
 		}
-		go handleConnection(conn, bc)
 	}
 }
 
-func handleConnection(conn net.Conn, bc *Blockchain)  {
-	request, err := ioutil.ReadAll(conn)
-	if err != nil {
-		log.Panic(err)
+/*
+	连接种子节点，以及地址簿中最近成功连接过的节点，主动建立的连接不超过maxOutboundPeers个
+	已连接的节点也计入连接数
+ */
+func connectKnownPeers(bc *Blockchain) {
+	addrs := append(append([]string{}, seedNodes...), peerManager.KnownAddresses()...)
+
+	connected := 0
+	for _, addr := range addrs {
+		if connected >= maxOutboundPeers {
+			return
+		}
+		if addr == nodeListenAddress {
+			continue
+		}
+		if peerManager.find(addr) != nil {
+			connected++
+			continue
+		}
+		_, err := connectPeer(addr, bc)
+		if err != nil {
+			fmt.Printf("%s is not available\n", addr)
+			peerManager.markFailed(addr)
+			continue
+		}
+		connected++
+	}
+}
+
+/*
+	处理其他节点通过连接p发来的一条消息
+	消息体无法解码时增加对方的不良行为分数
+ */
+func handleMessage(p *peer, msg message, bc *Blockchain) error {
+	handleLock.Lock()
+	defer handleLock.Unlock()
+
+	err := dispatchMessage(p, msg, bc)
+	if errors.Is(err, ErrMalformedData) {
+		p.misbehaving(banScoreMalformedData, err)
 	}
 
-	command := bytesToCommand(request[:commandLength])
-	fmt.Printf("Received %s command\n", command)
+	return err
+}
+
+func dispatchMessage(p *peer, msg message, bc *Blockchain) error {
+	request := msg.Payload
 
-	switch command {
+	switch msg.Command {
 	case "addr":
-		handleAddr(request)
+		return handleAddr(p, request, bc)
+	case "getaddr":
+		return handleGetAddr(p)
 	case "block":
-		handleBlock(request, bc)
+		return handleBlock(p, request, bc)
 	case "inv":
-		handleInv(request, bc)
-	case "getblocks":
-		handleGetBlocks(request, bc)
+		return handleInv(p, request, bc)
+	case "notfound":
+		return handleNotFound(p, request)
+	case "getheaders":
+		return handleGetHeaders(p, request, bc)
+	case "headers":
+		return handleHeaders(p, request, bc)
 	case "getdata":
-		handleGetData(request, bc)
+		return handleGetData(p, request, bc)
 	case "tx":
-		handleTx(request, bc)
+		return handleTx(p, request, bc)
 	case "version":
-		handleVersion(request, bc)
+		return handleVersion(p, request, bc)
+	case "verack":
+		return handleVerack(p)
 	default:
 		fmt.Println("Unknown command!")
 	}
 
-	conn.Close()
+	return nil
 }
 
-func handleAddr(request []byte)  {
-	var buff bytes.Buffer
+/*
+	处理对方发来的地址
+	1、将地址加入地址簿，地址过多时增加对方的不良行为分数
+	2、少量的新地址（例如刚启动的节点）转发给其他已连接的节点，使其在网络中传播
+	3、主动建立的连接不足maxOutboundPeers个时，在后台连接新的节点
+ */
+func handleAddr(p *peer, request []byte, bc *Blockchain) error {
 	var payload addr
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
+	}
+	if len(payload.AddrList) > maxAddrItems {
+		p.misbehaving(banScoreMalformedData, fmt.Errorf("addr message with %d addresses", len(payload.AddrList)))
+		return nil
 	}
 
-	knownNodes = append(knownNodes, payload.AddrList...)
-	fmt.Printf("There are %d known nodes now!\n", len(knownNodes))
-	requestBlocks()
+	fresh := peerManager.AddAddresses(payload.AddrList...)
+	fmt.Printf("There are %d known nodes now!\n", len(peerManager.KnownAddresses()))
+
+	if len(fresh) > 0 && len(payload.AddrList) <= addrRelayLimit {
+		err = relayAddresses(fresh, p)
+		if err != nil {
+			return err
+		}
+	}
+	if len(fresh) > 0 {
+		go connectKnownPeers(bc)
+	}
+
+	return nil
 }
 
-func requestBlocks()  {
-	for _, node := range knownNodes {
-		sendGetBlocks(node)
+//回复对方的getaddr，发送地址簿中最近成功连接过的地址
+func handleGetAddr(p *peer) error {
+	addrs := peerManager.KnownAddresses()
+	if len(addrs) > maxAddrItems {
+		addrs = addrs[:maxAddrItems]
+	}
+
+	payload := encodePayload(addr{addrs})
+	fmt.Println("command addr")
+	return p.queueMessage(message{"addr", payload})
+}
+
+//向除from之外所有已完成握手的节点发送消息
+func broadcast(msg message, from *peer) {
+	for _, p := range peerManager.allPeers() {
+		if p != from && p.handshakeComplete() {
+			//发送失败的节点已被断开，不影响发往其他节点
+			p.queueMessage(msg)
+		}
 	}
 }
 
-func handleInv(request []byte, bc *Blockchain)  {
-	var buff bytes.Buffer
+//向其他节点通告新的区块或交易
+func relayInventory(kind string, items [][]byte, from *peer) error {
+	payload := encodePayload(inv{kind, items})
+
+	fmt.Println("command inv")
+	broadcast(message{"inv", payload}, from)
+	return nil
+}
+
+//向其他节点转发新的地址
+func relayAddresses(addrs []string, from *peer) error {
+	payload := encodePayload(addr{addrs})
+
+	fmt.Println("command addr")
+	broadcast(message{"addr", payload}, from)
+	return nil
+}
+
+func handleInv(p *peer, request []byte, bc *Blockchain) error {
 	var payload inv
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	fmt.Printf("Received inventory with %d %s\n", len(payload.Items), payload.Type)
-	if payload.Type == "block" {
-		blocksInTransit = payload.Items
-
-		blockHash := payload.Items[0]
-		sendGetData(payload.AddrFrom, "block", blockHash)
+	if len(payload.Items) > maxInvItems {
+		p.misbehaving(banScoreMalformedData, fmt.Errorf("inv message with %d items", len(payload.Items)))
+		return nil
+	}
+	if len(payload.Items) == 0 {
+		return nil
+	}
 
-		newInTransit := [][]byte{}
-		for _, b := range blocksInTransit {
-			if bytes.Compare(b, blockHash) != 0 {
-				newInTransit = append(newInTransit, b)
+	if payload.Type == "block" {
+		//已有的区块不再请求，避免节点之间互相转发同一个区块，
+		//有未知的区块时先向对方请求区块头，验证后再下载区块数据
+		for _, hash := range payload.Items {
+			index, err := blockSync.index(bc, hash)
+			if err != nil {
+				return err
+			}
+			if index == nil {
+				return sendGetHeaders(p, bc)
 			}
 		}
-		blocksInTransit = newInTransit
 	}
 
 	if payload.Type == "tx" {
 		txID := payload.Items[0]
 
 		if mempool[hex.EncodeToString(txID)].ID == nil {
-			sendGetData(payload.AddrFrom, "tx", txID)
+			return sendGetData(p, "tx", txID)
 		}
 	}
 
+	return nil
+}
+
+//对方无法提供请求的区块时，改为向其他节点请求
+func handleNotFound(p *peer, request []byte) error {
+	var payload notfound
+
+	err := decodePayload(request, &payload)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s does not have %s %x\n", p, payload.Type, payload.ID)
+	if payload.Type == "block" {
+		blockSync.notFound(p, payload.ID)
+	}
+
+	return nil
 }
 
-func handleTx(request []byte, bc *Blockchain)  {
-	var buff bytes.Buffer
+//开启修剪模式时，在区块链接到主链后删除旧的区块数据
+func pruneBlocks(bc *Blockchain) error {
+	if pruneTarget <= 0 {
+		return nil
+	}
+
+	pruned, err := bc.Prune(pruneTarget)
+	if err != nil {
+		return err
+	}
+	if pruned > 0 {
+		fmt.Printf("Pruned %d blocks\n", pruned)
+	}
+
+	return nil
+}
+
+/*
+	从UTXO快照启动的节点在后台向保存了全部区块的已连接节点逐个请求快照之前的区块，补齐区块数据
+	收到的区块由handleBlock交给AddBlock保存，这里只轮询下一个需要补齐的区块，超时未收到则换一个节点重新请求
+ */
+func backfillBlocks(bc *Blockchain) {
+	for {
+		next, err := bc.NextBackfillBlock()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if next == nil {
+			return
+		}
+
+		p := findFullNode()
+		if p != nil {
+			err = sendGetData(p, "block", next)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+
+		for deadline := time.Now().Add(backfillTimeout); time.Now().Before(deadline); {
+			time.Sleep(backfillPollInterval)
+			current, err := bc.NextBackfillBlock()
+			if err != nil || !bytes.Equal(current, next) {
+				break
+			}
+		}
+	}
+}
+
+//随机选择一个已完成握手、保存了全部区块的节点，没有时返回nil
+func findFullNode() *peer {
+	var full []*peer
+	for _, p := range peerManager.allPeers() {
+		p.mu.Lock()
+		services := p.services
+		p.mu.Unlock()
+		if services&SFNodeNetwork != 0 && p.handshakeComplete() {
+			full = append(full, p)
+		}
+	}
+	if len(full) == 0 {
+		return nil
+	}
+
+	return full[rand.Intn(len(full))]
+}
+
+/*
+	处理对方发来的交易
+	签名无效等明显无效的交易会增加对方的不良行为分数，引用的输出不在UTXO集中的交易可能只是已被其他区块花费，不增加分数
+ */
+func handleTx(p *peer, request []byte, bc *Blockchain) error {
 	var payload tx
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	txData := payload.Transaction
-	tx := DeserializeTransaction(txData)
-	mempool[hex.EncodeToString(tx.ID)] = tx
+	tx, err := DeserializeTransaction(txData)
+	if err != nil {
+		return &malformedPayloadError{err}
+	}
+	if _, ok := mempool[hex.EncodeToString(tx.ID)]; ok {
+		return nil
+	}
 
 	//fmt.Printf("tx hash %x", tx.Hash())
 	//fmt.Println(tx)
-	if !bc.VerifyTransaction(&tx) {
-		fmt.Println("transactions are invalid! Waiting for new ones...")
-		return
+	err = bc.VerifyTransaction(&tx)
+	if err != nil {
+		fmt.Printf("Transaction %x is invalid: %v. Waiting for new ones...\n", tx.ID, err)
+		if !errors.Is(err, ErrMissingInput) {
+			p.misbehaving(banScoreInvalidTx, err)
+		}
+		return nil
 	}
 
-	if nodeListenAddress == knownNodes[0] {
-		for _, node := range knownNodes {
-			fmt.Println("node: "+node)
-			fmt.Println("nodeListenAddress: "+nodeListenAddress)
-			if node != nodeListenAddress && node != payload.AddFrom {
-				sendInv(node, "tx", [][]byte{tx.ID})
-			}
+	//交易的输入必须引用UTXO集中的输出，且输出总额不超过输入总额（手续费不为负数）
+	_, err = UTXOSet{bc}.TransactionFee(&tx)
+	if err != nil {
+		fmt.Printf("Transaction %x is rejected: %v\n", tx.ID, err)
+		if !errors.Is(err, ErrMissingInput) {
+			p.misbehaving(banScoreInvalidTx, err)
+		}
+		return nil
+	}
+	mempool[hex.EncodeToString(tx.ID)] = tx
+
+	//每个节点都将新的交易转发给除发送方之外的已连接节点，开启挖矿的节点同时打包交易
+	err = relayInventory("tx", [][]byte{tx.ID}, p)
+	if err != nil {
+		return err
+	}
+
+	if len(miningAddress) > 0 {
+		wakeMiner()
+	}
+
+	return nil
+}
+
+//唤醒挖矿协程，挖矿协程正在挖矿时只记录一次唤醒，不会阻塞
+func wakeMiner() {
+	select {
+	case minerWake <- struct{}{}:
+	default:
+	}
+}
+
+//开启挖矿的节点的挖矿协程，每次被唤醒后将交易池中的交易打包进新区块，直到交易池中没有可以打包的交易
+func minerLoop(bc *Blockchain) {
+	for range minerWake {
+		err := mineTransactions(bc)
+		if err != nil {
+			log.Println(err)
 		}
-	} else {
-		if len(mempool) >= 2 && len(miningAddress) > 0 {
-			MineTransaction:
-				var txs []*Transaction
-				for id := range mempool {
-					tx := mempool[id]
-					if bc.VerifyTransaction(&tx) {
-						txs = append(txs, &tx)
-					}
-				}
-				if len(txs) == 0 {
-					fmt.Println("All transactions are invalid! Waiting for new ones...")
-					return
-				}
-
-				cbTx := NewCoinbaseTX(miningAddress, "")
-				txs = append(txs, cbTx)
-
-				newBlock := bc.MineBlock(txs)
-				UTXOSet := UTXOSet{bc}
-				UTXOSet.Reindex()
-
-				fmt.Println("New block is mined!")
-
-				for _, tx := range txs {
-					txID := hex.EncodeToString(tx.ID)
-					delete(mempool, txID)
-				}
-
-				for _, node := range knownNodes {
-					if node != nodeListenAddress {
-						sendInv(node, "block", [][]byte{newBlock.Hash})
-					}
-				}
-
-				if len(mempool) > 0 {
-					goto MineTransaction
-				}
+	}
+}
+
+/*
+	将交易池中的交易打包进新区块，直到交易池中没有可以打包的交易
+	工作量证明不持有handleLock，挖矿期间节点仍然处理其他消息，只在选择交易和链接区块时持有handleLock
+ */
+func mineTransactions(bc *Blockchain) error {
+	for {
+		block, err := prepareMining(bc)
+		if err != nil || block == nil {
+			return err
+		}
+
+		block.mine()
+
+		err = submitMinedBlock(bc, block)
+		if err != nil {
+			return err
 		}
 	}
 }
 
-func handleGetBlocks(request []byte, bc *Blockchain)  {
-	var buff bytes.Buffer
-	var payload getblocks
+/*
+	持有handleLock从交易池中选择交易并生成待挖的区块，交易池为空或没有可以打包的交易时返回nil
+	按手续费率从交易池中选择交易，coinbase交易获得区块奖励+所选交易的手续费
+ */
+func prepareMining(bc *Blockchain) (*Block, error) {
+	handleLock.Lock()
+	defer handleLock.Unlock()
+
+	if len(mempool) == 0 {
+		return nil, nil
+	}
+	txs, fees := selectTransactions(bc, mempool)
+	if len(txs) == 0 {
+		fmt.Println("All transactions are invalid! Waiting for new ones...")
+		return nil, nil
+	}
+
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+	cbTx, err := NewCoinbaseTX(miningAddress, "", bestHeight+1, fees)
+	if err != nil {
+		return nil, err
+	}
+
+	return bc.prepareBlock(append([]*Transaction{cbTx}, txs...))
+}
+
+/*
+	持有handleLock将挖出的区块链接到主链
+	1、挖矿期间链尾已经改变（收到了其他节点的区块）时丢弃该区块，由调用者重新选择交易
+	2、从交易池中删除已打包的交易，开启修剪模式时删除旧的区块数据，并向其他节点通告新的区块
+ */
+func submitMinedBlock(bc *Blockchain, block *Block) error {
+	handleLock.Lock()
+	defer handleLock.Unlock()
+
+	if !bytes.Equal(block.PrevBlockHash, bc.tip) {
+		fmt.Println("The chain tip changed while mining, discarding the block")
+		return nil
+	}
+	_, err := bc.AddBlock(block)
+	if err != nil {
+		return err
+	}
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	fmt.Println("New block is mined!")
+	for _, tx := range block.Transactions {
+		delete(mempool, hex.EncodeToString(tx.ID))
+	}
+	err = pruneBlocks(bc)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	blocks := bc.GetBlockHashes()
-	sendInv(payload.AddrFrom, "block", blocks)
+	return relayInventory("block", [][]byte{block.Hash}, nil)
 }
 
-func handleBlock(request []byte, bc *Blockchain)  {
-	var buff bytes.Buffer
+/*
+	处理对方发来的区块
+	正在下载的区块交给blockSync按高度顺序链接，其他区块（例如补齐快照之前的区块）直接加入区块链，
+	缺少前一个区块时说明本节点落后了不止一个区块，向对方请求区块头
+ */
+func handleBlock(p *peer, request []byte, bc *Blockchain) error {
 	var payload block
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	blockData := payload.Block
-	block := DeserializeBlock(blockData)
+	block, err := DeserializeBlock(blockData)
+	if err != nil {
+		return &malformedPayloadError{err}
+	}
 
 	fmt.Println("Recevied a new block!")
-	bc.AddBlock(block)
+	if blockSync.requested(block.Hash) {
+		return blockSync.blockReceived(p, block, bc)
+	}
 
-	fmt.Printf("Added block %x\n", block.Hash)
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[0]
-		sendGetData(payload.AddrFrom, "block", blockHash)
+	known, err := bc.getBlockIndex(block.Hash)
+	if err != nil {
+		return err
+	}
+	err = acceptBlock(p, block, bc)
+	if errors.Is(err, ErrPrevBlockNotFound) {
+		return sendGetHeaders(p, bc)
+	}
+	if err != nil {
+		return err
+	}
 
-		blocksInTransit = blocksInTransit[1:]
-	} else {
-		UTXOSet := UTXOSet{bc}
-		UTXOSet.Reindex()
+	//将新的链尾区块通告给其他节点
+	if known == nil && bytes.Equal(block.Hash, bc.tip) {
+		return relayInventory("block", [][]byte{block.Hash}, p)
 	}
-}
 
-func handleVersion(request []byte, bc *Blockchain)  {
-	var buff bytes.Buffer
-	var payload verzion
+	return nil
+}
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+/*
+	将对方p发来的区块加入区块链
+	1、区块未通过验证时返回错误，缺少前一个区块和时间戳太新（可能只是双方的时钟不一致）以外的验证错误会使对方被封禁
+	2、已被打包进区块的交易从交易池中删除，链重组时被断开的交易重新放回交易池，交易池不为空时唤醒挖矿协程
+	3、开启修剪模式时删除旧的区块数据
+ */
+func acceptBlock(p *peer, block *Block, bc *Blockchain) error {
+	disconnected, err := bc.AddBlock(block)
 	if err != nil {
-		log.Panic(err)
+		var invalid *BlockValidationError
+		if errors.As(err, &invalid) && !errors.Is(err, ErrPrevBlockNotFound) && !errors.Is(err, ErrTimeTooNew) {
+			p.misbehaving(banScoreInvalidBlock, err)
+		}
+		return err
 	}
 
-	myBestHeight := bc.GetBestHeight()
-	foreignerBestHeight := payload.BestHeight
-
-	if myBestHeight < foreignerBestHeight {
-		sendGetBlocks(payload.AddrFrom)
-	} else if myBestHeight > foreignerBestHeight {
-		sendVersion(payload.AddrFrom, bc)
+	for _, tx := range block.Transactions {
+		delete(mempool, hex.EncodeToString(tx.ID))
 	}
-
-	if !nodeIsKnown(payload.AddrFrom) {
-		knownNodes = append(knownNodes, payload.AddrFrom)
+	for _, tx := range disconnected {
+		if bc.VerifyTransaction(tx) == nil {
+			mempool[hex.EncodeToString(tx.ID)] = *tx
+		}
 	}
-}
 
-func sendGetData(address, kind string, id []byte)  {
-	payload := gobEncode(getdata{nodeListenAddress, kind, id})
-	request := append(commandToBytes("getdata"), payload...)
-	fmt.Println("command getdata")
-	sendData(address, request)
-}
-
-func sendGetBlocks(address string)  {
-	payload := gobEncode(getblocks{nodeListenAddress})
-	request := append(commandToBytes("getblocks"), payload...)
-	fmt.Println("command getblocks")
-	sendData(address, request)
-}
+	fmt.Printf("Added block %x\n", block.Hash)
+	//链重组放回交易池的交易需要重新打包
+	if len(miningAddress) > 0 && len(mempool) > 0 {
+		wakeMiner()
+	}
 
-func sendInv(address, kind string, items [][]byte)  {
-	inventory := inv{nodeListenAddress, kind, items}
-	payload := gobEncode(inventory)
-	request := append(commandToBytes("inv"), payload...)
-	fmt.Println("command inv")
-	sendData(address, request)
+	return pruneBlocks(bc)
 }
 
-func sendVersion(addr string, bc *Blockchain)  {
-	bestHeight := bc.GetBestHeight()
-	payload := gobEncode(verzion{nodeVersion, bestHeight, nodeListenAddress})
-
-	requst := append(commandToBytes("version"), payload...)
-	fmt.Println("command version")
-	sendData(addr, requst)
+func sendGetData(p *peer, kind string, id []byte) error {
+	payload := encodePayload(getdata{kind, id})
+	fmt.Println("command getdata")
+	return p.queueMessage(message{"getdata", payload})
 }
 
-func sendBlock(addr string, b *Block)  {
-	data := block{nodeListenAddress, b.Serialize()}
-	payload := gobEncode(data)
+func sendBlock(p *peer, b *Block) error {
+	data := block{b.Serialize()}
+	payload := encodePayload(data)
 
-	request := append(commandToBytes("block"), payload...)
 	fmt.Println("command block")
-	sendData(addr, request)
+	return p.queueMessage(message{"block", payload})
 }
 
-func sendData(addr string, data []byte)  {
-	fmt.Println("Begin connect address: "+addr)
-	conn, err := net.Dial(protocol, addr)
+//不启动节点时（例如send命令）直接连接addr，完成握手后发送一条消息并断开连接
+func sendDataOnce(addr string, msg message) error {
+	conn, err := net.DialTimeout(protocol, addr, dialTimeout)
 	if err != nil {
-		fmt.Printf("%s is not available\n", addr)
-		//var updateNodes []string
-
-		return
+		return err
 	}
 	defer conn.Close()
 
-	_, err = io.Copy(conn, bytes.NewReader(data))
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	err = clientHandshake(conn)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
+
+	return writeMessage(conn, msg)
+}
+
+//通知对方请求的数据无法提供，例如区块数据已被修剪
+func sendNotFound(p *peer, kind string, id []byte) error {
+	payload := encodePayload(notfound{kind, id})
+	fmt.Println("command notfound")
+	return p.queueMessage(message{"notfound", payload})
 }
 
-func sendTx(addr string, tnx *Transaction)  {
-	data := tx{nodeListenAddress, tnx.Serialize()}
-	payload := gobEncode(data)
-	request := append(commandToBytes("tx"), payload...)
+func sendTx(p *peer, tnx *Transaction) error {
+	data := tx{tnx.Serialize()}
+	payload := encodePayload(data)
 
-	sendData(addr, request)
+	return p.queueMessage(message{"tx", payload})
 }
 
-func handleGetData(request []byte, bc *Blockchain)  {
-	var buff bytes.Buffer
+//将交易直接发送给地址为addr的节点，用于不启动节点的send命令
+func SubmitTransaction(addr string, tnx *Transaction) error {
+	data := tx{tnx.Serialize()}
+	payload := encodePayload(data)
+
+	return sendDataOnce(addr, message{"tx", payload})
+}
+
+//回复对方p的getdata，通过同一连接发送请求的区块或交易，没有请求的数据时回复notfound
+func handleGetData(p *peer, request []byte, bc *Blockchain) error {
 	var payload getdata
 
-	buff.Write(request[commandLength:])
-	dec := gob.NewDecoder(&buff)
-	err := dec.Decode(&payload)
+	err := decodePayload(request, &payload)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	if payload.Type == "block" {
 		block, err := bc.GetBlock([]byte(payload.ID))
+		if err == ErrBlockPruned || err == ErrBlockNotFound {
+			return sendNotFound(p, payload.Type, payload.ID)
+		}
 		if err != nil {
-			return
+			return err
 		}
 
-		sendBlock(payload.AddrFrom, &block)
+		return sendBlock(p, &block)
 	}
 
 	if payload.Type == "tx" {
 		txID := hex.EncodeToString(payload.ID)
-		tx := mempool[txID]
-
-		sendTx(payload.AddrFrom, &tx)
-	}
-
-}
-
-func gobEncode(data interface{}) []byte  {
-	var buff bytes.Buffer
+		tx, ok := mempool[txID]
+		if !ok {
+			//交易已被打包或从未收到过
+			return sendNotFound(p, payload.Type, payload.ID)
+		}
 
-	enc := gob.NewEncoder(&buff)
-	err := enc.Encode(data)
-	if err != nil {
-		log.Panic(err)
+		return sendTx(p, &tx)
 	}
 
-	return buff.Bytes()
+	return nil
 }
-
-func nodeIsKnown(addr string) bool  {
-	for _, node := range knownNodes {
-		if node == addr {
-			return true
-		}
-	}
-	return false
-}
-
-
@@ -0,0 +1,112 @@
+package BlockInfo
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//通过net.Pipe连接的、已完成握手的节点，测试从local读取发往该节点的消息
+func newTestPeer(t *testing.T) (*peer, net.Conn) {
+	local, remote := net.Pipe()
+	p := newPeer(remote, "pipe", true)
+	p.versionSent, p.versionReceived, p.verackReceived = true, true, true
+	go p.writeLoop()
+	t.Cleanup(func() {
+		p.disconnect(errPeerDisconnected)
+		local.Close()
+	})
+
+	return p, local
+}
+
+func TestHandleGetData(t *testing.T) {
+	defer func() { mempool = make(map[string]Transaction) }()
+
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	address := string(wallet.GetAddress())
+	bc, err := CreateBlockchainInStore(address, NewMemoryStore())
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+	p, local := newTestPeer(t)
+
+	request := func(kind string, id []byte) message {
+		payload := encodePayload(getdata{kind, id})
+		assert.NoError(t, handleGetData(p, payload, bc))
+
+		msg, err := readMessage(local)
+		assert.NoError(t, err)
+		return msg
+	}
+
+	//回复通过收到请求的连接发送
+	msg := request("block", bc.tip)
+	assert.Equal(t, "block", msg.Command)
+	var b block
+	assert.NoError(t, decodePayload(msg.Payload, &b))
+	received, err := DeserializeBlock(b.Block)
+	assert.NoError(t, err)
+	assert.Equal(t, bc.tip, received.Hash)
+
+	msg = request("block", make([]byte, 32))
+	assert.Equal(t, "notfound", msg.Command)
+	var missing notfound
+	assert.NoError(t, decodePayload(msg.Payload, &missing))
+	assert.Equal(t, notfound{"block", make([]byte, 32)}, missing)
+
+	coinbase := newTestCoinbase(t, address, 1, 0)
+	mempool[hex.EncodeToString(coinbase.ID)] = *coinbase
+	msg = request("tx", coinbase.ID)
+	assert.Equal(t, "tx", msg.Command)
+	var data tx
+	assert.NoError(t, decodePayload(msg.Payload, &data))
+	assert.Equal(t, coinbase.Serialize(), data.Transaction)
+
+	msg = request("tx", make([]byte, 32))
+	assert.Equal(t, "notfound", msg.Command)
+	assert.NoError(t, decodePayload(msg.Payload, &missing))
+	assert.Equal(t, notfound{"tx", make([]byte, 32)}, missing)
+}
+
+func TestMineTransactions(t *testing.T) {
+	params.CoinbaseMaturity = 1
+	defer func() { params.CoinbaseMaturity = 100 }()
+	defer func() { mempool = make(map[string]Transaction) }()
+	defer func() { miningAddress = "" }()
+
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	address := string(wallet.GetAddress())
+	bc, err := CreateBlockchainInStore(address, NewMemoryStore())
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+	miningAddress = address
+
+	spend, err := NewUTXOTransaction(wallet, address, 3, 1, &UTXOSet{bc})
+	assert.NoError(t, err)
+	mempool[hex.EncodeToString(spend.ID)] = *spend
+	assert.NoError(t, mineTransactions(bc))
+	assert.Empty(t, mempool)
+	_, header, err := bc.GetTransaction(spend.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, header.Height)
+
+	//挖矿期间链尾改变时丢弃挖出的区块
+	stale, err := bc.prepareBlock([]*Transaction{newTestCoinbase(t, address, 2, 0)})
+	assert.NoError(t, err)
+	stale.mine()
+	tip, err := bc.MineBlock([]*Transaction{newTestCoinbase(t, address, 2, 0)})
+	assert.NoError(t, err)
+	assert.NoError(t, submitMinedBlock(bc, stale))
+	assert.Equal(t, tip.Hash, bc.tip)
+	index, err := bc.getBlockIndex(stale.Hash)
+	assert.NoError(t, err)
+	assert.Nil(t, index)
+
+	//交易池为空时不挖矿
+	assert.NoError(t, mineTransactions(bc))
+	assert.Equal(t, tip.Hash, bc.tip)
+}
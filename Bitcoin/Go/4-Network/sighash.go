@@ -0,0 +1,169 @@
+/*
+	交易签名摘要（sighash）
+	签名的对象不再是交易结构体的十六进制打印，而是按以下规则构造的交易副本的规范序列化数据（见encoding.go）：
+	1、清空所有输入的Signature和PubKey
+	2、将正在签名的输入的PubKey替换为其引用的输出的锁定脚本（PubKeyHash）
+	3、根据签名类型裁剪输入和输出
+		SigHashAll：签名所有输入和输出
+		SigHashNone：不签名任何输出
+		SigHashSingle：只签名与输入索引号相同的输出，之前的输出置为空输出（Value为-1，脚本为空）
+		SigHashAnyoneCanPay：可与上面三种组合，只签名当前输入
+	4、在序列化数据后追加4个字节（小端序）的签名类型，进行两次SHA-256得到签名摘要
+
+	签名采用DER编码，并在末尾追加1个字节的签名类型；公钥采用SEC编码（压缩的33个字节或未压缩的65个字节）
+ */
+package BlockInfo
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+type SigHashType uint32
+
+const (
+	SigHashAll          SigHashType = 0x01
+	SigHashNone         SigHashType = 0x02
+	SigHashSingle       SigHashType = 0x03
+	SigHashAnyoneCanPay SigHashType = 0x80
+
+	sigHashMask = 0x1f
+)
+
+var ErrInvalidSigHashType = errors.New("invalid signature hash type")
+var ErrInvalidPubKey = errors.New("invalid public key encoding")
+
+//判断签名类型是否有效
+func (hashType SigHashType) valid() bool {
+	base := hashType &^ SigHashAnyoneCanPay
+	return base >= SigHashAll && base <= SigHashSingle
+}
+
+/*
+	计算交易第inputIndex个输入的签名摘要
+	prevOutput为该输入引用的输出，hashType为签名类型
+ */
+func (tx *Transaction) SignatureHash(inputIndex int, prevOutput TXOutput, hashType SigHashType) ([]byte, error) {
+	if !hashType.valid() {
+		return nil, ErrInvalidSigHashType
+	}
+	if inputIndex < 0 || inputIndex >= len(tx.Vin) {
+		return nil, errors.New("input index out of range")
+	}
+
+	txCopy := tx.TrimmedCopy()
+	txCopy.Vin[inputIndex].PubKey = prevOutput.PubKeyHash
+
+	switch hashType & sigHashMask {
+	case SigHashNone:
+		txCopy.Vout = nil
+	case SigHashSingle:
+		if inputIndex >= len(txCopy.Vout) {
+			return nil, ErrInvalidSigHashType
+		}
+		txCopy.Vout = txCopy.Vout[:inputIndex+1]
+		for i := 0; i < inputIndex; i++ {
+			txCopy.Vout[i] = TXOutput{-1, nil}
+		}
+	}
+
+	if hashType&SigHashAnyoneCanPay != 0 {
+		txCopy.Vin = []TXInput{txCopy.Vin[inputIndex]}
+	}
+
+	var buff bytes.Buffer
+	txCopy.encode(&buff)
+	writeUint32(&buff, uint32(hashType))
+
+	first := sha256.Sum256(buff.Bytes())
+	second := sha256.Sum256(first[:])
+
+	return second[:], nil
+}
+
+//对签名摘要进行签名，返回 DER编码的签名 + 1个字节的签名类型
+func signHash(privKey *ecdsa.PrivateKey, hash []byte, hashType SigHashType) ([]byte, error) {
+	signature, err := ecdsa.SignASN1(rand.Reader, privKey, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(signature, byte(hashType)), nil
+}
+
+//拆分输入中的签名，返回DER编码的签名和签名类型
+func splitSignature(signature []byte) ([]byte, SigHashType, error) {
+	if len(signature) < 2 {
+		return nil, 0, errors.New("signature is too short")
+	}
+
+	hashType := SigHashType(signature[len(signature)-1])
+	if !hashType.valid() {
+		return nil, 0, ErrInvalidSigHashType
+	}
+
+	return signature[:len(signature)-1], hashType, nil
+}
+
+//将公钥按SEC格式编码，compressed为true时使用33个字节的压缩格式
+func EncodePubKey(pubKey *ecdsa.PublicKey, compressed bool) []byte {
+	if compressed {
+		return elliptic.MarshalCompressed(pubKey.Curve, pubKey.X, pubKey.Y)
+	}
+
+	return elliptic.Marshal(pubKey.Curve, pubKey.X, pubKey.Y)
+}
+
+/*
+	解析SEC格式的公钥（0x02/0x03开头的压缩格式，或0x04开头的未压缩格式）
+	同时兼容旧版本钱包中 X.Bytes()+Y.Bytes() 直接拼接的公钥
+ */
+func ParsePubKey(data []byte) (*ecdsa.PublicKey, error) {
+	curve := elliptic.P256()
+	var x, y *big.Int
+
+	switch {
+	case len(data) == 33 && (data[0] == 0x02 || data[0] == 0x03):
+		x, y = elliptic.UnmarshalCompressed(curve, data)
+	case len(data) == 65 && data[0] == 0x04:
+		x, y = elliptic.Unmarshal(curve, data)
+	}
+	if x == nil {
+		x, y = parseLegacyPubKey(curve, data)
+	}
+
+	if x == nil {
+		return nil, ErrInvalidPubKey
+	}
+
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+/*
+	解析旧版本钱包的公钥，即X.Bytes()和Y.Bytes()直接拼接，坐标有前导0字节时不足64个字节，
+	依次尝试每一种拆分方式（两部分各自左补0到曲线的字节长度），返回在曲线上的点，都不在曲线上时返回nil
+ */
+func parseLegacyPubKey(curve elliptic.Curve, data []byte) (*big.Int, *big.Int) {
+	size := (curve.Params().BitSize + 7) / 8
+	if len(data) > 2*size {
+		return nil, nil
+	}
+
+	for xLen := len(data) - size; xLen <= size; xLen++ {
+		if xLen < 1 {
+			continue
+		}
+
+		x, y := new(big.Int).SetBytes(data[:xLen]), new(big.Int).SetBytes(data[xLen:])
+		if curve.IsOnCurve(x, y) {
+			return x, y
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,104 @@
+package BlockInfo
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignatureHash(t *testing.T) {
+	coinbase, tx := goldenTransactions()
+	prevOutput := coinbase.Vout[0]
+
+	//以下期望值按sighash.go中描述的规则独立计算得到
+	expected := map[SigHashType]string{
+		SigHashAll:                          "44e271594e971f76e71f95e2acb3b9e6c3be7ceeedbb80f892fae99d15bbd649",
+		SigHashNone:                         "b43e17b857f49634c8352e910bc5eb84600f842ea66c48f0fa5e624c35297110",
+		SigHashSingle | SigHashAnyoneCanPay: "6d753a79de80423597a36247c7d0509aaf1c1c1642b766317a4b84a5cf1b9c81",
+		SigHashAll | SigHashAnyoneCanPay:    "1ce61391e45155b9c19986043c1daf2b394bbe1b4c10b1d6f9b8b9d48bf989c6",
+	}
+
+	for hashType, hash := range expected {
+		actual, err := tx.SignatureHash(0, prevOutput, hashType)
+		assert.Nil(t, err)
+		assert.Equal(t, hash, hex.EncodeToString(actual), "Signature hash is correct")
+	}
+
+	_, err := tx.SignatureHash(0, prevOutput, 0x04)
+	assert.Equal(t, ErrInvalidSigHashType, err, "Unknown hash type is rejected")
+}
+
+func TestSignAndVerify(t *testing.T) {
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	pubKeyHash := Ripmd160Hash(wallet.PublicKey)
+
+	prevTx := Transaction{nil, []TXInput{{[]byte{}, -1, nil, []byte("prev")}}, []TXOutput{{10, pubKeyHash}}}
+	prevTx.ID = prevTx.Hash()
+	prevTXs := map[string]Transaction{hex.EncodeToString(prevTx.ID): prevTx}
+
+	newTx := func() *Transaction {
+		tx := &Transaction{nil, []TXInput{{prevTx.ID, 0, nil, wallet.PublicKey}}, []TXOutput{{7, pubKeyHash}, {3, pubKeyHash}}}
+		tx.ID = tx.Hash()
+		return tx
+	}
+
+	tx := newTx()
+	assert.NoError(t, tx.Sign(wallet.PrivateKey, prevTXs))
+	assert.NoError(t, tx.Verify(prevTXs), "Signed transaction is valid")
+
+	tx.Vout[0].Value = 8
+	assert.Equal(t, ErrInvalidSignature, tx.Verify(prevTXs), "Changing a signed output invalidates the signature")
+
+	tx = newTx()
+	assert.NoError(t, tx.SignWithType(wallet.PrivateKey, prevTXs, SigHashNone))
+	tx.Vout[0].Value = 8
+	assert.NoError(t, tx.Verify(prevTXs), "Outputs are not covered by SigHashNone")
+
+	tx = newTx()
+	assert.Equal(t, ErrMissingInput, tx.Sign(wallet.PrivateKey, map[string]Transaction{}), "Unknown previous transaction is rejected")
+
+	tx = newTx()
+	tx.Vin[0].PubKey = EncodePubKey(&wallet.PrivateKey.PublicKey, false)
+	prevTx.Vout[0].PubKeyHash = Ripmd160Hash(tx.Vin[0].PubKey)
+	prevTXs[hex.EncodeToString(prevTx.ID)] = prevTx
+	assert.NoError(t, tx.Sign(wallet.PrivateKey, prevTXs))
+	assert.NoError(t, tx.Verify(prevTXs), "Uncompressed public key is accepted")
+
+	other, err := NewWallet()
+	assert.NoError(t, err)
+	tx.Vin[0].PubKey = other.PublicKey
+	assert.Equal(t, ErrInvalidSignature, tx.Verify(prevTXs), "Public key must match the spent output")
+}
+
+func TestParseLegacyPubKey(t *testing.T) {
+	//旧版本钱包的公钥是X.Bytes()+Y.Bytes()，生成一个坐标有前导0字节的密钥
+	var wallet *Wallet
+	var legacy []byte
+	for legacy == nil || len(legacy) == 64 {
+		var err error
+		wallet, err = NewWallet()
+		assert.NoError(t, err)
+		pub := wallet.PrivateKey.PublicKey
+		legacy = append(pub.X.Bytes(), pub.Y.Bytes()...)
+	}
+
+	pubKey, err := ParsePubKey(legacy)
+	assert.NoError(t, err, "Legacy public key with a short coordinate is accepted")
+	assert.Equal(t, 0, pubKey.X.Cmp(wallet.PrivateKey.PublicKey.X))
+	assert.Equal(t, 0, pubKey.Y.Cmp(wallet.PrivateKey.PublicKey.Y))
+
+	//旧版本钱包的公钥仍然可以签名和验证交易
+	prevTx := Transaction{nil, []TXInput{{[]byte{}, -1, nil, []byte("prev")}}, []TXOutput{{10, Ripmd160Hash(legacy)}}}
+	prevTx.ID = prevTx.Hash()
+	prevTXs := map[string]Transaction{hex.EncodeToString(prevTx.ID): prevTx}
+	tx := &Transaction{nil, []TXInput{{prevTx.ID, 0, nil, legacy}}, []TXOutput{{10, Ripmd160Hash(legacy)}}}
+	tx.ID = tx.Hash()
+	assert.NoError(t, tx.Sign(wallet.PrivateKey, prevTXs))
+	assert.NoError(t, tx.Verify(prevTXs))
+
+	legacy[len(legacy)-1] ^= 1
+	_, err = ParsePubKey(legacy)
+	assert.Equal(t, ErrInvalidPubKey, err, "Point not on the curve is rejected")
+}
@@ -0,0 +1,459 @@
+/*
+	UTXO集快照
+	新节点可以直接从快照得到某个区块时的UTXO集，而不需要下载并重放从创世区块开始的所有区块
+	快照格式（定长整数采用小端序，varint、varbytes见encoding.go）：
+	"UTXO" | uint32(snapshotVersion) | varbytes(区块哈希) | uint32(区块高度)
+	| varint(区块头个数) | BlockHeader...（从创世区块到快照区块，每个88个字节）
+	| varint(输出个数) | (varbytes(UTXO集的key) | varbytes(UTXO集的value))...
+	| 32个字节的校验和（以上所有内容的SHA-256）
+	节点只加载区块哈希和校验和与共识参数TrustedSnapshots中某一项（或加载时指定的可信快照）一致的快照，
+	加载后快照区块及之前的区块只有区块头，区块数据之后从其他节点补齐
+ */
+package BlockInfo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const snapshotMagic = "UTXO"
+const snapshotVersion = 1
+
+//从快照启动的节点记录快照区块的高度，以及下一个需要补齐区块数据的高度，全部补齐后删除
+const snapshotStateBucket = "snapshotstate"
+const snapshotHeightKey = "height"
+const backfillHeightKey = "backfill"
+
+var (
+	ErrBadSnapshotChecksum = errors.New("snapshot checksum does not match its content")
+	ErrBadSnapshotHeaders  = errors.New("snapshot headers do not form a chain to its block")
+	ErrUntrustedSnapshot   = errors.New("snapshot does not match any trusted snapshot")
+)
+
+//可信的UTXO快照，区块哈希和校验和为十六进制字符串
+type SnapshotCheckpoint struct {
+	Height    int
+	BlockHash string
+	Checksum  string
+}
+
+//快照对应的区块、包含的输出个数和校验和
+type SnapshotInfo struct {
+	Height    int
+	BlockHash []byte
+	Outputs   int
+	Checksum  []byte
+}
+
+//解析后的快照
+type utxoSnapshot struct {
+	SnapshotInfo
+	headers []*BlockHeader
+	keys    [][]byte
+	values  [][]byte
+}
+
+/*
+	将UTXO集导出为快照并写入w
+	在一个只读事务中读取UTXO集对应的区块、从创世区块到该区块的区块头和UTXO集，保证快照前后一致
+ */
+func (bc *Blockchain) DumpUTXOSnapshot(w io.Writer) (SnapshotInfo, error) {
+	var info SnapshotInfo
+	var buf bytes.Buffer
+
+	err := bc.Db.View(func(tx StoreTx) error {
+		hash := getUTXOBestBlock(tx)
+		if hash == nil {
+			return ErrBlockNotFound
+		}
+
+		var headers []*BlockHeader
+		for h := hash; len(h) > 0; {
+			header, err := getBlockHeader(tx, h)
+			if err != nil {
+				return err
+			}
+			headers = append(headers, header)
+			h = header.PrevBlockHash
+		}
+
+		var entries bytes.Buffer
+		err := tx.Bucket([]byte(utxoBucket)).ForEach(func(k, v []byte) error {
+			writeVarBytes(&entries, k)
+			writeVarBytes(&entries, v)
+			info.Outputs++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		info.BlockHash = hash
+		info.Height = headers[0].Height
+
+		buf.WriteString(snapshotMagic)
+		writeUint32(&buf, snapshotVersion)
+		writeVarBytes(&buf, hash)
+		writeUint32(&buf, uint32(info.Height))
+		writeVarInt(&buf, uint64(len(headers)))
+		for i := len(headers) - 1; i >= 0; i-- {
+			buf.Write(headers[i].Serialize())
+		}
+		writeVarInt(&buf, uint64(info.Outputs))
+		buf.Write(entries.Bytes())
+
+		return nil
+	})
+	if err != nil {
+		return info, err
+	}
+
+	checksum := sha256.Sum256(buf.Bytes())
+	info.Checksum = checksum[:]
+	buf.Write(checksum[:])
+
+	_, err = w.Write(buf.Bytes())
+
+	return info, err
+}
+
+//检查校验和并解析快照
+func parseSnapshot(data []byte) (*utxoSnapshot, error) {
+	if len(data) < sha256.Size {
+		return nil, ErrMalformedData
+	}
+	content := data[:len(data)-sha256.Size]
+	checksum := sha256.Sum256(content)
+	if !bytes.Equal(checksum[:], data[len(content):]) {
+		return nil, ErrBadSnapshotChecksum
+	}
+
+	s := &utxoSnapshot{}
+	s.Checksum = checksum[:]
+	err := decodeAll(content, func(r *bytes.Reader) error {
+		magic := make([]byte, len(snapshotMagic))
+		if _, err := io.ReadFull(r, magic); err != nil || string(magic) != snapshotMagic {
+			return ErrMalformedData
+		}
+		version, err := readUint32(r)
+		if err != nil {
+			return err
+		}
+		if version != snapshotVersion {
+			return ErrUnsupportedVersion
+		}
+
+		s.BlockHash, err = readVarBytes(r)
+		if err != nil {
+			return err
+		}
+		height, err := readUint32(r)
+		if err != nil {
+			return err
+		}
+		s.Height = int(height)
+
+		n, err := readCount(r)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			data := make([]byte, blockHeaderLen)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return ErrMalformedData
+			}
+			header, err := DeserializeBlockHeader(data)
+			if err != nil {
+				return ErrMalformedData
+			}
+			s.headers = append(s.headers, header)
+		}
+
+		s.Outputs, err = readCount(r)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < s.Outputs; i++ {
+			key, err := readVarBytes(r)
+			if err != nil {
+				return err
+			}
+			value, err := readVarBytes(r)
+			if err != nil {
+				return err
+			}
+			s.keys = append(s.keys, key)
+			s.values = append(s.values, value)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+/*
+	检查快照中的区块头从创世区块开始逐个相连、高度连续，并以快照区块结束
+	每个区块头的Bits必须与难度调整规则计算的结果一致（创世区块为genesisBits），时间戳和工作量证明必须有效
+ */
+func (s *utxoSnapshot) checkHeaders() error {
+	if len(s.headers) != s.Height+1 {
+		return ErrBadSnapshotHeaders
+	}
+
+	chain := make(map[string]*blockIndex)
+	lookup := func(hash []byte) (*blockIndex, error) {
+		return chain[string(hash)], nil
+	}
+
+	var parent *blockIndex
+	for i, header := range s.headers {
+		block := &Block{*header, header.Hash(), nil}
+		bits := genesisBits
+		if parent != nil {
+			var err error
+			bits, err = nextBits(parent, lookup)
+			if err != nil {
+				return err
+			}
+		}
+
+		if header.Height != i || header.Bits != bits {
+			return ErrBadSnapshotHeaders
+		}
+		if (parent == nil && len(header.PrevBlockHash) > 0) || (parent != nil && !bytes.Equal(header.PrevBlockHash, parent.Hash)) {
+			return ErrBadSnapshotHeaders
+		}
+		if checkBlockTime(header, parent, lookup) != nil || !NewProofOfWork(block).Validate() {
+			return ErrBadSnapshotHeaders
+		}
+
+		parent = newBlockIndex(block, parent)
+		chain[string(parent.Hash)] = parent
+	}
+
+	if !bytes.Equal(parent.Hash, s.BlockHash) {
+		return ErrBadSnapshotHeaders
+	}
+
+	return nil
+}
+
+//快照的区块哈希和校验和是否与共识参数中某个可信快照一致
+func (info SnapshotInfo) trusted() bool {
+	for _, c := range params.TrustedSnapshots {
+		if c.Height == info.Height && c.BlockHash == hex.EncodeToString(info.BlockHash) && c.Checksum == hex.EncodeToString(info.Checksum) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+	从快照文件创建节点的区块链数据库，数据库已存在时返回ErrChainExists
+	txIndex为true时启用交易索引，快照之前的交易在区块数据补齐后才能通过交易索引查询
+ */
+func LoadUTXOSnapshot(path, nodeID string, txIndex bool) (*Blockchain, SnapshotInfo, error) {
+	var info SnapshotInfo
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, info, err
+	}
+
+	bc, err := createBlockchainFile(nodeID, func(store Store) (*Blockchain, error) {
+		var bc *Blockchain
+		var err error
+		bc, info, err = LoadUTXOSnapshotInStore(data, store, txIndex)
+		return bc, err
+	})
+
+	return bc, info, err
+}
+
+/*
+	在存储后端store中从快照数据创建区块链
+	1、检查校验和、解析快照，快照必须与某个可信快照一致，区块头必须从创世区块相连到快照区块
+	2、在一个事务中保存所有区块头、区块索引和高度索引，链尾指向快照区块
+	3、写入快照中的UTXO集并建立地址索引，UTXO集对应的区块为快照区块
+	4、将修剪高度设为快照区块高度+1（之前的区块没有区块数据，不能提供给其他节点），并记录需要补齐的区块
+ */
+func LoadUTXOSnapshotInStore(data []byte, store Store, txIndex bool) (*Blockchain, SnapshotInfo, error) {
+	s, err := parseSnapshot(data)
+	if err != nil {
+		return nil, SnapshotInfo{}, err
+	}
+	if !s.trusted() {
+		return nil, s.SnapshotInfo, ErrUntrustedSnapshot
+	}
+	err = s.checkHeaders()
+	if err != nil {
+		return nil, s.SnapshotInfo, err
+	}
+
+	err = store.Update(func(tx StoreTx) error {
+		b, err := tx.CreateBucket([]byte(blocksBucket))
+		if err == ErrBucketExists {
+			return ErrChainExists
+		}
+		if err != nil {
+			return err
+		}
+		hb, err := tx.CreateBucket([]byte(headersBucket))
+		if err != nil {
+			return err
+		}
+
+		var parent *blockIndex
+		for _, header := range s.headers {
+			hash := header.Hash()
+			err := hb.Put(hash, header.Serialize())
+			if err != nil {
+				return err
+			}
+
+			bi := newBlockIndex(&Block{*header, hash, nil}, parent)
+			err = putBlockIndex(tx, bi)
+			if err != nil {
+				return err
+			}
+			err = putHeightIndex(tx, header.Height, hash)
+			if err != nil {
+				return err
+			}
+			parent = bi
+		}
+		err = b.Put([]byte("l"), s.BlockHash)
+		if err != nil {
+			return err
+		}
+
+		for _, bucketName := range []string{utxoBucket, addrIndexBucket} {
+			_, err = tx.CreateBucket([]byte(bucketName))
+			if err != nil {
+				return err
+			}
+		}
+		for i, key := range s.keys {
+			if len(key) != utxoKeyLen {
+				return ErrMalformedData
+			}
+			entry, err := DeserializeUTXOEntry(s.values[i])
+			if err != nil {
+				return err
+			}
+			err = putUTXO(tx, key, entry)
+			if err != nil {
+				return err
+			}
+		}
+		err = putUTXOBestBlock(tx, s.BlockHash)
+		if err != nil {
+			return err
+		}
+
+		if txIndex {
+			_, err = tx.CreateBucket([]byte(txIndexBucket))
+			if err != nil {
+				return err
+			}
+		}
+
+		err = putPruneHeight(tx, s.Height+1)
+		if err != nil {
+			return err
+		}
+		sb, err := tx.CreateBucket([]byte(snapshotStateBucket))
+		if err != nil {
+			return err
+		}
+		err = sb.Put([]byte(snapshotHeightKey), heightKey(s.Height))
+		if err != nil {
+			return err
+		}
+		return sb.Put([]byte(backfillHeightKey), heightKey(0))
+	})
+	if err != nil {
+		return nil, s.SnapshotInfo, err
+	}
+
+	bc := Blockchain{append([]byte{}, s.BlockHash...), store}
+
+	return &bc, s.SnapshotInfo, nil
+}
+
+//获取下一个需要补齐区块数据的主链区块哈希，已全部补齐或不是从快照启动的节点返回nil
+func (bc *Blockchain) NextBackfillBlock() ([]byte, error) {
+	var hash []byte
+
+	err := bc.Db.View(func(tx StoreTx) error {
+		sb := tx.Bucket([]byte(snapshotStateBucket))
+		if sb == nil {
+			return nil
+		}
+
+		hash = getHashByHeight(tx, decodeHeight(sb.Get([]byte(backfillHeightKey))))
+		return nil
+	})
+
+	return hash, err
+}
+
+/*
+	补齐从快照启动的节点在快照之前的区块数据
+	1、只接受下一个需要补齐的区块（按高度从低到高），其他已知区块直接忽略
+	2、区块需要通过CheckBlock，即区块哈希与保存的区块头一致，交易与Merkle根一致
+	3、在一个事务中保存区块数据，启用了交易索引时写入交易索引，并记录下一个需要补齐的高度，
+	   快照区块补齐后删除快照状态，修剪高度恢复为0（节点没有修剪过其他区块时）
+ */
+func (bc *Blockchain) backfillBlock(block *Block) error {
+	next, err := bc.NextBackfillBlock()
+	if err != nil || !bytes.Equal(next, block.Hash) {
+		return err
+	}
+
+	err = CheckBlock(block)
+	if err != nil {
+		return err
+	}
+
+	return bc.Db.Update(func(tx StoreTx) error {
+		sb := tx.Bucket([]byte(snapshotStateBucket))
+		if sb == nil || decodeHeight(sb.Get([]byte(backfillHeightKey))) != block.Height {
+			return nil
+		}
+
+		err := putBlock(tx, block)
+		if err != nil {
+			return err
+		}
+		err = putTxIndex(tx, block)
+		if err != nil {
+			return err
+		}
+
+		snapshotHeight := decodeHeight(sb.Get([]byte(snapshotHeightKey)))
+		if block.Height < snapshotHeight {
+			return sb.Put([]byte(backfillHeightKey), heightKey(block.Height+1))
+		}
+
+		fmt.Println("All blocks before the UTXO snapshot are backfilled")
+		err = tx.DeleteBucket([]byte(snapshotStateBucket))
+		if err != nil {
+			return err
+		}
+		if getPruneHeight(tx) == snapshotHeight+1 {
+			return putPruneHeight(tx, 0)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,103 @@
+package BlockInfo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUTXOSnapshot(t *testing.T) {
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	address := string(wallet.GetAddress())
+
+	bc, err := CreateBlockchainInStore(address, NewMemoryStore())
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+
+	var buf bytes.Buffer
+	info, err := bc.DumpUTXOSnapshot(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, info.Height)
+	assert.Equal(t, bc.tip, info.BlockHash)
+	assert.Equal(t, 1, info.Outputs)
+	data := buf.Bytes()
+
+	_, _, err = LoadUTXOSnapshotInStore(data, NewMemoryStore(), false)
+	assert.Equal(t, ErrUntrustedSnapshot, err, "Snapshots not in the consensus parameters are rejected")
+
+	AddTrustedSnapshot(SnapshotCheckpoint{info.Height, hex.EncodeToString(info.BlockHash), hex.EncodeToString(info.Checksum)})
+	defer func() { params.TrustedSnapshots = nil }()
+
+	corrupted := append([]byte{}, data...)
+	corrupted[len(corrupted)/2] ^= 1
+	_, _, err = LoadUTXOSnapshotInStore(corrupted, NewMemoryStore(), false)
+	assert.Equal(t, ErrBadSnapshotChecksum, err)
+
+	store := NewMemoryStore()
+	loaded, _, err := LoadUTXOSnapshotInStore(data, store, false)
+	assert.NoError(t, err)
+	_, _, err = LoadUTXOSnapshotInStore(data, store, false)
+	assert.Equal(t, ErrChainExists, err)
+
+	balance, immature, err := UTXOSet{loaded}.GetBalance(Ripmd160Hash(wallet.PublicKey))
+	assert.NoError(t, err)
+	assert.Equal(t, params.BlockSubsidy(0), balance+immature)
+
+	//快照区块的区块数据需要补齐
+	_, err = loaded.GetBlock(info.BlockHash)
+	assert.Equal(t, ErrBlockPruned, err)
+
+	//补齐之前无法计算发行量或重建UTXO集
+	_, err = loaded.CalculateSupply()
+	assert.Equal(t, ErrMissingHistory, err)
+	assert.Equal(t, ErrMissingHistory, UTXOSet{loaded}.Reindex())
+	assert.NoError(t, store.Update(func(tx StoreTx) error {
+		return putUTXOBestBlock(tx, make([]byte, 32))
+	}))
+	assert.ErrorIs(t, loaded.ensureUTXOSet(), ErrMissingHistory)
+	assert.NoError(t, store.Update(func(tx StoreTx) error {
+		return putUTXOBestBlock(tx, info.BlockHash)
+	}))
+	next, err := loaded.NextBackfillBlock()
+	assert.NoError(t, err)
+	assert.Equal(t, info.BlockHash, next)
+
+	genesis, err := bc.GetBlock(info.BlockHash)
+	assert.NoError(t, err)
+	_, err = loaded.AddBlock(&genesis)
+	assert.NoError(t, err)
+	next, err = loaded.NextBackfillBlock()
+	assert.NoError(t, err)
+	assert.Nil(t, next)
+	pruneHeight, err := loaded.PruneHeight()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, pruneHeight)
+}
+
+func TestSnapshotHeaders(t *testing.T) {
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	address := string(wallet.GetAddress())
+	bc, err := CreateBlockchainInStore(address, NewMemoryStore())
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+	genesis, err := bc.GetBlockHeader(bc.tip)
+	assert.NoError(t, err)
+	block1, err := bc.MineBlock([]*Transaction{newTestCoinbase(t, address, 1, 0)})
+	assert.NoError(t, err)
+
+	s := &utxoSnapshot{SnapshotInfo: SnapshotInfo{Height: 1, BlockHash: block1.Hash}, headers: []*BlockHeader{genesis, &block1.BlockHeader}}
+	assert.NoError(t, s.checkHeaders())
+
+	//以最低难度挖出的区块头工作量证明有效，但Bits与难度调整规则不一致
+	easy := newBlockAt([]*Transaction{newTestCoinbase(t, address, 1, 0)}, genesis.Hash(), 1, BigToCompact(powLimit), block1.Timestamp)
+	s = &utxoSnapshot{SnapshotInfo: SnapshotInfo{Height: 1, BlockHash: easy.Hash}, headers: []*BlockHeader{genesis, &easy.BlockHeader}}
+	assert.True(t, NewProofOfWork(easy).Validate())
+	assert.Equal(t, ErrBadSnapshotHeaders, s.checkHeaders())
+
+	s = &utxoSnapshot{SnapshotInfo: SnapshotInfo{Height: 1, BlockHash: genesis.Hash()}, headers: []*BlockHeader{genesis, &block1.BlockHeader}}
+	assert.Equal(t, ErrBadSnapshotHeaders, s.checkHeaders(), "Headers must end with the snapshot block")
+}
@@ -0,0 +1,48 @@
+/*
+	存储后端接口
+	区块链的所有数据（区块、区块头、UTXO集、各类索引）都以"桶(bucket) + key/value"的形式保存，
+	Blockchain和UTXOSet只通过Store接口访问数据，而不直接依赖某个具体的数据库，
+	目前提供两种实现：
+	1、boltStore：基于boltdb的文件数据库，节点默认使用，对应blockchain_%s.db文件
+	2、memoryStore：内存数据库，不落盘，用于单元测试
+	LevelDB/Pebble这类只有单一key空间的数据库，可以将桶名作为key的前缀来实现该接口
+ */
+package BlockInfo
+
+import "errors"
+
+var (
+	ErrBucketNotFound = notFound("bucket")
+	ErrBucketExists   = errors.New("bucket already exists")
+)
+
+//存储后端，View为只读事务，Update为读写事务，Update中的所有修改要么全部生效，要么在返回错误时全部回滚
+type Store interface {
+	View(fn func(tx StoreTx) error) error
+	Update(fn func(tx StoreTx) error) error
+	Close() error
+}
+
+//存储事务，桶不存在时Bucket返回nil，DeleteBucket返回ErrBucketNotFound
+type StoreTx interface {
+	Bucket(name []byte) StoreBucket
+	CreateBucket(name []byte) (StoreBucket, error)
+	CreateBucketIfNotExists(name []byte) (StoreBucket, error)
+	DeleteBucket(name []byte) error
+}
+
+//桶中的key按字节序排列，Get返回的值只在事务内有效，需要在事务外使用时应复制一份
+type StoreBucket interface {
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	Cursor() StoreCursor
+	ForEach(fn func(k, v []byte) error) error
+}
+
+//按key的字节序遍历桶的游标，遍历结束时返回的key为nil
+type StoreCursor interface {
+	First() (key, value []byte)
+	Next() (key, value []byte)
+	Seek(seek []byte) (key, value []byte)
+}
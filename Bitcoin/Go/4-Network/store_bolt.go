@@ -0,0 +1,93 @@
+/*
+	基于boltdb的存储后端
+	boltdb本身就是桶 + key/value的结构，这里只是将bolt的类型包装成Store接口
+ */
+package BlockInfo
+
+import "github.com/boltdb/bolt"
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+type boltBucket struct {
+	*bolt.Bucket
+}
+
+type boltCursor struct {
+	*bolt.Cursor
+}
+
+//打开（不存在时创建）path对应的bolt数据库文件
+func OpenBoltStore(path string) (Store, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &boltStore{db}, nil
+}
+
+func (s *boltStore) View(fn func(tx StoreTx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (s *boltStore) Update(fn func(tx StoreTx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+//桶不存在时必须返回nil接口，而不是包含空指针的接口
+func (t boltTx) Bucket(name []byte) StoreBucket {
+	b := t.tx.Bucket(name)
+	if b == nil {
+		return nil
+	}
+
+	return boltBucket{b}
+}
+
+func (t boltTx) CreateBucket(name []byte) (StoreBucket, error) {
+	b, err := t.tx.CreateBucket(name)
+	if err == bolt.ErrBucketExists {
+		return nil, ErrBucketExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return boltBucket{b}, nil
+}
+
+func (t boltTx) CreateBucketIfNotExists(name []byte) (StoreBucket, error) {
+	b, err := t.tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return boltBucket{b}, nil
+}
+
+func (t boltTx) DeleteBucket(name []byte) error {
+	err := t.tx.DeleteBucket(name)
+	if err == bolt.ErrBucketNotFound {
+		return ErrBucketNotFound
+	}
+
+	return err
+}
+
+func (b boltBucket) Cursor() StoreCursor {
+	return boltCursor{b.Bucket.Cursor()}
+}
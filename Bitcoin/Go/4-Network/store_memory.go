@@ -0,0 +1,260 @@
+/*
+	内存存储后端
+	所有数据保存在内存中，进程退出后即丢失，用于在单元测试中运行完整的区块链而不需要创建数据库文件
+	1、每个桶保存key到value的映射，以及按字节序排好序的key列表，用于游标遍历
+	2、读写事务记录每次修改前的内容，回调返回错误时按相反顺序恢复，实现与bolt相同的原子性
+	3、读写锁保证同一时刻只有一个读写事务，或者多个只读事务
+ */
+package BlockInfo
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	errStoreClosed   = errors.New("store is closed")
+	errTxNotWritable = errors.New("transaction is not writable")
+	errKeyRequired   = errors.New("key required")
+)
+
+type memoryStore struct {
+	mu      sync.RWMutex
+	buckets map[string]*memoryBucket
+}
+
+type memoryBucket struct {
+	keys   []string
+	values map[string][]byte
+}
+
+type memoryTx struct {
+	store    *memoryStore
+	writable bool
+	undo     []func()
+}
+
+type memoryBucketHandle struct {
+	tx     *memoryTx
+	bucket *memoryBucket
+}
+
+type memoryCursor struct {
+	bucket  *memoryBucket
+	current string
+}
+
+//创建一个空的内存存储
+func NewMemoryStore() Store {
+	return &memoryStore{buckets: make(map[string]*memoryBucket)}
+}
+
+func (s *memoryStore) View(fn func(tx StoreTx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.buckets == nil {
+		return errStoreClosed
+	}
+
+	return fn(&memoryTx{store: s})
+}
+
+func (s *memoryStore) Update(fn func(tx StoreTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.buckets == nil {
+		return errStoreClosed
+	}
+
+	tx := &memoryTx{store: s, writable: true}
+	err := fn(tx)
+	if err != nil {
+		for i := len(tx.undo) - 1; i >= 0; i-- {
+			tx.undo[i]()
+		}
+	}
+
+	return err
+}
+
+func (s *memoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.buckets = nil
+
+	return nil
+}
+
+func (tx *memoryTx) Bucket(name []byte) StoreBucket {
+	b, ok := tx.store.buckets[string(name)]
+	if !ok {
+		return nil
+	}
+
+	return &memoryBucketHandle{tx, b}
+}
+
+func (tx *memoryTx) CreateBucket(name []byte) (StoreBucket, error) {
+	if !tx.writable {
+		return nil, errTxNotWritable
+	}
+	if _, ok := tx.store.buckets[string(name)]; ok {
+		return nil, ErrBucketExists
+	}
+
+	b := &memoryBucket{values: make(map[string][]byte)}
+	tx.store.buckets[string(name)] = b
+	tx.undo = append(tx.undo, func() {
+		delete(tx.store.buckets, string(name))
+	})
+
+	return &memoryBucketHandle{tx, b}, nil
+}
+
+func (tx *memoryTx) CreateBucketIfNotExists(name []byte) (StoreBucket, error) {
+	if b := tx.Bucket(name); b != nil {
+		return b, nil
+	}
+
+	return tx.CreateBucket(name)
+}
+
+func (tx *memoryTx) DeleteBucket(name []byte) error {
+	if !tx.writable {
+		return errTxNotWritable
+	}
+	b, ok := tx.store.buckets[string(name)]
+	if !ok {
+		return ErrBucketNotFound
+	}
+
+	delete(tx.store.buckets, string(name))
+	tx.undo = append(tx.undo, func() {
+		tx.store.buckets[string(name)] = b
+	})
+
+	return nil
+}
+
+func (h *memoryBucketHandle) Get(key []byte) []byte {
+	return h.bucket.values[string(key)]
+}
+
+//保存key和value的副本，调用方之后修改传入的切片不会影响已保存的数据
+func (h *memoryBucketHandle) Put(key, value []byte) error {
+	if !h.tx.writable {
+		return errTxNotWritable
+	}
+	if len(key) == 0 {
+		return errKeyRequired
+	}
+
+	b := h.bucket
+	k := string(key)
+	old, existed := b.values[k]
+	if !existed {
+		b.insertKey(k)
+	}
+	b.values[k] = append([]byte{}, value...)
+
+	h.tx.undo = append(h.tx.undo, func() {
+		if existed {
+			b.values[k] = old
+		} else {
+			delete(b.values, k)
+			b.removeKey(k)
+		}
+	})
+
+	return nil
+}
+
+func (h *memoryBucketHandle) Delete(key []byte) error {
+	if !h.tx.writable {
+		return errTxNotWritable
+	}
+
+	b := h.bucket
+	k := string(key)
+	old, existed := b.values[k]
+	if !existed {
+		return nil
+	}
+	delete(b.values, k)
+	b.removeKey(k)
+
+	h.tx.undo = append(h.tx.undo, func() {
+		b.insertKey(k)
+		b.values[k] = old
+	})
+
+	return nil
+}
+
+func (h *memoryBucketHandle) Cursor() StoreCursor {
+	return &memoryCursor{bucket: h.bucket}
+}
+
+//遍历key列表的副本，回调中修改桶不会影响本次遍历
+func (h *memoryBucketHandle) ForEach(fn func(k, v []byte) error) error {
+	keys := append([]string{}, h.bucket.keys...)
+	for _, k := range keys {
+		v, ok := h.bucket.values[k]
+		if !ok {
+			continue
+		}
+
+		err := fn([]byte(k), v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b *memoryBucket) insertKey(k string) {
+	i := sort.SearchStrings(b.keys, k)
+	b.keys = append(b.keys, "")
+	copy(b.keys[i+1:], b.keys[i:])
+	b.keys[i] = k
+}
+
+func (b *memoryBucket) removeKey(k string) {
+	i := sort.SearchStrings(b.keys, k)
+	if i < len(b.keys) && b.keys[i] == k {
+		b.keys = append(b.keys[:i], b.keys[i+1:]...)
+	}
+}
+
+//游标只记录当前的key，每次移动时重新在key列表中查找位置，因此遍历过程中修改桶也是安全的
+func (c *memoryCursor) at(i int) ([]byte, []byte) {
+	if i >= len(c.bucket.keys) {
+		return nil, nil
+	}
+
+	c.current = c.bucket.keys[i]
+
+	return []byte(c.current), c.bucket.values[c.current]
+}
+
+func (c *memoryCursor) First() ([]byte, []byte) {
+	return c.at(0)
+}
+
+func (c *memoryCursor) Next() ([]byte, []byte) {
+	i := sort.SearchStrings(c.bucket.keys, c.current)
+	if i < len(c.bucket.keys) && c.bucket.keys[i] == c.current {
+		i++
+	}
+
+	return c.at(i)
+}
+
+func (c *memoryCursor) Seek(seek []byte) ([]byte, []byte) {
+	return c.at(sort.SearchStrings(c.bucket.keys, string(seek)))
+}
@@ -0,0 +1,90 @@
+package BlockInfo
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	err := store.Update(func(tx StoreTx) error {
+		b, err := tx.CreateBucket([]byte("bucket"))
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"b2", "a", "b1", "c"} {
+			err = b.Put([]byte(k), []byte("v"+k))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	assert.NoError(t, err)
+
+	failed := errors.New("failed")
+	err = store.Update(func(tx StoreTx) error {
+		b := tx.Bucket([]byte("bucket"))
+		b.Put([]byte("a"), []byte("changed"))
+		b.Put([]byte("d"), []byte("vd"))
+		b.Delete([]byte("c"))
+		tx.CreateBucket([]byte("other"))
+
+		return failed
+	})
+	assert.Equal(t, failed, err)
+
+	store.View(func(tx StoreTx) error {
+		assert.Nil(t, tx.Bucket([]byte("other")), "Failed update is rolled back")
+
+		b := tx.Bucket([]byte("bucket"))
+		assert.Equal(t, []byte("va"), b.Get([]byte("a")), "Failed update is rolled back")
+		assert.Nil(t, b.Get([]byte("d")), "Failed update is rolled back")
+
+		var keys []string
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		assert.Equal(t, []string{"a", "b1", "b2", "c"}, keys, "Cursor walks keys in byte order")
+
+		k, v := c.Seek([]byte("b"))
+		assert.Equal(t, "b1", string(k), "Seek moves to the first key not less than the seek key")
+		assert.Equal(t, "vb1", string(v))
+
+		return nil
+	})
+}
+
+func TestBlockchainInMemoryStore(t *testing.T) {
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	address := string(wallet.GetAddress())
+
+	store := NewMemoryStore()
+	defer store.Close()
+
+	_, err = OpenBlockchain(store)
+	assert.Equal(t, ErrChainNotFound, err)
+
+	bc, err := CreateBlockchainInStore(address, store)
+	assert.NoError(t, err)
+	_, err = CreateBlockchainInStore(address, store)
+	assert.Equal(t, ErrChainExists, err)
+
+	balance, immature, err := UTXOSet{bc}.GetBalance(Ripmd160Hash(wallet.PublicKey))
+	assert.NoError(t, err)
+	assert.Equal(t, params.BlockSubsidy(0), balance+immature, "Genesis reward is in the UTXO set without a reindex")
+
+	reopened, err := OpenBlockchain(store)
+	assert.NoError(t, err)
+	assert.Equal(t, bc.tip, reopened.tip)
+	height, err := reopened.GetBestHeight()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, height)
+}
@@ -0,0 +1,85 @@
+/*
+	统计币的发行量
+	每个区块新发行的币 = coinbase交易输出总额 - 区块中交易的手续费（手续费只是转移，不是新发行的币）
+	所有区块发行量的总和应当等于UTXO集（chainstate）中所有未花费输出的总额
+ */
+package BlockInfo
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+//某个高度的区块的发行量信息
+type BlockSupply struct {
+	Height  int //区块高度
+	Subsidy int //按共识参数该高度允许的区块奖励
+	Issued  int //该区块实际新发行的币
+	Total   int //到该高度为止累计发行的币
+}
+
+/*
+	从创世纪块开始遍历区块链，计算每个高度的发行量
+	1、从链尾向前遍历收集区块，再按高度从低到高处理
+	2、记录每笔交易的输出值，用于计算后续交易输入引用的输出总额
+	3、区块发行量 = 区块中所有交易的输出总额 - 非coinbase交易的输入总额，即 coinbase交易输出总额 - 手续费
+	修剪模式或从快照启动的节点缺少之前的区块，返回ErrMissingHistory
+ */
+func (bc *Blockchain) CalculateSupply() ([]BlockSupply, error) {
+	pruneHeight, err := bc.PruneHeight()
+	if err != nil {
+		return nil, err
+	}
+	if pruneHeight > 0 {
+		return nil, ErrMissingHistory
+	}
+
+	var blocks []*Block
+	bci := bc.Iterator()
+
+	for {
+		block, err := bci.Next()
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	outputs := make(map[string][]TXOutput)
+	var supply []BlockSupply
+	total := 0
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
+		issued := 0
+
+		for _, tx := range block.Transactions {
+			outputValue, err := tx.OutputValue()
+			if err != nil {
+				return nil, err
+			}
+			issued += outputValue
+
+			if !tx.IsCoinbase() {
+				for _, vin := range tx.Vin {
+					prevOutputs, ok := outputs[hex.EncodeToString(vin.Txid)]
+					if !ok || vin.VoutIndex < 0 || vin.VoutIndex >= len(prevOutputs) {
+						return nil, fmt.Errorf("transaction %x spends an unknown output", tx.ID)
+					}
+					issued -= prevOutputs[vin.VoutIndex].Value
+				}
+			}
+
+			outputs[hex.EncodeToString(tx.ID)] = tx.Vout
+		}
+
+		total += issued
+		supply = append(supply, BlockSupply{block.Height, params.BlockSubsidy(block.Height), issued, total})
+	}
+
+	return supply, nil
+}
@@ -3,19 +3,13 @@ package BlockInfo
 import (
 	"bytes"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
-	"encoding/gob"
 	"encoding/hex"
 	"fmt"
-	"log"
-	"math/big"
 	"strings"
 )
 
-const subsidy  = 10
-
 //交易结构体，包括
 // 交易ID -- 将交易的输入和输出统一序列化后进行哈希
 // Vin — 交易输入数组
@@ -47,30 +41,32 @@ type TXOutput struct {
 	PubKeyHash		[]byte
 }
 
-type TXOutputs struct {
-	Outputs 	[]TXOutput
-}
-
+//将交易按规范二进制格式（见encoding.go）序列化，包含输入的签名
 func (tx Transaction) Serialize() []byte {
 	var encoded bytes.Buffer
 
-	enc := gob.NewEncoder(&encoded)
-	err := enc.Encode(tx)
-	if err != nil {
-		log.Panic(err)
-	}
+	tx.encode(&encoded)
 
 	return encoded.Bytes()
 }
 
+/*
+	计算交易ID
+	交易ID是在签名之前确定的，因此对去掉所有输入签名后的交易规范序列化数据进行哈希，
+	签名前后计算的结果相同
+ */
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
-	 txCopy := *tx
-	 txCopy.ID = []byte{}
+	txCopy := *tx
+	txCopy.ID = []byte{}
+	txCopy.Vin = make([]TXInput, len(tx.Vin))
+	for i, vin := range tx.Vin {
+		txCopy.Vin[i] = TXInput{vin.Txid, vin.VoutIndex, nil, vin.PubKey}
+	}
 
-	 hash = sha256.Sum256(txCopy.Serialize())
+	hash = sha256.Sum256(txCopy.Serialize())
 
-	 return hash[:]
+	return hash[:]
 }
 
 func (in *TXInput) UsesKey(pubKeyHash []byte) bool {
@@ -93,25 +89,29 @@ func (out *TXOutput) IsLockedWithKey(pubKeyHash []byte) bool {
 /*
 	生成Coinbase交易，用于在挖区块过程，给矿工的奖励
 	Coinbase交易没有输入，即指向的前一笔输入的交易Id为空、索引号为-1、签名为nil，公钥为数据信息
-	Coinbase交易的输出（奖励、接收者公钥哈希）
+	Coinbase交易的输出（奖励、接收者公钥哈希），奖励为高度height的区块奖励 + 区块中交易的手续费fees
  */
-func NewCoinbaseTX(to, data string) *Transaction  {
+func NewCoinbaseTX(to, data string, height, fees int) (*Transaction, error) {
+	if !ValidForAddress(to) {
+		return nil, ErrInvalidAddress
+	}
+
 	if data == "" {
 		//data = fmt.Sprintf("Reward to '%s'", to)
 		randData := make([]byte, 20)
 		_, err := rand.Read(randData)
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
 		data = fmt.Sprintf("%x", randData)
 	}
 
 	txin := TXInput{[]byte{}, -1, nil,[]byte(data)}
-	txout := NewTXOutput(subsidy, to)
+	txout := NewTXOutput(params.BlockSubsidy(height)+fees, to)
 	tx := Transaction{nil, []TXInput{txin}, []TXOutput{*txout}}
 	tx.ID = tx.Hash()
 
-	return &tx
+	return &tx, nil
 }
 
 //通过value、address信息构建交易输出结构体TXOutput{Value,PubKeyHash}
@@ -122,6 +122,20 @@ func NewTXOutput(value int, address string) *TXOutput  {
 	return txout
 }
 
+//计算交易所有输出的总额，输出金额或总额超出有效范围时返回ErrValueOutOfRange
+func (tx *Transaction) OutputValue() (int, error) {
+	value := 0
+	for _, out := range tx.Vout {
+		var err error
+		value, err = addMoney(value, out.Value)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return value, nil
+}
+
 // 判断某笔交易是否是Coinbase交易
 func (tx Transaction) IsCoinbase() bool {
 	return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].VoutIndex == -1
@@ -178,21 +192,36 @@ func NewTransaction(from, to string, amount int, bc *Blockchain) *Transaction  {
 }
 */
 
-func NewUTXOTransaction(wallet *Wallet, to string, amount int, utxoSet *UTXOSet) *Transaction {
+/*
+	在UTXO集基础上构建一笔从wallet到to的amount的交易，并支付fee的手续费
+	输入总额需要覆盖amount+fee，找零为 输入总额-amount-fee，手续费由打包该交易的矿工获得
+	amount不为正数、fee为负数或者超过最大发行总量时返回ErrInvalidAmount，可花费的输出不足时返回ErrInsufficientFunds
+ */
+func NewUTXOTransaction(wallet *Wallet, to string, amount, fee int, utxoSet *UTXOSet) (*Transaction, error) {
 	var inputs 	[]TXInput
 	var outputs	[]TXOutput
 
+	if !ValidForAddress(to) {
+		return nil, ErrInvalidAddress
+	}
+	if amount <= 0 || !moneyRange(amount) || !moneyRange(fee) {
+		return nil, ErrInvalidAmount
+	}
+
 	pubKeyHash := Ripmd160Hash(wallet.PublicKey)
-	acc, validOutputs := utxoSet.FindSpendableOutputs(pubKeyHash, amount)
+	acc, validOutputs, err := utxoSet.FindSpendableOutputs(pubKeyHash, amount+fee)
+	if err != nil {
+		return nil, err
+	}
 
-	if acc < amount {
-		log.Panic("ERROR：Not enough funds")
+	if acc < amount+fee {
+		return nil, ErrInsufficientFunds
 	}
 
 	for txid, outs := range validOutputs {
 		txIDStr, err := hex.DecodeString(txid)
 		if err != nil {
-			log.Panic(err)
+			return nil, err
 		}
 
 		for _, outIndex := range outs {
@@ -203,15 +232,18 @@ func NewUTXOTransaction(wallet *Wallet, to string, amount int, utxoSet *UTXOSet)
 
 	from := fmt.Sprintf("%s", wallet.GetAddress())
 	outputs = append(outputs, *NewTXOutput(amount, to))
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from))
+	if acc > amount+fee {
+		outputs = append(outputs, *NewTXOutput(acc-amount-fee, from))
 	}
 
 	tx := Transaction{nil, inputs, outputs}
 	tx.ID = tx.Hash()
-	utxoSet.Blockchain.SignTransaction(&tx, wallet.PrivateKey)
+	err = utxoSet.Blockchain.SignTransaction(&tx, wallet.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
 
-	return &tx
+	return &tx, nil
 }
 
 /*
@@ -266,46 +298,57 @@ func NewUTXOTransaction(from, to string, amount int, utxoSet *UTXOSet) *Transact
 */
 
 /*
-	通过私钥+交易输入引用的交易id-Transaction映射对交易进行签名
-	1、对交易的每笔输入进行遍历，判断其引用的交易是否在交易id-Transaction映射中
-	2、获取当前交易的修剪版的交易txCopy（清空了每笔输入的签名和公钥哈希）
-	3、对交易txCopy的每个输入遍历进行签名
-		3.1、先将当前需要签名的输入中的公钥置换为引用的交易输出的公钥哈希
-		3.2、交易txCopy的ID赋值为交易txCopy的哈希
-		3.3、将当前需要签名的输入中的公钥置为nil
-		3.4、对交易txCopy的ID（哈希）通过私钥生成签名
-		3.5、将生成的签名赋值到源交易中对应的输入下的Signature字段
+	通过私钥+交易输入引用的交易id-Transaction映射对交易进行签名，签名类型为SigHashAll
+ */
+func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) error {
+	return tx.SignWithType(privKey, prevTXs, SigHashAll)
+}
+
+/*
+	使用指定的签名类型对交易进行签名
+	1、对交易的每笔输入进行遍历，判断其引用的交易及输出是否在交易id-Transaction映射中，不在则返回ErrMissingInput
+	2、对每笔输入计算签名摘要（见sighash.go），通过私钥生成DER编码的签名并追加签名类型
+	3、将生成的签名赋值到交易中对应的输入下的Signature字段
  */
-func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction)  {
+func (tx *Transaction) SignWithType(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction, hashType SigHashType) error {
 	if tx.IsCoinbase() {
-		return
+		return nil
 	}
 
-	for _, vin := range tx.Vin {
-		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
-		}
+	err := tx.checkPrevTXs(prevTXs)
+	if err != nil {
+		return err
 	}
 
-	txCopy := tx.TrimmedCopy()
-
-	for index, vin := range txCopy.Vin {
+	for index, vin := range tx.Vin {
 		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
 
-		txCopy.Vin[index].Signature = nil
-		txCopy.Vin[index].PubKey = prevTx.Vout[vin.VoutIndex].PubKeyHash
-
-		dataToSign := fmt.Sprintf("%x\n", txCopy)
+		hash, err := tx.SignatureHash(index, prevTx.Vout[vin.VoutIndex], hashType)
+		if err != nil {
+			return err
+		}
 
-		r, s, err := ecdsa.Sign(rand.Reader, &privKey, []byte(dataToSign))
+		signature, err := signHash(&privKey, hash, hashType)
 		if err != nil {
-			log.Panic(err)
+			return err
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
 
 		tx.Vin[index].Signature = signature
-		txCopy.Vin[index].PubKey = nil
 	}
+
+	return nil
+}
+
+//检查交易的每笔输入引用的交易及输出都在交易id-Transaction映射中
+func (tx *Transaction) checkPrevTXs(prevTXs map[string]Transaction) error {
+	for _, vin := range tx.Vin {
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		if prevTx.ID == nil || vin.VoutIndex < 0 || vin.VoutIndex >= len(prevTx.Vout) {
+			return ErrMissingInput
+		}
+	}
+
+	return nil
 }
 
 //对交易结构体实现String()方法
@@ -359,101 +402,63 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 }
 
 /*
-	实现交易的签名验证
-	1、先验证交易输入是否有对应的引用交易
-	2、获取交易的修剪版交易
-	3、对交易的每笔输入进行签名验证
+	实现交易的签名验证，验证通过时返回nil
+	1、先验证交易输入是否有对应的引用交易及输出，没有则返回ErrMissingInput
+	2、验证输入中的公钥与引用输出的公钥哈希是否匹配
+	3、按签名中的签名类型计算签名摘要，使用SEC格式的公钥验证DER编码的签名
+	2、3中任何一项不通过都返回ErrInvalidSignature
  */
-func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
+func (tx *Transaction) Verify(prevTXs map[string]Transaction) error {
 	if tx.IsCoinbase() {
-		return true
+		return nil
 	}
 
-	for _, vin := range tx.Vin  {
-		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
-			log.Panic("ERROR: Previous transaction is not correct")
-		}
+	err := tx.checkPrevTXs(prevTXs)
+	if err != nil {
+		return err
 	}
 
-	fmt.Println("Previous transaction is correct")
-
-	//fmt.Printf("%x\n", tx.Serialize())
-	fmt.Println(tx)
-
-	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
-
 	for index, vin := range tx.Vin {
-		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
-		txCopy.Vin[index].Signature = nil
-		txCopy.Vin[index].PubKey = prevTx.Vout[vin.VoutIndex].PubKeyHash
-
-		//fmt.Printf("txCopy.Hash : %x\n", txCopy.Hash())
-		//txCopy.ID = txCopy.Hash()
-		//txCopy.Vin[index].PubKey = nil
-
-		r := big.Int{}
-		s := big.Int{}
-		sigLen := len(vin.Signature)
-		r.SetBytes(vin.Signature[:(sigLen/2)])
-		s.SetBytes(vin.Signature[(sigLen/2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		keyLen := len(vin.PubKey)
-		x.SetBytes(vin.PubKey[:(keyLen/2)])
-		y.SetBytes(vin.PubKey[(keyLen/2):])
-
-		/*fmt.Println(x)
-		fmt.Println(y)
-		fmt.Println(txCopy.ID)
-		fmt.Println(r)
-		fmt.Println(s)*/
-		dataToVerify := fmt.Sprintf("%x\n", txCopy)
-
-		rawPubKey := ecdsa.PublicKey{curve, &x, &y}
-		if ecdsa.Verify(&rawPubKey, []byte(dataToVerify), &r, &s) == false {
-			return false
-		}
-
-		txCopy.Vin[index].PubKey = nil
-	}
-
-	return true
-}
+		prevOutput := prevTXs[hex.EncodeToString(vin.Txid)].Vout[vin.VoutIndex]
 
-func (outs TXOutputs) Serialize() []byte {
-	var buff bytes.Buffer
+		if !vin.UsesKey(prevOutput.PubKeyHash) {
+			return ErrInvalidSignature
+		}
 
-	enc := gob.NewEncoder(&buff)
-	err := enc.Encode(outs)
-	if err != nil {
-		log.Panic(err)
-	}
+		pubKey, err := ParsePubKey(vin.PubKey)
+		if err != nil {
+			return ErrInvalidSignature
+		}
 
-	return buff.Bytes()
-}
+		signature, hashType, err := splitSignature(vin.Signature)
+		if err != nil {
+			return ErrInvalidSignature
+		}
 
-func DeserializeOutputs(data []byte) TXOutputs  {
-	var outputs TXOutputs
+		hash, err := tx.SignatureHash(index, prevOutput, hashType)
+		if err != nil {
+			return ErrInvalidSignature
+		}
 
-	dec := gob.NewDecoder(bytes.NewReader(data))
-	err := dec.Decode(&outputs)
-	if err != nil {
-		log.Panic(err)
+		if ecdsa.VerifyASN1(pubKey, hash, signature) == false {
+			return ErrInvalidSignature
+		}
 	}
 
-	return outputs
+	return nil
 }
 
-func DeserializeTransaction(data []byte) Transaction {
-	var transaction Transaction
+func DeserializeTransaction(data []byte) (Transaction, error) {
+	var transaction *Transaction
 
-	decoder := gob.NewDecoder(bytes.NewReader(data))
-	err := decoder.Decode(&transaction)
+	err := decodeAll(data, func(r *bytes.Reader) error {
+		var err error
+		transaction, err = decodeTransaction(r)
+		return err
+	})
 	if err != nil {
-		log.Panic(err)
+		return Transaction{}, err
 	}
 
-	return transaction
+	return *transaction, nil
 }
\ No newline at end of file
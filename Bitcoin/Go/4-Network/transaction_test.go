@@ -0,0 +1,29 @@
+package BlockInfo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewUTXOTransactionAmount(t *testing.T) {
+	params.CoinbaseMaturity = 1
+	defer func() { params.CoinbaseMaturity = 100 }()
+
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	address := string(wallet.GetAddress())
+	bc, err := CreateBlockchainInStore(address, NewMemoryStore())
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+	utxoSet := UTXOSet{bc}
+
+	//负数的金额或手续费会使找零超过输入总额
+	for _, c := range []struct{ amount, fee int }{{0, 1}, {-5, 1}, {3, -1}, {params.MaxSupply + 1, 0}} {
+		_, err = NewUTXOTransaction(wallet, address, c.amount, c.fee, &utxoSet)
+		assert.Equal(t, ErrInvalidAmount, err, "amount %d, fee %d", c.amount, c.fee)
+	}
+
+	_, err = NewUTXOTransaction(wallet, address, 3, 0, &utxoSet)
+	assert.NoError(t, err)
+}
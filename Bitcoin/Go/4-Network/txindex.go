@@ -0,0 +1,160 @@
+/*
+	交易索引（可选）
+	key为交易ID，value为 区块哈希（32个字节）+ uint32(交易在区块中的位置，小端序)
+	只有数据库中存在txindex这个Bucket时才启用，在区块链接到主链/从主链断开时维护，
+	启用后FindTransaction只需要一次查找即可定位交易，而不需要从链尾遍历所有区块
+ */
+package BlockInfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const txIndexBucket = "txindex"
+
+var ErrTransactionNotFound = notFound("transaction")
+
+//将区块中的交易写入交易索引，未启用交易索引时不做任何操作
+func putTxIndex(tx StoreTx, block *Block) error {
+	b := tx.Bucket([]byte(txIndexBucket))
+	if b == nil {
+		return nil
+	}
+
+	for i, transaction := range block.Transactions {
+		value := make([]byte, len(block.Hash)+4)
+		copy(value, block.Hash)
+		binary.LittleEndian.PutUint32(value[len(block.Hash):], uint32(i))
+
+		err := b.Put(transaction.ID, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//将区块中的交易从交易索引中删除，未启用交易索引时不做任何操作
+func deleteTxIndex(tx StoreTx, block *Block) error {
+	b := tx.Bucket([]byte(txIndexBucket))
+	if b == nil {
+		return nil
+	}
+
+	for _, transaction := range block.Transactions {
+		err := b.Delete(transaction.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//判断是否启用了交易索引
+func (bc *Blockchain) TxIndexEnabled() (bool, error) {
+	enabled := false
+
+	err := bc.Db.View(func(tx StoreTx) error {
+		enabled = tx.Bucket([]byte(txIndexBucket)) != nil
+		return nil
+	})
+
+	return enabled, err
+}
+
+/*
+	通过交易索引查找交易及其所在区块的区块头
+	返回的bool表示是否启用了交易索引，未启用时需要遍历区块链查找
+ */
+func (bc *Blockchain) findIndexedTransaction(ID []byte) (Transaction, *BlockHeader, bool, error) {
+	var transaction Transaction
+	var header *BlockHeader
+	enabled := false
+	found := false
+
+	err := bc.Db.View(func(tx StoreTx) error {
+		b := tx.Bucket([]byte(txIndexBucket))
+		if b == nil {
+			return nil
+		}
+		enabled = true
+
+		value := b.Get(ID)
+		if value == nil {
+			return nil
+		}
+		if len(value) < 4 {
+			return ErrMalformedData
+		}
+
+		blockHash := value[:len(value)-4]
+		position := int(binary.LittleEndian.Uint32(value[len(value)-4:]))
+
+		block, err := getBlock(tx, blockHash)
+		if err != nil && err != ErrBlockNotFound {
+			return err
+		}
+		if block == nil || position >= len(block.Transactions) || !bytes.Equal(block.Transactions[position].ID, ID) {
+			return fmt.Errorf("transaction index of %x is corrupted, run reindextx to rebuild it", ID)
+		}
+
+		transaction = *block.Transactions[position]
+		header = &block.BlockHeader
+		found = true
+		return nil
+	})
+
+	if err != nil {
+		return Transaction{}, nil, enabled, err
+	}
+	if enabled && !found {
+		return Transaction{}, nil, enabled, ErrTransactionNotFound
+	}
+
+	return transaction, header, enabled, nil
+}
+
+/*
+	启用并重建交易索引
+	1、删除并新建txindex的Bucket
+	2、从链尾遍历主链上的所有区块，将每个区块的交易写入交易索引
+ */
+func (bc *Blockchain) ReindexTransactions() (int, error) {
+	count := 0
+
+	err := bc.Db.Update(func(tx StoreTx) error {
+		err := tx.DeleteBucket([]byte(txIndexBucket))
+		if err != nil && err != ErrBucketNotFound {
+			return err
+		}
+		_, err = tx.CreateBucket([]byte(txIndexBucket))
+		if err != nil {
+			return err
+		}
+
+		hash := bc.tip
+		for len(hash) > 0 {
+			block, err := getBlock(tx, hash)
+			if err != nil {
+				return err
+			}
+
+			err = putTxIndex(tx, block)
+			if err != nil {
+				return err
+			}
+			count += len(block.Transactions)
+			hash = block.PrevBlockHash
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -0,0 +1,64 @@
+package BlockInfo
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxIndex(t *testing.T) {
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	address := string(wallet.GetAddress())
+	bc, err := CreateBlockchainInStore(address, NewMemoryStore())
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+
+	block1, err := bc.MineBlock([]*Transaction{newTestCoinbase(t, address, 1, 0)})
+	assert.NoError(t, err)
+
+	//未启用交易索引时遍历区块链查找
+	enabled, err := bc.TxIndexEnabled()
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+	_, header, err := bc.GetTransaction(block1.Transactions[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, block1.BlockHeader, *header)
+
+	count, err := bc.ReindexTransactions()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	enabled, err = bc.TxIndexEnabled()
+	assert.NoError(t, err)
+	assert.True(t, enabled)
+
+	//启用后新链接的区块也写入交易索引
+	block2, err := bc.MineBlock([]*Transaction{newTestCoinbase(t, address, 2, 0)})
+	assert.NoError(t, err)
+	for _, block := range []*Block{block1, block2} {
+		transaction, header, err := bc.GetTransaction(block.Transactions[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, block.Transactions[0].ID, transaction.ID)
+		assert.Equal(t, block.Height, header.Height)
+	}
+	_, err = bc.FindTransaction(make([]byte, 32))
+	assert.Equal(t, ErrTransactionNotFound, err)
+
+	//索引指向的位置与交易不符时报告索引损坏
+	err = bc.Db.Update(func(tx StoreTx) error {
+		value := make([]byte, len(block2.Hash)+4)
+		copy(value, block2.Hash)
+		binary.LittleEndian.PutUint32(value[len(block2.Hash):], 1)
+		return tx.Bucket([]byte(txIndexBucket)).Put(block1.Transactions[0].ID, value)
+	})
+	assert.NoError(t, err)
+	_, err = bc.FindTransaction(block1.Transactions[0].ID)
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrTransactionNotFound, err)
+
+	_, err = bc.ReindexTransactions()
+	assert.NoError(t, err)
+	_, err = bc.FindTransaction(block1.Transactions[0].ID)
+	assert.NoError(t, err, "Reindexing repairs the transaction index")
+}
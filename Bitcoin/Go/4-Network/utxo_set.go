@@ -1,180 +1,424 @@
 package BlockInfo
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"github.com/boltdb/bolt"
-	"log"
 )
 
 const utxoBucket  = "chainstate"
+const undoBucket  = "undo"
+//记录UTXO集对应的主链最后一个区块，与UTXO集在同一事务中修改
+const utxoStateBucket = "chainstateinfo"
+const utxoBestBlockKey = "best"
+//UTXO集中key的长度：交易ID（32个字节）+ 输出索引号（4个字节，大端序）
+const utxoKeyLen = 36
 
+var ErrNoUndoData = errors.New("undo data of block is not found")
 
 type UTXOSet struct {
 	Blockchain *Blockchain
 }
 
+/*
+	UTXO集中的一个未花费输出
+	以 交易ID + 输出索引号 作为key，每个输出单独保存，花费时只删除对应的一个key
+	Height为创建该输出的区块高度，Coinbase表示是否是coinbase交易的输出，用于判断是否已成熟
+ */
+type UTXOEntry struct {
+	Output   TXOutput
+	Height   int
+	Coinbase bool
+}
+
+//生成输出在UTXO集中的key，索引号采用大端序，使同一交易的输出在数据库中相邻且按索引号排列
+func utxoKey(txID []byte, index int) []byte {
+	key := make([]byte, 0, utxoKeyLen)
+	key = append(key, txID...)
+
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(index))
+
+	return append(key, b[:]...)
+}
+
+//从UTXO集的key中解析出交易ID和输出索引号
+func parseUTXOKey(key []byte) ([]byte, int) {
+	txIDLen := len(key) - 4
+
+	return key[:txIDLen], int(binary.BigEndian.Uint32(key[txIDLen:]))
+}
+
+/*
+	判断输出在高度为spendHeight的区块中是否可以被花费
+	coinbase交易的输出需要经过CoinbaseMaturity个区块才能花费，防止链重组使奖励消失后，花费它的交易也随之失效
+ */
+func (e UTXOEntry) IsMature(spendHeight int) bool {
+	return !e.Coinbase || spendHeight-e.Height >= params.CoinbaseMaturity
+}
+
+//将UTXO集中的输出序列化：int64(Value) | varbytes(PubKeyHash) | uint32(Height) | byte(Coinbase)
+func (e UTXOEntry) Serialize() []byte {
+	var buff bytes.Buffer
+
+	e.Output.encode(&buff)
+	writeUint32(&buff, uint32(e.Height))
+	if e.Coinbase {
+		buff.WriteByte(1)
+	} else {
+		buff.WriteByte(0)
+	}
+
+	return buff.Bytes()
+}
+
+func DeserializeUTXOEntry(data []byte) (UTXOEntry, error) {
+	var entry UTXOEntry
+
+	err := decodeAll(data, func(r *bytes.Reader) error {
+		var err error
+		if entry.Output, err = decodeTXOutput(r); err != nil {
+			return err
+		}
+
+		height, err := readUint32(r)
+		if err != nil {
+			return err
+		}
+		entry.Height = int(height)
+
+		coinbase, err := r.ReadByte()
+		if err != nil || coinbase > 1 {
+			return ErrMalformedData
+		}
+		entry.Coinbase = coinbase == 1
+		return nil
+	})
+	if err != nil {
+		return UTXOEntry{}, err
+	}
+
+	return entry, nil
+}
+
 /*
 	从UTXO集中查找对应公钥哈希和数量的可花费输出（int, map[string][]int）
-	1、读取数据库下的UTXO集
-	2、对UTXO集进行遍历，查询并返回符合条件的公钥哈希和大于要求数量amount的可花费输出
+	1、通过地址索引只遍历公钥哈希对应的输出
+	2、累计可花费输出的数量，达到amount后停止
+	3、在下一个区块中仍未成熟的coinbase输出不能花费，跳过
  */
-func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, map[string][]int)  {
+func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, map[string][]int, error) {
 	unspentOutputs := make(map[string][]int)
 	accumulated := 0
+	bestHeight, err := u.Blockchain.GetBestHeight()
+	if err != nil {
+		return 0, nil, err
+	}
+	spendHeight := bestHeight + 1
 	db := u.Blockchain.Db
 
-	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
-		c := b.Cursor()
+	err = db.View(func(tx StoreTx) error {
+		return forEachAddressUTXO(tx, pubkeyHash, func(key []byte, entry UTXOEntry) bool {
+			if !entry.IsMature(spendHeight) {
+				return true
+			}
 
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			txID := hex.EncodeToString(k)
-			outs := DeserializeOutputs(v)
+			txID, outIndex := parseUTXOKey(key)
+			accumulated += entry.Output.Value
+			unspentOutputs[hex.EncodeToString(txID)] = append(unspentOutputs[hex.EncodeToString(txID)], outIndex)
 
-			for outIndex, out := range outs.Outputs {
-				if out.IsLockedWithKey(pubkeyHash) && accumulated < amount {
-					accumulated += out.Value
-					unspentOutputs[txID] = append(unspentOutputs[txID], outIndex)
-				}
-			}
+			return accumulated < amount
+		})
+	})
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return accumulated, unspentOutputs, nil
+}
+
+/*
+	从UTXO集中查找交易txID下索引号为index的输出（包含创建区块高度和是否是coinbase交易）
+	若该输出不在UTXO集中（不存在或已被花费），则返回ErrOutputNotFound
+ */
+func (u UTXOSet) FindEntry(txID []byte, index int) (UTXOEntry, error) {
+	var entry UTXOEntry
+	db := u.Blockchain.Db
+
+	if index < 0 {
+		return entry, ErrOutputNotFound
+	}
+
+	err := db.View(func(tx StoreTx) error {
+		b := tx.Bucket([]byte(utxoBucket))
+		data := b.Get(utxoKey(txID, index))
+		if data == nil {
+			return ErrOutputNotFound
 		}
 
-		return nil
+		var err error
+		entry, err = DeserializeUTXOEntry(data)
+		return err
 	})
 
+	return entry, err
+}
+
+//从UTXO集中查找交易txID下索引号为index的输出，若该输出不在UTXO集中，则返回ErrOutputNotFound
+func (u UTXOSet) FindOutput(txID []byte, index int) (TXOutput, error) {
+	entry, err := u.FindEntry(txID, index)
+
+	return entry.Output, err
+}
+
+/*
+	计算交易的手续费，即交易输入引用的输出总额 - 交易输出总额
+	输入引用的输出不在UTXO集中、引用的coinbase输出在下一个区块中仍未成熟、金额超出有效范围，或输出总额超过输入总额时返回对应的错误
+ */
+func (u UTXOSet) TransactionFee(tx *Transaction) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, nil
+	}
+
+	bestHeight, err := u.Blockchain.GetBestHeight()
+	if err != nil {
+		return 0, err
+	}
+	spendHeight := bestHeight + 1
+	inputValue := 0
+	for _, vin := range tx.Vin {
+		entry, err := u.FindEntry(vin.Txid, vin.VoutIndex)
+		if err == ErrOutputNotFound {
+			return 0, ErrMissingInput
+		}
+		if err != nil {
+			return 0, err
+		}
+		if !entry.IsMature(spendHeight) {
+			return 0, ErrImmatureSpend
+		}
+		inputValue, err = addMoney(inputValue, entry.Output.Value)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	outputValue, err := tx.OutputValue()
 	if err != nil {
-		log.Panic(err)
+		return 0, err
+	}
+	fee := inputValue - outputValue
+	if fee < 0 {
+		return 0, ErrOutputsExceedInput
 	}
 
-	return accumulated, unspentOutputs
+	return fee, nil
 }
 
 /*
 	打印UTXO集下的交易信息（交易ID、地址、值）
  */
-func (u UTXOSet) PrintUTXO()  {
+func (u UTXOSet) PrintUTXO() error {
 	db := u.Blockchain.Db
 
-	err := db.View(func(tx *bolt.Tx) error {
+	return db.View(func(tx StoreTx) error {
 		b := tx.Bucket([]byte(utxoBucket))
 		c := b.Cursor()
 
+		var lastTxID []byte
 		for k, v := c.First(); k != nil; k, v = c.Next() {
-			outs := DeserializeOutputs(v)
-			fmt.Printf("--- Transaction %x：\n", k)
-			for _, out := range outs.Outputs {
-				fmt.Printf("address: %s ", PKHashToAddress(out.PubKeyHash))
-				fmt.Printf("value：'%d'\n", out.Value)
+			txID, outIndex := parseUTXOKey(k)
+			if !bytes.Equal(txID, lastTxID) {
+				fmt.Printf("--- Transaction %x：\n", txID)
+				lastTxID = append([]byte{}, txID...)
+			}
+
+			entry, err := DeserializeUTXOEntry(v)
+			if err != nil {
+				return err
 			}
+			fmt.Printf("out: %d ", outIndex)
+			fmt.Printf("address: %s ", PKHashToAddress(entry.Output.PubKeyHash))
+			fmt.Printf("value：'%d'\n", entry.Output.Value)
 		}
 		return nil
 	})
+}
+
+/*
+	统计公钥哈希对应的余额，分为已成熟（可以花费）和未成熟（coinbase输出尚未经过CoinbaseMaturity个区块）两部分
+ */
+func (u UTXOSet) GetBalance(pubKeyHash []byte) (int, int, error) {
+	mature, immature := 0, 0
+	bestHeight, err := u.Blockchain.GetBestHeight()
+	if err != nil {
+		return 0, 0, err
+	}
+	spendHeight := bestHeight + 1
+	db := u.Blockchain.Db
+
+	err = db.View(func(tx StoreTx) error {
+		return forEachAddressUTXO(tx, pubKeyHash, func(key []byte, entry UTXOEntry) bool {
+			if entry.IsMature(spendHeight) {
+				mature += entry.Output.Value
+			} else {
+				immature += entry.Output.Value
+			}
+			return true
+		})
+	})
 
 	if err != nil {
-		log.Panic(err)
+		return 0, 0, err
 	}
+
+	return mature, immature, nil
 }
 
-/*
-	在UTXO集中查找指定公钥哈希对应的输出集（[]TXOutput）
- */
-func (u UTXOSet) FindUTXO(pubKeyHash []byte) []TXOutput  {
-	var UTXOs []TXOutput
+//统计UTXO集中所有未花费输出的总额
+func (u UTXOSet) TotalValue() (int, error) {
 	db := u.Blockchain.Db
+	total := 0
 
-	err := db.View(func(tx *bolt.Tx) error {
+	err := db.View(func(tx StoreTx) error {
 		b := tx.Bucket([]byte(utxoBucket))
 		c := b.Cursor()
 
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			outs := DeserializeOutputs(v)
-			fmt.Printf("--- Transaction %x：\n", k)
-			for _, out := range outs.Outputs {
-				fmt.Printf("address: %s ", PKHashToAddress(out.PubKeyHash))
-				fmt.Printf("value：'%d'\n", out.Value)
-				if out.IsLockedWithKey(pubKeyHash) {
-					//fmt.Println(fmt.Sprintf("--- Transaction %x：", tx.ID))
-					UTXOs = append(UTXOs, out)
-				}
+		for _, v := c.First(); v != nil; _, v = c.Next() {
+			entry, err := DeserializeUTXOEntry(v)
+			if err != nil {
+				return err
 			}
+			total += entry.Output.Value
 		}
 		return nil
 	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+/*
+	通过地址索引在UTXO集中查找指定公钥哈希对应的输出集（[]TXOutput）
+ */
+func (u UTXOSet) FindUTXO(pubKeyHash []byte) ([]TXOutput, error) {
+	var UTXOs []TXOutput
+	db := u.Blockchain.Db
+
+	err := db.View(func(tx StoreTx) error {
+		return forEachAddressUTXO(tx, pubKeyHash, func(key []byte, entry UTXOEntry) bool {
+			UTXOs = append(UTXOs, entry.Output)
+			return true
+		})
+	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
 
-	return UTXOs
+	return UTXOs, nil
 }
 
 /*
-	计算UTXO集中包含的交易数（交易ID对应的key）
+	计算UTXO集中包含的交易数
+	同一交易的输出在数据库中相邻，因此只需要统计交易ID变化的次数
  */
-func (u UTXOSet) CountTransactions() int {
+func (u UTXOSet) CountTransactions() (int, error) {
 	db := u.Blockchain.Db
 	counter := 0
 
-	err := db.View(func(tx *bolt.Tx) error {
+	err := db.View(func(tx StoreTx) error {
 		b := tx.Bucket([]byte(utxoBucket))
 		c := b.Cursor()
 
+		var lastTxID []byte
 		for k, _ := c.First(); k != nil; k, _ = c.Next() {
-			counter++
+			txID, _ := parseUTXOKey(k)
+			if !bytes.Equal(txID, lastTxID) {
+				counter++
+				lastTxID = append([]byte{}, txID...)
+			}
 		}
 
 		return nil
 	})
 
 	if err != nil {
-		log.Panic(err)
+		return 0, err
 	}
 
-	return counter
+	return counter, nil
 }
 
 /*
-	从区块链数据库中读取区块交易，重新生成UTXO集，并更新数据库中
-	1、删除后并新建区块链数据库下Bucket为utxoBucket的数据
-	2、查找数据库下所有的未花费输出（map[string]TXOutputs  交易Id-输出结构体分组）
-	3、将上步查找的结果保存进行数据库
-	注意事项：当一个新的区块链被创建以后，就会立刻进行重建索引。目前，
-	这是 Reindex 唯一使用的地方，即使这里看起来有点“杀鸡用牛刀”，因为一条链开始的时候，
-	只有一个块，里面只有一笔交易，Update 已经被使用了。不过我们在未来可能需要重建索引的机制。
+	从区块链数据库中读取区块交易，重新生成UTXO集，只在UTXO集损坏或缺失时用于恢复，
+	节点正常运行时UTXO集随区块的链接和断开增量更新
  */
-func (u UTXOSet) Reindex()  {
+func (u UTXOSet) Reindex() error {
+	return u.ReindexWithProgress(nil)
+}
+
+/*
+	重新生成UTXO集，每应用一个区块后调用progress(区块高度, 链尾高度)报告进度，progress可以为nil
+	1、删除后并新建区块链数据库下Bucket为utxoBucket、addrIndexBucket的数据，并删除所有撤销数据及区块数据占用空间的记录
+	2、从链尾沿前一个区块哈希找到主链上的所有区块
+	3、从创世区块开始依次将区块应用到UTXO集（同时建立地址索引和撤销数据），UTXO集对应的区块最终为当前链尾
+	整个过程在一个事务中完成，中途失败时原来的UTXO集保持不变
+	修剪模式或从快照启动的节点缺少之前的区块，返回ErrMissingHistory
+ */
+func (u UTXOSet) ReindexWithProgress(progress func(height, bestHeight int)) error {
 	db := u.Blockchain.Db
-	bucketName := []byte(utxoBucket)
 
-	err := db.Update(func(tx *bolt.Tx) error {
-		err := tx.DeleteBucket(bucketName)
-		if err != nil && err != bolt.ErrBucketNotFound {
-			log.Panic(err)
+	return db.Update(func(tx StoreTx) error {
+		if getPruneHeight(tx) > 0 {
+			return ErrMissingHistory
 		}
-
-		_, err = tx.CreateBucket(bucketName)
+		for _, bucketName := range []string{utxoBucket, addrIndexBucket, undoBucket} {
+			err := tx.DeleteBucket([]byte(bucketName))
+			if err != nil && err != ErrBucketNotFound {
+				return err
+			}
+		}
+		err := resetStoredSize(tx)
 		if err != nil {
-			log.Panic(err)
+			return err
+		}
+		for _, bucketName := range []string{utxoBucket, addrIndexBucket} {
+			_, err := tx.CreateBucket([]byte(bucketName))
+			if err != nil {
+				return err
+			}
 		}
 
-		return nil
-	})
-	if err != nil {
-		log.Panic(err)
-	}
-
-	UTXO := u.Blockchain.FindUTXO()
-	err = db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(bucketName)
-		for txID, outs := range UTXO {
-			key, err := hex.DecodeString(txID)
+		var hashes [][]byte
+		for hash := u.Blockchain.tip; len(hash) > 0; {
+			header, err := getBlockHeader(tx, hash)
 			if err != nil {
-				log.Panic(err)
+				return err
 			}
+			hashes = append(hashes, hash)
+			hash = header.PrevBlockHash
+		}
 
-			err = b.Put(key, outs.Serialize())
+		bestHeight := len(hashes) - 1
+		for i := bestHeight; i >= 0; i-- {
+			block, err := getBlock(tx, hashes[i])
+			if err != nil {
+				return err
+			}
+			err = connectUTXO(tx, block)
 			if err != nil {
-				log.Panic(err)
+				return err
+			}
+
+			if progress != nil {
+				progress(block.Height, bestHeight)
 			}
 		}
 		return nil
@@ -184,61 +428,272 @@ func (u UTXOSet) Reindex()  {
 /*
 	将区块参数中的交易进行遍历更新数据库中UTXO集
 	当挖出一个新块时，应该更新 UTXO 集。更新意味着移除已花费输出，并从新挖出来的交易中加入未花费输出。
+	在独立的事务中执行connectUTXO，链接主链区块时由connectBlock在与区块、链尾相同的事务中执行
+ */
+func (u UTXOSet) Update(block *Block) error {
+	return u.Blockchain.Db.Update(func(tx StoreTx) error {
+		return connectUTXO(tx, block)
+	})
+}
+
+/*
+	在事务中将区块的交易应用到UTXO集
 	1、获取数据库中为chainstate的Bucket对象
 	2、对区块中的交易进行遍历
-	3、若非coinbase交易，则将交易的输入所引用交易输出从UTXO集删除
-	4、将交易的输出更新至UTXO集（不管是不是coinabase交易）
+	3、若非coinbase交易，则删除交易的每个输入所引用的输出对应的key及其地址索引
+	4、将交易的每个输出以 交易ID + 输出索引号 为key保存进UTXO集并建立地址索引（不管是不是coinabase交易）
+	5、在修改UTXO集之前记录每个被修改的key原来的值，作为区块的撤销数据保存进undo，用于回滚区块
+	6、将UTXO集对应的区块记录为该区块
  */
-func (u UTXOSet) Update(block *Block)  {
-	db := u.Blockchain.Db
-
-	err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(utxoBucket))
-
-		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, vin := range tx.Vin {
-					updateOuts := TXOutputs{}
-					outsBytes := b.Get(vin.Txid)
-					outs := DeserializeOutputs(outsBytes)
-
-					for outIndex, out := range outs.Outputs {
-						//比较的值感觉有点问题，找个时间好好排查
-						if outIndex != vin.VoutIndex {
-							updateOuts.Outputs = append(updateOuts.Outputs, out)
-						}
-					}
+func connectUTXO(dbTx StoreTx, block *Block) error {
+	b := dbTx.Bucket([]byte(utxoBucket))
+	undo := newBlockUndo()
+
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() == false {
+			for _, vin := range tx.Vin {
+				key := utxoKey(vin.Txid, vin.VoutIndex)
+				data := b.Get(key)
+				if data == nil {
+					continue
+				}
+				undo.record(key, data)
 
-					if len(updateOuts.Outputs) == 0 {
-						err := b.Delete(vin.Txid)
-						if err != nil {
-							log.Panic(err)
-						}
-					} else {
-						err := b.Put(vin.Txid, updateOuts.Serialize())
-						if err != nil {
-							log.Panic(err)
-						}
-					}
+				err := deleteUTXO(dbTx, key)
+				if err != nil {
+					return err
 				}
 			}
+		}
 
-			newOutputs := TXOutputs{}
-			for _, out := range tx.Vout {
-				newOutputs.Outputs = append(newOutputs.Outputs, out)
-			}
+		for outIndex, out := range tx.Vout {
+			key := utxoKey(tx.ID, outIndex)
+			undo.record(key, b.Get(key))
 
-			fmt.Printf("Update UTXO for block: %x\n", tx.ID)
-			err := b.Put(tx.ID, newOutputs.Serialize())
+			err := putUTXO(dbTx, key, UTXOEntry{out, block.Height, tx.IsCoinbase()})
 			if err != nil {
-				log.Panic(err)
+				return err
 			}
 		}
+	}
+
+	ub, err := dbTx.CreateBucketIfNotExists([]byte(undoBucket))
+	if err != nil {
+		return err
+	}
+	data, err := undo.Serialize()
+	if err != nil {
+		return err
+	}
+	err = addStoredSize(dbTx, len(data)-len(ub.Get(block.Hash)))
+	if err != nil {
+		return err
+	}
+	err = ub.Put(block.Hash, data)
+	if err != nil {
+		return err
+	}
+
+	return putUTXOBestBlock(dbTx, block.Hash)
+}
+
+//将区块从UTXO集中回滚，即Update的逆操作
+func (u UTXOSet) Disconnect(block *Block) error {
+	return u.Blockchain.Db.Update(func(tx StoreTx) error {
+		return disconnectUTXO(tx, block)
+	})
+}
+
+/*
+	在事务中将区块从UTXO集中回滚，即connectUTXO的逆操作，用于链重组时断开主链上的区块
+	1、读取区块的撤销数据
+	2、将撤销数据中记录的每个key恢复为区块链接之前的值（原来不存在的key则删除），从而恢复被花费的输出、删除区块创建的输出，
+	   地址索引随之恢复
+	3、删除区块的撤销数据，并将UTXO集对应的区块记录为前一个区块
+ */
+func disconnectUTXO(tx StoreTx, block *Block) error {
+	ub := tx.Bucket([]byte(undoBucket))
+	if ub == nil || ub.Get(block.Hash) == nil {
+		return ErrNoUndoData
+	}
+	undo, err := DeserializeBlockUndo(ub.Get(block.Hash))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range undo.Entries {
+		err := deleteUTXO(tx, entry.Key)
+		if err != nil {
+			return err
+		}
+		if entry.Value == nil {
+			continue
+		}
+
+		utxo, err := DeserializeUTXOEntry(entry.Value)
+		if err != nil {
+			return err
+		}
+		err = putUTXO(tx, entry.Key, utxo)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = addStoredSize(tx, -len(ub.Get(block.Hash)))
+	if err != nil {
+		return err
+	}
+	err = ub.Delete(block.Hash)
+	if err != nil {
+		return err
+	}
 
+	return putUTXOBestBlock(tx, block.PrevBlockHash)
+}
+
+//记录UTXO集对应的主链最后一个区块
+func putUTXOBestBlock(tx StoreTx, hash []byte) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(utxoStateBucket))
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte(utxoBestBlockKey), hash)
+}
+
+//读取UTXO集对应的区块哈希，没有记录（旧数据库）时返回nil
+func getUTXOBestBlock(tx StoreTx) []byte {
+	b := tx.Bucket([]byte(utxoStateBucket))
+	if b == nil {
+		return nil
+	}
+
+	hash := b.Get([]byte(utxoBestBlockKey))
+	if hash == nil {
+		return nil
+	}
+
+	return append([]byte{}, hash...)
+}
+
+/*
+	启动时检查UTXO集是否与链尾一致
+	1、UTXO集对应的区块就是链尾时不需要处理
+	2、UTXO集对应的区块是链尾之前的主链区块时，将之后的区块依次重新应用到UTXO集
+	3、没有记录（旧数据库）或者对应的区块不在主链上时，根据主链重建UTXO集，
+	   修剪模式或从快照启动的节点没有重建所需的全部区块，返回ErrMissingHistory，需要重新加载UTXO快照
+ */
+func (bc *Blockchain) ensureUTXOSet() error {
+	var best []byte
+	err := bc.Db.View(func(tx StoreTx) error {
+		best = getUTXOBestBlock(tx)
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(best, bc.tip) {
+		return nil
+	}
+
+	u := UTXOSet{bc}
+	if best != nil {
+		bestIndex, err := bc.getBlockIndex(best)
+		if err != nil {
+			return err
+		}
+		tipHeight, err := bc.GetBestHeight()
+		if err != nil {
+			return err
+		}
+
+		if bestIndex != nil && bestIndex.Height < tipHeight {
+			//从链尾往前取出UTXO集之后的区块
+			blocks := make([]*Block, tipHeight-bestIndex.Height)
+			bci := bc.Iterator()
+			for i := len(blocks) - 1; i >= 0; i-- {
+				blocks[i], err = bci.Next()
+				if err != nil {
+					return err
+				}
+			}
+
+			if bytes.Equal(blocks[0].PrevBlockHash, best) {
+				fmt.Printf("Replaying %d blocks to the UTXO set...\n", len(blocks))
+				for _, block := range blocks {
+					err := u.Update(block)
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+		}
+	}
+
+	pruneHeight, err := bc.PruneHeight()
+	if err != nil {
+		return err
+	}
+	if pruneHeight > 0 {
+		return fmt.Errorf("UTXO set does not match the chain tip and cannot be rebuilt: %w, load a UTXO snapshot with loadutxo into a new blockchain", ErrMissingHistory)
+	}
+
+	fmt.Println("Rebuilding UTXO set...")
+	return u.Reindex()
+}
+
+/*
+	区块的撤销数据
+	记录区块链接时UTXO集中每个被修改的key（交易ID + 输出索引号）在修改之前的值，Value为nil表示该key原来不存在
+ */
+type BlockUndo struct {
+	Entries []UndoEntry
+	seen    map[string]bool
+}
 
+type UndoEntry struct {
+	Key   []byte
+	Value []byte
+}
+
+func newBlockUndo() *BlockUndo {
+	return &BlockUndo{nil, make(map[string]bool)}
+}
+
+//记录key在区块修改之前的值，同一个key只记录第一次
+func (undo *BlockUndo) record(key, value []byte) {
+	if undo.seen[string(key)] {
+		return
+	}
+	undo.seen[string(key)] = true
+
+	var prev []byte
+	if value != nil {
+		prev = make([]byte, len(value))
+		copy(prev, value)
+	}
+	undo.Entries = append(undo.Entries, UndoEntry{append([]byte{}, key...), prev})
+}
+
+func (undo BlockUndo) Serialize() ([]byte, error) {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	err := enc.Encode(undo)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
+
+	return buff.Bytes(), nil
+}
+
+func DeserializeBlockUndo(data []byte) (BlockUndo, error) {
+	var undo BlockUndo
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&undo)
+
+	return undo, err
 }
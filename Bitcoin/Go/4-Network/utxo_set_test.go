@@ -0,0 +1,248 @@
+package BlockInfo
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoinbaseMaturity(t *testing.T) {
+	params.CoinbaseMaturity = 2
+	defer func() { params.CoinbaseMaturity = 100 }()
+
+	entry := UTXOEntry{Height: 5, Coinbase: true}
+	assert.False(t, entry.IsMature(6))
+	assert.True(t, entry.IsMature(7))
+	entry.Coinbase = false
+	assert.True(t, entry.IsMature(5), "Outputs of normal transactions are always mature")
+
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	address := string(wallet.GetAddress())
+	pubKeyHash := Ripmd160Hash(wallet.PublicKey)
+	bc, err := CreateBlockchainInStore(address, NewMemoryStore())
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+	genesis, err := bc.GetBlock(bc.tip)
+	assert.NoError(t, err)
+
+	block1, err := bc.MineBlock([]*Transaction{newTestCoinbase(t, address, 1, 0)})
+	assert.NoError(t, err)
+	utxoSet := UTXOSet{bc}
+
+	//高度2的区块中创世块的奖励已成熟，高度1的奖励还未成熟
+	mature, immature, err := utxoSet.GetBalance(pubKeyHash)
+	assert.NoError(t, err)
+	assert.Equal(t, params.BlockSubsidy(0), mature)
+	assert.Equal(t, params.BlockSubsidy(1), immature)
+
+	accumulated, outputs, err := utxoSet.FindSpendableOutputs(pubKeyHash, 2*params.BlockSubsidy(0))
+	assert.NoError(t, err)
+	assert.Equal(t, params.BlockSubsidy(0), accumulated, "Immature coinbase outputs are not spendable")
+	assert.Equal(t, map[string][]int{hex.EncodeToString(genesis.Transactions[0].ID): {0}}, outputs)
+
+	_, err = NewUTXOTransaction(wallet, address, params.BlockSubsidy(0), 1, &utxoSet)
+	assert.Equal(t, ErrInsufficientFunds, err)
+
+	immatureSpend := &Transaction{nil, []TXInput{{block1.Transactions[0].ID, 0, nil, wallet.PublicKey}}, []TXOutput{*NewTXOutput(1, address)}}
+	_, err = utxoSet.TransactionFee(immatureSpend)
+	assert.Equal(t, ErrImmatureSpend, err)
+
+	_, err = bc.MineBlock([]*Transaction{newTestCoinbase(t, address, 2, 0)})
+	assert.NoError(t, err)
+	mature, immature, err = utxoSet.GetBalance(pubKeyHash)
+	assert.NoError(t, err)
+	assert.Equal(t, params.BlockSubsidy(0)+params.BlockSubsidy(1), mature)
+	assert.Equal(t, params.BlockSubsidy(2), immature)
+	fee, err := utxoSet.TransactionFee(immatureSpend)
+	assert.NoError(t, err)
+	assert.Equal(t, params.BlockSubsidy(1)-1, fee)
+}
+
+func TestUTXOEntry(t *testing.T) {
+	txID := make([]byte, 32)
+	txID[0] = 0xab
+	key := utxoKey(txID, 258)
+	assert.Len(t, key, utxoKeyLen)
+	id, index := parseUTXOKey(key)
+	assert.Equal(t, txID, id)
+	assert.Equal(t, 258, index)
+	assert.True(t, string(utxoKey(txID, 1)) < string(utxoKey(txID, 256)), "Outputs of a transaction are ordered by index")
+
+	entry := UTXOEntry{TXOutput{50, []byte{1, 2, 3}}, 7, true}
+	decoded, err := DeserializeUTXOEntry(entry.Serialize())
+	assert.NoError(t, err)
+	assert.Equal(t, entry, decoded)
+
+	data := entry.Serialize()
+	data[len(data)-1] = 2
+	_, err = DeserializeUTXOEntry(data)
+	assert.Equal(t, ErrMalformedData, err)
+	_, err = DeserializeUTXOEntry(entry.Serialize()[:5])
+	assert.Equal(t, ErrMalformedData, err)
+}
+
+func TestUTXOSetOutpoints(t *testing.T) {
+	params.CoinbaseMaturity = 1
+	defer func() { params.CoinbaseMaturity = 100 }()
+
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	address := string(wallet.GetAddress())
+	bc, err := CreateBlockchainInStore(address, NewMemoryStore())
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+	utxoSet := UTXOSet{bc}
+
+	//spend有转账和找零两个输出，只花费其中一个时另一个仍在UTXO集中
+	spend, err := NewUTXOTransaction(wallet, address, 3, 1, &utxoSet)
+	assert.NoError(t, err)
+	_, err = bc.MineBlock([]*Transaction{newTestCoinbase(t, address, 1, 1), spend})
+	assert.NoError(t, err)
+
+	entry, err := utxoSet.FindEntry(spend.ID, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, UTXOEntry{spend.Vout[0], 1, false}, entry)
+	_, err = utxoSet.FindEntry(spend.ID, 2)
+	assert.Equal(t, ErrOutputNotFound, err)
+	_, err = utxoSet.FindEntry(spend.ID, -1)
+	assert.Equal(t, ErrOutputNotFound, err)
+
+	partial := &Transaction{nil, []TXInput{{spend.ID, 0, nil, wallet.PublicKey}}, []TXOutput{*NewTXOutput(2, address)}}
+	partial.ID = partial.Hash()
+	assert.NoError(t, bc.SignTransaction(partial, wallet.PrivateKey))
+	_, err = bc.MineBlock([]*Transaction{newTestCoinbase(t, address, 2, 1), partial})
+	assert.NoError(t, err)
+
+	_, err = utxoSet.FindOutput(spend.ID, 0)
+	assert.Equal(t, ErrOutputNotFound, err)
+	output, err := utxoSet.FindOutput(spend.ID, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, spend.Vout[1], output)
+
+	count, err := utxoSet.CountTransactions()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count, "Coinbase transactions of blocks 1 and 2, spend and partial")
+}
+
+func TestEnsureUTXOSet(t *testing.T) {
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	address := string(wallet.GetAddress())
+	store := NewMemoryStore()
+	bc, err := CreateBlockchainInStore(address, store)
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+	var blocks []*Block
+	for height := 1; height <= 2; height++ {
+		block, err := bc.MineBlock([]*Transaction{newTestCoinbase(t, address, height, 0)})
+		assert.NoError(t, err)
+		blocks = append(blocks, block)
+	}
+
+	bestBlock := func() []byte {
+		var best []byte
+		assert.NoError(t, store.View(func(tx StoreTx) error {
+			best = getUTXOBestBlock(tx)
+			return nil
+		}))
+		return best
+	}
+	assert.Equal(t, bc.tip, bestBlock(), "Tip and UTXO set are updated in the same transaction")
+
+	check := func() {
+		bc, err := OpenBlockchain(store)
+		assert.NoError(t, err)
+		assert.Equal(t, bc.tip, bestBlock())
+		for _, block := range blocks {
+			_, err := UTXOSet{bc}.FindEntry(block.Transactions[0].ID, 0)
+			assert.NoError(t, err)
+		}
+		total, err := UTXOSet{bc}.TotalValue()
+		assert.NoError(t, err)
+		assert.Equal(t, params.BlockSubsidy(0)*3, total)
+	}
+
+	//UTXO集落后于链尾时，打开时重新应用之后的区块
+	utxoSet := UTXOSet{bc}
+	assert.NoError(t, utxoSet.Disconnect(blocks[1]))
+	assert.NoError(t, utxoSet.Disconnect(blocks[0]))
+	assert.Equal(t, blocks[0].PrevBlockHash, bestBlock())
+	check()
+
+	//旧数据库没有记录UTXO集对应的区块时，根据主链重建UTXO集
+	err = store.Update(func(tx StoreTx) error {
+		err := tx.Bucket([]byte(utxoBucket)).Delete(utxoKey(blocks[0].Transactions[0].ID, 0))
+		if err != nil {
+			return err
+		}
+		return tx.DeleteBucket([]byte(utxoStateBucket))
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, bestBlock())
+	check()
+}
+
+func TestReindexWithProgress(t *testing.T) {
+	params.CoinbaseMaturity = 1
+	defer func() { params.CoinbaseMaturity = 100 }()
+
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	address := string(wallet.GetAddress())
+	bc, err := CreateBlockchainInStore(address, NewMemoryStore())
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+	utxoSet := UTXOSet{bc}
+
+	//收到的区块增量更新UTXO集
+	spend, err := NewUTXOTransaction(wallet, address, 3, 1, &utxoSet)
+	assert.NoError(t, err)
+	block1 := newTestBlock(t, bc, bc.tip, []*Transaction{newTestCoinbase(t, address, 1, 1), spend})
+	assert.NoError(t, acceptBlock(nil, block1, bc))
+	block2, err := bc.MineBlock([]*Transaction{newTestCoinbase(t, address, 2, 0)})
+	assert.NoError(t, err)
+	_, err = utxoSet.FindEntry(spend.ID, 1)
+	assert.NoError(t, err)
+
+	dump := func() map[string]string {
+		contents := make(map[string]string)
+		assert.NoError(t, bc.Db.View(func(tx StoreTx) error {
+			for _, bucketName := range []string{utxoBucket, addrIndexBucket} {
+				err := tx.Bucket([]byte(bucketName)).ForEach(func(k, v []byte) error {
+					contents[bucketName+string(k)] = string(v)
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+		return contents
+	}
+	incremental := dump()
+
+	//回滚再重新应用区块后UTXO集不变
+	assert.NoError(t, utxoSet.Disconnect(block2))
+	_, err = utxoSet.FindEntry(block2.Transactions[0].ID, 0)
+	assert.Equal(t, ErrOutputNotFound, err)
+	assert.NoError(t, utxoSet.Update(block2))
+	assert.Equal(t, incremental, dump())
+
+	var progress [][2]int
+	err = utxoSet.ReindexWithProgress(func(height, bestHeight int) {
+		progress = append(progress, [2]int{height, bestHeight})
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]int{{0, 2}, {1, 2}, {2, 2}}, progress)
+	assert.Equal(t, incremental, dump(), "Reindexing produces the same UTXO set as incremental updates")
+
+	//重建失败时原来的UTXO集保持不变
+	assert.NoError(t, bc.Db.Update(func(tx StoreTx) error {
+		return tx.Bucket([]byte(blocksBucket)).Delete(block1.Hash)
+	}))
+	assert.Error(t, utxoSet.Reindex())
+	assert.Equal(t, incremental, dump())
+}
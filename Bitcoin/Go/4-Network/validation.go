@@ -0,0 +1,343 @@
+/*
+	区块验证
+	在区块被写入数据库之前，对区块进行完整的验证，任何一项不通过都返回对应的错误，而不是直接panic
+ */
+package BlockInfo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+//区块的时间戳必须晚于前medianTimeBlocks个区块时间戳的中位数，且不能晚于当前时间maxFutureBlockTime秒以上
+const medianTimeBlocks = 11
+const maxFutureBlockTime = 2 * 60 * 60
+
+//区块验证失败的原因
+var (
+	ErrInvalidPoW         = errors.New("proof of work is invalid")
+	ErrBadBlockHash       = errors.New("block hash does not match its header")
+	ErrBadMerkleRoot      = errors.New("merkle root does not match block transactions")
+	ErrPrevBlockNotFound  = errors.New("previous block is not found")
+	ErrPrevBlockMismatch  = errors.New("previous block is not the chain tip")
+	ErrPrevBlockInvalid   = errors.New("previous block is invalid")
+	ErrBadHeight          = errors.New("block height does not follow previous block")
+	ErrNotGenesisBlock    = errors.New("block is not a genesis block")
+	ErrBadDifficulty      = errors.New("block target does not match the expected difficulty")
+	ErrTimeTooOld         = errors.New("block timestamp is not after the median time of previous blocks")
+	ErrTimeTooNew         = errors.New("block timestamp is too far in the future")
+	ErrNoTransactions     = errors.New("block has no transactions")
+	ErrNoCoinbase         = errors.New("block has no coinbase transaction")
+	ErrMultipleCoinbase   = errors.New("block has more than one coinbase transaction")
+	ErrBadCoinbaseValue   = errors.New("coinbase pays more than the block subsidy plus fees")
+	ErrBlockTooLarge      = errors.New("block exceeds the maximum block size")
+	ErrBadTransactionID   = errors.New("transaction ID does not match its content")
+	ErrMissingInput       = errors.New("transaction input references an unknown or spent output")
+	ErrDoubleSpend        = errors.New("output is spent more than once in the block")
+	ErrNegativeValue      = errors.New("transaction output value is negative")
+	ErrValueOutOfRange    = errors.New("transaction value exceeds the maximum supply")
+	ErrOutputsExceedInput = errors.New("transaction outputs exceed its inputs")
+	ErrImmatureSpend      = errors.New("transaction spends an immature coinbase output")
+	ErrInvalidSignature   = errors.New("transaction signature is invalid")
+)
+
+//区块验证错误，包含未通过验证的区块哈希和具体原因
+type BlockValidationError struct {
+	Hash []byte
+	Err  error
+}
+
+func (e *BlockValidationError) Error() string {
+	return fmt.Sprintf("block %x is invalid: %v", e.Hash, e.Err)
+}
+
+func (e *BlockValidationError) Unwrap() error {
+	return e.Err
+}
+
+func invalidBlock(block *Block, err error) error {
+	return &BlockValidationError{block.Hash, err}
+}
+
+/*
+	不依赖区块链状态的区块验证，区块在保存进数据库（包括侧链区块）之前都要通过
+	1、工作量证明有效，且区块哈希与区块头一致，时间戳不晚于当前时间maxFutureBlockTime秒以上
+	2、区块头中的Merkle根与区块中的交易一致
+	3、区块序列化后的大小不超过maxBlockSize
+	4、有且只有一笔coinbase交易
+	5、每笔交易的ID与其内容一致，输出的值不为负数，单个输出及输出总额都不超过最大发行总量MaxSupply
+ */
+func CheckBlock(block *Block) error {
+	pow := NewProofOfWork(block)
+	if !bytes.Equal(pow.Hash(), block.Hash) {
+		return invalidBlock(block, ErrBadBlockHash)
+	}
+	if !pow.Validate() {
+		return invalidBlock(block, ErrInvalidPoW)
+	}
+	err := checkBlockTime(&block.BlockHeader, nil, nil)
+	if err != nil {
+		return invalidBlock(block, err)
+	}
+	if !bytes.Equal(block.HashTransactions(), block.MerkleRoot) {
+		return invalidBlock(block, ErrBadMerkleRoot)
+	}
+
+	if len(block.Serialize()) > maxBlockSize {
+		return invalidBlock(block, ErrBlockTooLarge)
+	}
+
+	err = checkCoinbase(block)
+	if err != nil {
+		return invalidBlock(block, err)
+	}
+
+	for _, tx := range block.Transactions {
+		if !bytes.Equal(tx.Hash(), tx.ID) {
+			return invalidBlock(block, ErrBadTransactionID)
+		}
+
+		for _, out := range tx.Vout {
+			if out.Value < 0 {
+				return invalidBlock(block, ErrNegativeValue)
+			}
+			if out.Value > params.MaxSupply {
+				return invalidBlock(block, ErrValueOutOfRange)
+			}
+		}
+		_, err = tx.OutputValue()
+		if err != nil {
+			return invalidBlock(block, err)
+		}
+	}
+
+	return nil
+}
+
+/*
+	对将要链接到当前链尾的区块进行完整验证
+	1、通过CheckBlock的验证
+	2、前一个区块存在，并且是当前链的最后一个区块，区块高度为前一区块高度+1，时间戳晚于前面区块的中位时间
+	3、每笔非coinbase交易的输入都引用UTXO集中或区块内排在它之前的交易创建的未花费输出，
+	   且同一输出在区块内只被花费一次，引用的coinbase交易输出必须已经成熟
+	4、每笔交易的输出总额不超过输入总额，且输入的签名都验证通过
+	5、coinbase交易的输出总额不超过 该高度的区块奖励（见params.go） + 区块中所有交易的手续费
+ */
+func (bc *Blockchain) ValidateBlock(block *Block) error {
+	err := CheckBlock(block)
+	if err != nil {
+		return err
+	}
+
+	prevHeader, err := bc.GetBlockHeader(block.PrevBlockHash)
+	if err != nil {
+		return invalidBlock(block, ErrPrevBlockNotFound)
+	}
+	if !bytes.Equal(block.PrevBlockHash, bc.tip) {
+		return invalidBlock(block, ErrPrevBlockMismatch)
+	}
+	if block.Height != prevHeader.Height+1 {
+		return invalidBlock(block, ErrBadHeight)
+	}
+	parent, err := bc.getBlockIndex(block.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+	err = checkBlockTime(&block.BlockHeader, parent, bc.getBlockIndex)
+	if err != nil {
+		return invalidBlock(block, err)
+	}
+
+	utxoSet := UTXOSet{bc}
+	spent := make(map[string]bool)
+	//区块内已验证的交易创建的输出，排在后面的交易可以花费
+	created := make(map[string]UTXOEntry)
+	var coinbase *Transaction
+	fees := 0
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			coinbase = tx
+		} else {
+			fee, err := validateInputs(tx, block, utxoSet, spent, created)
+			if err != nil {
+				return err
+			}
+			fees, err = addMoney(fees, fee)
+			if err != nil {
+				return invalidBlock(block, err)
+			}
+		}
+
+		for outIndex, out := range tx.Vout {
+			created[string(utxoKey(tx.ID, outIndex))] = UTXOEntry{out, block.Height, tx.IsCoinbase()}
+		}
+	}
+
+	coinbaseValue, err := coinbase.OutputValue()
+	if err != nil {
+		return invalidBlock(block, err)
+	}
+	if coinbaseValue > params.BlockSubsidy(block.Height)+fees {
+		return invalidBlock(block, ErrBadCoinbaseValue)
+	}
+
+	return nil
+}
+
+/*
+	验证区块中一笔非coinbase交易的输入和签名，返回交易的手续费
+	引用的输出先在created（区块内之前的交易创建的输出）中查找，再在UTXO集中查找，花费的输出记录在spent中
+ */
+func validateInputs(tx *Transaction, block *Block, utxoSet UTXOSet, spent map[string]bool, created map[string]UTXOEntry) (int, error) {
+	prevTXs := make(map[string]Transaction)
+	inputValue := 0
+	for _, vin := range tx.Vin {
+		outpoint := string(utxoKey(vin.Txid, vin.VoutIndex))
+		if spent[outpoint] {
+			return 0, invalidBlock(block, ErrDoubleSpend)
+		}
+		spent[outpoint] = true
+
+		entry, ok := created[outpoint]
+		if !ok {
+			var err error
+			entry, err = utxoSet.FindEntry(vin.Txid, vin.VoutIndex)
+			if err == ErrOutputNotFound {
+				return 0, invalidBlock(block, ErrMissingInput)
+			}
+			if err != nil {
+				return 0, err
+			}
+		}
+		if !entry.IsMature(block.Height) {
+			return 0, invalidBlock(block, ErrImmatureSpend)
+		}
+		var err error
+		inputValue, err = addMoney(inputValue, entry.Output.Value)
+		if err != nil {
+			return 0, invalidBlock(block, err)
+		}
+		addPrevOutput(prevTXs, vin, entry.Output)
+	}
+
+	outputValue, err := tx.OutputValue()
+	if err != nil {
+		return 0, invalidBlock(block, err)
+	}
+	if outputValue > inputValue {
+		return 0, invalidBlock(block, ErrOutputsExceedInput)
+	}
+
+	err = tx.Verify(prevTXs)
+	if err == ErrMissingInput || err == ErrInvalidSignature {
+		return 0, invalidBlock(block, err)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return inputValue - outputValue, nil
+}
+
+//检查区块中有且只有一笔coinbase交易，其奖励是否合理需要结合交易手续费在ValidateBlock中检查
+func checkCoinbase(block *Block) error {
+	if len(block.Transactions) == 0 {
+		return ErrNoTransactions
+	}
+
+	var coinbase *Transaction
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			continue
+		}
+		if coinbase != nil {
+			return ErrMultipleCoinbase
+		}
+		coinbase = tx
+	}
+
+	if coinbase == nil {
+		return ErrNoCoinbase
+	}
+
+	return nil
+}
+
+/*
+	计算parent及其之前共medianTimeBlocks个区块时间戳的中位数（median time past），区块不足medianTimeBlocks个时取已有区块的中位数
+	通过lookup查找祖先区块的索引，与nextBits相同
+ */
+func medianTimePast(parent *blockIndex, lookup func(hash []byte) (*blockIndex, error)) (int64, error) {
+	var timestamps []int64
+
+	index := parent
+	for {
+		timestamps = append(timestamps, index.Timestamp)
+		if len(timestamps) == medianTimeBlocks || len(index.PrevHash) == 0 {
+			break
+		}
+
+		prev, err := lookup(index.PrevHash)
+		if err != nil {
+			return 0, err
+		}
+		if prev == nil {
+			return 0, ErrPrevBlockNotFound
+		}
+		index = prev
+	}
+
+	sort.Slice(timestamps, func(i, j int) bool {
+		return timestamps[i] < timestamps[j]
+	})
+
+	return timestamps[len(timestamps)/2], nil
+}
+
+/*
+	检查区块头的时间戳，不通过时返回ErrTimeTooNew或ErrTimeTooOld
+	1、不晚于当前时间maxFutureBlockTime秒以上
+	2、parent不为nil时，必须晚于parent及其之前区块的中位时间，使矿工无法任意设置时间戳来降低难度
+ */
+func checkBlockTime(header *BlockHeader, parent *blockIndex, lookup func(hash []byte) (*blockIndex, error)) error {
+	if header.Timestamp > time.Now().Unix()+maxFutureBlockTime {
+		return ErrTimeTooNew
+	}
+	if parent == nil {
+		return nil
+	}
+
+	mtp, err := medianTimePast(parent, lookup)
+	if err != nil {
+		return err
+	}
+	if header.Timestamp <= mtp {
+		return ErrTimeTooOld
+	}
+
+	return nil
+}
+
+//金额是否在有效范围内：不为负数，且不超过最大发行总量
+func moneyRange(value int) bool {
+	return value >= 0 && value <= params.MaxSupply
+}
+
+/*
+	将金额value累加到total上，value或累加结果超出有效范围时返回ErrValueOutOfRange
+	两者都不超过MaxSupply，相加不会溢出，交易和区块中的金额都通过它累加
+ */
+func addMoney(total, value int) (int, error) {
+	if !moneyRange(value) {
+		return 0, ErrValueOutOfRange
+	}
+	total += value
+	if !moneyRange(total) {
+		return 0, ErrValueOutOfRange
+	}
+
+	return total, nil
+}
@@ -0,0 +1,178 @@
+package BlockInfo
+
+import (
+	"encoding/hex"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//以parent为父区块挖出包含txs的区块，只进行工作量证明，不加入区块链
+func newTestBlock(t *testing.T, bc *Blockchain, parent []byte, txs []*Transaction) *Block {
+	index, err := bc.getBlockIndex(parent)
+	assert.NoError(t, err)
+	bits, err := bc.nextBits(index)
+	assert.NoError(t, err)
+	timestamp, err := bc.nextBlockTime(index)
+	assert.NoError(t, err)
+
+	return newBlockAt(txs, parent, index.Height+1, bits, timestamp)
+}
+
+func newTestCoinbase(t *testing.T, address string, height, fees int) *Transaction {
+	coinbase, err := NewCoinbaseTX(address, "", height, fees)
+	assert.NoError(t, err)
+
+	return coinbase
+}
+
+func TestValidateBlock(t *testing.T) {
+	params.CoinbaseMaturity = 2
+	defer func() { params.CoinbaseMaturity = 100 }()
+
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	address := string(wallet.GetAddress())
+	bc, err := CreateBlockchainInStore(address, NewMemoryStore())
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+	genesis := bc.tip
+
+	block1, err := bc.MineBlock([]*Transaction{newTestCoinbase(t, address, 1, 0)})
+	assert.NoError(t, err)
+	utxoSet := UTXOSet{bc}
+
+	//创世块的奖励在高度2成熟，高度1的奖励还未成熟
+	spend, err := NewUTXOTransaction(wallet, address, 3, 1, &utxoSet)
+	assert.NoError(t, err)
+
+	validate := func(txs ...*Transaction) error {
+		return bc.ValidateBlock(newTestBlock(t, bc, bc.tip, txs))
+	}
+
+	err = validate(newTestCoinbase(t, address, 2, 1), spend)
+	assert.NoError(t, err, "Block spending a mature output is valid")
+
+	err = bc.ValidateBlock(newTestBlock(t, bc, genesis, []*Transaction{newTestCoinbase(t, address, 1, 0)}))
+	assert.ErrorIs(t, err, ErrPrevBlockMismatch)
+
+	err = validate(newTestCoinbase(t, address, 2, 2), spend)
+	assert.ErrorIs(t, err, ErrBadCoinbaseValue, "Coinbase cannot take more than the subsidy plus fees")
+
+	//与spend花费同一输出的另一笔交易
+	conflict, err := NewUTXOTransaction(wallet, address, 4, 1, &utxoSet)
+	assert.NoError(t, err)
+	err = validate(newTestCoinbase(t, address, 2, 2), spend, conflict)
+	assert.ErrorIs(t, err, ErrDoubleSpend)
+
+	immature := &Transaction{nil, []TXInput{{block1.Transactions[0].ID, 0, nil, wallet.PublicKey}}, []TXOutput{*NewTXOutput(1, address)}}
+	immature.ID = immature.Hash()
+	assert.NoError(t, bc.SignTransaction(immature, wallet.PrivateKey))
+	err = validate(newTestCoinbase(t, address, 2, 0), immature)
+	assert.ErrorIs(t, err, ErrImmatureSpend)
+
+	badSignature := *spend
+	badSignature.Vin = append([]TXInput{}, spend.Vin...)
+	badSignature.Vin[0].Signature = append([]byte{}, spend.Vin[0].Signature...)
+	badSignature.Vin[0].Signature[10] ^= 1
+	err = validate(newTestCoinbase(t, address, 2, 1), &badSignature)
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	//花费区块内前一笔交易创建的输出
+	chained := &Transaction{nil, []TXInput{{spend.ID, 0, nil, wallet.PublicKey}}, []TXOutput{*NewTXOutput(2, address)}}
+	chained.ID = chained.Hash()
+	assert.NoError(t, chained.Sign(wallet.PrivateKey, map[string]Transaction{hex.EncodeToString(spend.ID): *spend}))
+
+	err = validate(newTestCoinbase(t, address, 2, 2), chained, spend)
+	assert.ErrorIs(t, err, ErrMissingInput, "Outputs created later in the block cannot be spent")
+
+	block := newTestBlock(t, bc, bc.tip, []*Transaction{newTestCoinbase(t, address, 2, 2), spend, chained})
+	assert.NoError(t, bc.ValidateBlock(block), "Outputs created earlier in the block can be spent")
+	_, err = bc.AddBlock(block)
+	assert.NoError(t, err)
+
+	_, err = utxoSet.FindEntry(spend.ID, 0)
+	assert.Equal(t, ErrOutputNotFound, err, "Output spent in the same block is not in the UTXO set")
+	entry, err := utxoSet.FindEntry(chained.ID, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, entry.Output.Value)
+}
+
+func TestValueOutOfRange(t *testing.T) {
+	params.CoinbaseMaturity = 1
+	defer func() { params.CoinbaseMaturity = 100 }()
+
+	wallet, err := NewWallet()
+	assert.NoError(t, err)
+	address := string(wallet.GetAddress())
+	bc, err := CreateBlockchainInStore(address, NewMemoryStore())
+	assert.NoError(t, err)
+	defer bc.Db.Close()
+	genesis, err := bc.GetBlock(bc.tip)
+	assert.NoError(t, err)
+	utxoSet := UTXOSet{bc}
+
+	//花费创世块奖励的交易，outputs为各输出的金额
+	spendGenesis := func(outputs ...int) *Transaction {
+		tx := &Transaction{nil, []TXInput{{genesis.Transactions[0].ID, 0, nil, wallet.PublicKey}}, nil}
+		for _, value := range outputs {
+			tx.Vout = append(tx.Vout, *NewTXOutput(value, address))
+		}
+		tx.ID = tx.Hash()
+		assert.NoError(t, bc.SignTransaction(tx, wallet.PrivateKey))
+
+		return tx
+	}
+
+	//输出总额回绕后恰好等于输入的10个币
+	overflow := spendGenesis(math.MaxInt, math.MaxInt, 12)
+	_, err = utxoSet.TransactionFee(overflow)
+	assert.ErrorIs(t, err, ErrValueOutOfRange)
+	block := newTestBlock(t, bc, bc.tip, []*Transaction{newTestCoinbase(t, address, 1, 0), overflow})
+	assert.ErrorIs(t, CheckBlock(block), ErrValueOutOfRange)
+	assert.ErrorIs(t, bc.ValidateBlock(block), ErrValueOutOfRange)
+
+	//单个输出不超过最大发行总量，但总额超过
+	tooLarge := spendGenesis(params.MaxSupply, params.MaxSupply)
+	_, err = utxoSet.TransactionFee(tooLarge)
+	assert.ErrorIs(t, err, ErrValueOutOfRange)
+	block = newTestBlock(t, bc, bc.tip, []*Transaction{newTestCoinbase(t, address, 1, 0), tooLarge})
+	assert.ErrorIs(t, bc.ValidateBlock(block), ErrValueOutOfRange)
+
+	fee, err := utxoSet.TransactionFee(spendGenesis(4, 5))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fee)
+}
+
+func TestCheckBlockTime(t *testing.T) {
+	//时间戳依次为 1000, 1010, ..., 1200 的21个区块
+	chain := make(map[string]*blockIndex)
+	var parent *blockIndex
+	for height := 0; height <= 20; height++ {
+		index := &blockIndex{Hash: []byte{byte(height)}, Height: height, Timestamp: int64(1000 + 10*height)}
+		if parent != nil {
+			index.PrevHash = parent.Hash
+		}
+		chain[string(index.Hash)] = index
+		parent = index
+	}
+	lookup := func(hash []byte) (*blockIndex, error) {
+		return chain[string(hash)], nil
+	}
+
+	mtp, err := medianTimePast(parent, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1150), mtp, "Median of the last 11 timestamps")
+	mtp, err = medianTimePast(chain[string([]byte{1})], lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1010), mtp, "Median of all timestamps near the genesis block")
+
+	assert.Equal(t, ErrTimeTooOld, checkBlockTime(&BlockHeader{Timestamp: 1150}, parent, lookup))
+	assert.NoError(t, checkBlockTime(&BlockHeader{Timestamp: 1151}, parent, lookup), "Timestamp may be earlier than the parent's")
+
+	future := time.Now().Unix() + maxFutureBlockTime + 60
+	assert.Equal(t, ErrTimeTooNew, checkBlockTime(&BlockHeader{Timestamp: future}, parent, lookup))
+	assert.Equal(t, ErrTimeTooNew, checkBlockTime(&BlockHeader{Timestamp: future}, nil, nil))
+}
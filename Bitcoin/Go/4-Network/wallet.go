@@ -8,7 +8,6 @@ import (
 	"crypto/sha256"
 
 	"golang.org/x/crypto/ripemd160"
-	"log"
 )
 
 const version  = byte(0x00)     //定义版本号，一个字节
@@ -20,22 +19,26 @@ type Wallet struct {
 	PublicKey []byte				//公钥
 }
 
-//通过椭圆曲线加密算法生成私钥，私钥产生公钥
-func newKeyPair() (ecdsa.PrivateKey, []byte) {
+//通过椭圆曲线加密算法生成私钥，私钥产生公钥，公钥采用SEC压缩格式编码
+func newKeyPair() (ecdsa.PrivateKey, []byte, error) {
 	curve := elliptic.P256()
 	private, err := ecdsa.GenerateKey(curve, rand.Reader)
 	if err != nil {
-		log.Panic(err)
+		return ecdsa.PrivateKey{}, nil, err
 	}
-	publicKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	publicKey := EncodePubKey(&private.PublicKey, true)
 
-	return *private, publicKey
+	return *private, publicKey, nil
 }
 
 //创建钱包下的密钥
-func NewWallet() *Wallet  {
-	privateKey, publicKey := newKeyPair()
-	return &Wallet{privateKey, publicKey}
+func NewWallet() (*Wallet, error) {
+	privateKey, publicKey, err := newKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Wallet{privateKey, publicKey}, nil
 }
 
 //获取钱包下密钥对应的比特币地址
@@ -83,6 +86,10 @@ func CheckSum(payload []byte) []byte  {
 //判断比特币地址是否有效
 func ValidForAddress(address string) bool  {
 	version_publicKeyHash_checkSumBytes := Base58Decode([]byte(address))
+	//至少包含版本号、公钥哈希和校验码，过短的地址直接视为无效
+	if len(version_publicKeyHash_checkSumBytes) <= 1+addressChecksumLen {
+		return false
+	}
 
 	checkSumBytes := version_publicKeyHash_checkSumBytes[len(version_publicKeyHash_checkSumBytes)-addressChecksumLen:]
 	//fmt.Println("checkSumBytes: ", checkSumBytes)
@@ -6,7 +6,6 @@ import (
 	"encoding/gob"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 )
 
@@ -41,7 +40,7 @@ func (ws *Wallets) LoadWalletsFromFile(nodeID string) error {
 
 	fileContent, err := ioutil.ReadFile(walletFile)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	var wallets Wallets
@@ -49,7 +48,7 @@ func (ws *Wallets) LoadWalletsFromFile(nodeID string) error {
 	decoder := gob.NewDecoder(bytes.NewReader(fileContent))
 	err = decoder.Decode(&wallets)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
 	ws.Wallets = wallets.Wallets
@@ -61,13 +60,16 @@ func (ws *Wallets) LoadWalletsFromFile(nodeID string) error {
 	1、创建一组钱包信息，即私钥-公钥-地址
 	2、将创建的钱包信息添加到钱包集ws.Wallets中
  */
-func (ws *Wallets) CreateWallet()  string {
-	wallet := NewWallet()
+func (ws *Wallets) CreateWallet() (string, error) {
+	wallet, err := NewWallet()
+	if err != nil {
+		return "", err
+	}
 	address := fmt.Sprintf("%s", wallet.GetAddress())
 
 	ws.Wallets[address] = wallet
 
-	return address
+	return address, nil
 }
 
 /*
@@ -81,15 +83,20 @@ func (ws *Wallets) GetAddresses() []string {
 	return addresses
 }
 
-//获取当前钱包集下地址为address下的钱包信息，即私钥-公钥对
-func (ws Wallets) GetWallet(address string) Wallet {
-	return *ws.Wallets[address]
+//获取当前钱包集下地址为address下的钱包信息，即私钥-公钥对，钱包集中没有该地址时返回ErrWalletNotFound
+func (ws Wallets) GetWallet(address string) (Wallet, error) {
+	wallet, ok := ws.Wallets[address]
+	if !ok {
+		return Wallet{}, ErrWalletNotFound
+	}
+
+	return *wallet, nil
 }
 
 /*
 	将当前钱包集的内容序列化，并保存进行钱包文件wallet.dat
  */
-func (ws Wallets) SaveToFile(nodeID string)  {
+func (ws Wallets) SaveToFile(nodeID string) error {
 	walletFile := fmt.Sprintf(walletFile, nodeID)
 
 	var content bytes.Buffer
@@ -99,11 +106,8 @@ func (ws Wallets) SaveToFile(nodeID string)  {
 	encoder := gob.NewEncoder(&content)
 	err := encoder.Encode(ws)
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
 
-	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
-	if err != nil {
-		log.Panic(err)
-	}
+	return ioutil.WriteFile(walletFile, content.Bytes(), 0644)
 }
\ No newline at end of file